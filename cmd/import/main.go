@@ -0,0 +1,88 @@
+// Command import moves links exported from other shorteners into the service storage on behalf of a user.
+//
+//	import -file links.csv -user <user id> [-format csv|json|ndjson] [-on-conflict rename|skip]
+//
+// Storage is configured the same way as for the service.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/config"
+	"github.com/DrGermanius/Shortener/internal/app/handlers"
+	"github.com/DrGermanius/Shortener/internal/app/importer"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+	"github.com/DrGermanius/Shortener/internal/store"
+)
+
+func main() {
+	file := flag.String("file", "", "file to import")
+	uid := flag.String("user", "", "id of the user who will own the links")
+	format := flag.String("format", "", "format of the file: csv, json or ndjson; detected by extension by default")
+	onConflict := flag.String("on-conflict", importer.OnConflictRename, "what to do with taken aliases: rename or skip")
+
+	c, err := config.NewConfig()
+	if err != nil {
+		log.Fatalf("can't initialize config: %v", err)
+	}
+	if *file == "" || *uid == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(*file), ".")
+	}
+
+	zapl, err := zap.NewProduction()
+	if err != nil {
+		log.Fatalf("can't initialize zap logger: %v", err)
+	}
+	defer zapl.Sync()
+	logger := zapl.Sugar()
+
+	f, err := os.Open(*file)
+	if err != nil {
+		logger.Fatalf("can't open import file: %v", err)
+	}
+	defer f.Close()
+
+	rows, err := importer.Parse(f, *format)
+	if err != nil {
+		logger.Fatalf("can't read import file: %v", err)
+	}
+
+	storager, err := store.New(c.ConnectionString, logger)
+	if err != nil {
+		logger.Fatalf("can't initialize store: %v", err)
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	wp := app.NewWorkerPool(ctx, logger)
+	h := handlers.NewHandlers(storager, wp, logger, ctx)
+
+	out := json.NewEncoder(os.Stdout)
+	failed := 0
+	err = h.Import(ctx, *uid, rows, *onConflict, func(results []models.JobResult) {
+		for _, r := range results {
+			if r.Error != "" {
+				failed++
+			}
+			_ = out.Encode(r)
+		}
+	})
+	if err != nil {
+		logger.Fatalf("import interrupted: %v", err)
+	}
+	logger.Infof("imported %d of %d links", len(rows)-failed, len(rows))
+}
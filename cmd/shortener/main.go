@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -17,10 +18,14 @@ import (
 	"github.com/DrGermanius/Shortener/internal/app"
 	"github.com/DrGermanius/Shortener/internal/app/config"
 	"github.com/DrGermanius/Shortener/internal/app/handlers"
+	"github.com/DrGermanius/Shortener/internal/app/health"
 	ml "github.com/DrGermanius/Shortener/internal/app/middlewares"
 	"github.com/DrGermanius/Shortener/internal/store"
 )
 
+// shutdownTimeout limits the time background writers are waited for on shutdown.
+const shutdownTimeout = 10 * time.Second
+
 var (
 	buildVersion = "N/A"
 	buildDate    = "N/A"
@@ -48,9 +53,18 @@ func main() {
 	if err != nil {
 		logger.Fatalf("can't initialize store: %v", err)
 	}
+	n, err := storager.InterruptJobs(context.Background())
+	if err != nil {
+		logger.Fatalf("can't interrupt unfinished jobs: %v", err)
+	}
+	if n > 0 {
+		logger.Infof("%d unfinished jobs were interrupted by restart", n)
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	wp := app.NewWorkerPool(ctx, logger)
+	go app.StartExpirySweeper(ctx, storager.DeleteExpired, logger)
+	go health.NewChecker(storager, logger).Run(ctx)
 
 	h := handlers.NewHandlers(storager, wp, logger, ctx)
 
@@ -62,14 +76,41 @@ func main() {
 	r.Mount("/debug", middleware.Profiler())
 
 	r.Get("/{id}", h.GetShortLinkHandler)
+	r.Get("/{id}/*", h.GetShortLinkHandler)
+	r.Head("/{id}", h.GetShortLinkHandler)
+	r.Head("/{id}/*", h.GetShortLinkHandler)
 	r.Get("/api/user/urls", h.GetUserUrlsHandler)
+	r.Get("/api/user/urls/broken", h.BrokenLinksHandler)
+	r.Get("/api/user/urls/export", h.ExportLinksHandler)
+	r.Get("/api/user/urls/stream", h.StreamHandler)
+	r.Get("/api/user/urls/import/{id}", h.ImportJobHandler)
+	r.Get("/api/user/urls/{id}/rules", h.GetRulesHandler)
+	r.Get("/api/user/urls/{id}/history", h.LinkHistoryHandler)
+	r.Get("/api/user/urls/{id}/stats", h.LinkStatsHandler)
+	r.Get("/api/user/urls/{id}/stats/timeseries", h.LinkTimeSeriesHandler)
+	r.Get("/api/user/webhooks", h.WebhooksHandler)
+	r.Get("/api/user/webhooks/{id}/deliveries", h.WebhookDeliveriesHandler)
+	r.Get("/api/jobs/{id}", h.JobHandler)
+	r.Get("/api/internal/stats", h.InternalStatsHandler)
+	r.Get("/api/qr/{id}", h.QRHandler)
 	r.Get("/ping", h.PingDatabaseHandler)
 
 	r.Post("/", h.AddShortLinkHandler)
+	r.Post("/{id}", h.UnlockLinkHandler)
+	r.Post("/{id}/*", h.UnlockLinkHandler)
 	r.Post("/api/shorten", h.ShortenHandler)
 	r.Post("/api/shorten/batch", h.BatchHandler)
+	r.Post("/api/user/urls/{id}/rollback", h.RollbackLinkHandler)
+	r.Post("/api/user/urls/import", h.ImportLinksHandler)
+	r.Post("/api/user/webhooks", h.CreateWebhookHandler)
+	r.Post("/api/user/webhooks/{id}/deliveries/{delivery}/retry", h.RetryDeliveryHandler)
+
+	r.Put("/api/user/urls/{id}/rules", h.SetRulesHandler)
+
+	r.Patch("/api/user/urls/{id}", h.EditLinkHandler)
 
 	r.Delete("/api/user/urls", h.DeleteLinksHandler)
+	r.Delete("/api/user/webhooks/{id}", h.DeleteWebhookHandler)
 
 	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, app.ErrMethodNotAllowed.Error(), http.StatusMethodNotAllowed)
@@ -100,4 +141,10 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	<-quit
 	log.Println("Shutting down service...")
+	cancel()
+	select {
+	case <-h.Done():
+	case <-time.After(shutdownTimeout):
+		logger.Errorf("clicks and events weren't saved in %v", shutdownTimeout)
+	}
 }
@@ -3,7 +3,11 @@ package auth
 import (
 	"crypto/hmac"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/DrGermanius/Shortener/internal/app"
 	"github.com/DrGermanius/Shortener/internal/app/config"
@@ -13,6 +17,9 @@ import (
 
 const AuthCookie = "UserID"
 
+// LinkAccessCookiePrefix prefixes names of cookies granting access to password-protected links.
+const LinkAccessCookiePrefix = "LinkAccess_"
+
 func CheckSignature(s string) (string, error) {
 	if len(s) < 36 {
 		return "", app.ErrInvalidSignature
@@ -56,3 +63,31 @@ func calculateSignature(b []byte) ([]byte, error) {
 	res := h.Sum(nil)
 	return []byte(hex.EncodeToString(res)), nil
 }
+
+// GetLinkAccess returns a value granting access to the password-protected link until exp.
+// The password hash takes part in the signature, so changing the password revokes issued values.
+func GetLinkAccess(short, passwordHash string, exp time.Time) string {
+	e := strconv.FormatInt(exp.Unix(), 10)
+	return e + "." + linkAccessSignature(short, passwordHash, e)
+}
+
+// CheckLinkAccess reports whether the value grants access to the password-protected link at the moment.
+func CheckLinkAccess(short, passwordHash, v string) bool {
+	parts := strings.SplitN(v, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	exp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || time.Now().Unix() >= exp {
+		return false
+	}
+
+	return hmac.Equal([]byte(parts[1]), []byte(linkAccessSignature(short, passwordHash, parts[0])))
+}
+
+func linkAccessSignature(short, passwordHash, exp string) string {
+	h := hmac.New(sha256.New, []byte(config.Config().AuthKey))
+	h.Write([]byte(short + "|" + passwordHash + "|" + exp))
+	return hex.EncodeToString(h.Sum(nil))
+}
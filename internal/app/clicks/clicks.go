@@ -0,0 +1,218 @@
+// Package clicks records redirects made by short links without delaying them.
+package clicks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Shortener/internal/app/config"
+	"github.com/DrGermanius/Shortener/internal/app/geoip"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+const (
+	bufferSize    = 4096
+	flushSize     = 500
+	flushInterval = time.Second
+	// maxFieldLength bounds client supplied values saved with clicks.
+	maxFieldLength = 1024
+	// pruneInterval is how often rollups older than their retention are deleted.
+	pruneInterval = time.Hour
+)
+
+// Store is the part of the links storage clicks are saved to.
+type Store interface {
+	AddClicks(ctx context.Context, clicks []models.Click) error
+	DeleteRollups(ctx context.Context, interval string, before time.Time) (int64, error)
+	DeleteVisitorSketches(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Visit is a redirect to be recorded.
+type Visit struct {
+	Short     string
+	IP        string
+	Referrer  string
+	UserAgent string
+	Time      time.Time
+}
+
+// Recorder saves clicks to the store in batches; visits are dropped if the store can't keep up.
+type Recorder struct {
+	visits  chan Visit
+	store   Store
+	geo     *geoip.DB
+	key     []byte
+	logger  *zap.SugaredLogger
+	dropped int64
+	// done is closed once the recorder has stopped after its context is done.
+	done chan struct{}
+}
+
+// NewRecorder creates recorder which saves clicks and deletes rollups older than models.Retention
+// and visitor sketches older than models.VisitorRetention until ctx is done.
+// Countries are resolved with the database set by GEOIP_DB_FILE; an unreadable database is logged and ignored.
+func NewRecorder(ctx context.Context, store Store, logger *zap.SugaredLogger) *Recorder {
+	geo, err := geoip.Open(config.Config().GeoIPFile)
+	if err != nil {
+		logger.Errorf("error while reading geoip database %q, countries won't be resolved: %v", config.Config().GeoIPFile, err)
+	}
+
+	r := &Recorder{
+		visits: make(chan Visit, bufferSize),
+		store:  store,
+		geo:    geo,
+		key:    []byte(config.Config().AuthKey),
+		logger: logger,
+		done:   make(chan struct{}),
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r.run(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		r.prune(ctx)
+	}()
+	go func() {
+		wg.Wait()
+		close(r.done)
+	}()
+	return r
+}
+
+// Done returns channel closed once the queued clicks are saved and the recorder has stopped after ctx is done.
+func (r *Recorder) Done() <-chan struct{} {
+	return r.done
+}
+
+// Record queues the visit without blocking the caller.
+func (r *Recorder) Record(v Visit) {
+	select {
+	case r.visits <- v:
+	default:
+		atomic.AddInt64(&r.dropped, 1)
+	}
+}
+
+func (r *Recorder) run(ctx context.Context) {
+	t := time.NewTicker(flushInterval)
+	defer t.Stop()
+
+	batch := make([]models.Click, 0, flushSize)
+	for {
+		select {
+		case v := <-r.visits:
+			batch = append(batch, r.click(v))
+			if len(batch) < flushSize {
+				continue
+			}
+		case <-t.C:
+		case <-ctx.Done():
+			// the context of the store calls is done as well, so the rest is saved with a fresh one
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			r.flush(flushCtx, r.drain(batch))
+			cancel()
+			return
+		}
+
+		r.flush(ctx, batch)
+		batch = batch[:0]
+	}
+}
+
+// prune deletes expired rollups and visitor sketches at start and then every pruneInterval.
+func (r *Recorder) prune(ctx context.Context) {
+	t := time.NewTicker(pruneInterval)
+	defer t.Stop()
+
+	for {
+		for interval, retention := range models.Retention {
+			n, err := r.store.DeleteRollups(ctx, interval, time.Now().Add(-retention))
+			if err != nil {
+				r.logger.Errorf("can't delete expired %s rollups: %v", interval, err)
+			} else if n > 0 {
+				r.logger.Infof("%d expired %s rollups were deleted", n, interval)
+			}
+		}
+		n, err := r.store.DeleteVisitorSketches(ctx, time.Now().Add(-models.VisitorRetention))
+		if err != nil {
+			r.logger.Errorf("can't delete expired visitor sketches: %v", err)
+		} else if n > 0 {
+			r.logger.Infof("%d expired visitor sketches were deleted", n)
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// drain appends visits queued so far to the batch.
+func (r *Recorder) drain(batch []models.Click) []models.Click {
+	for {
+		select {
+		case v := <-r.visits:
+			batch = append(batch, r.click(v))
+		default:
+			return batch
+		}
+	}
+}
+
+func (r *Recorder) flush(ctx context.Context, batch []models.Click) {
+	if n := atomic.SwapInt64(&r.dropped, 0); n > 0 {
+		r.logger.Warnf("%d clicks were dropped since the click buffer was full", n)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	err := r.store.AddClicks(ctx, batch)
+	if err != nil {
+		r.logger.Errorf("can't save %d clicks: %v", len(batch), err)
+	}
+}
+
+// click converts the visit into a click, hashing the client address and resolving its country.
+func (r *Recorder) click(v Visit) models.Click {
+	c := models.Click{
+		Short:     v.Short,
+		Time:      v.Time,
+		Referrer:  truncate(v.Referrer),
+		UserAgent: truncate(v.UserAgent),
+	}
+	if ip := net.ParseIP(v.IP); ip != nil {
+		c.IPHash = r.hashIP(ip)
+		c.Country = r.geo.Country(ip)
+	}
+	return c
+}
+
+// hashIP returns keyed hash of the address, so visitors can be told apart but not identified.
+func (r *Recorder) hashIP(ip net.IP) string {
+	h := hmac.New(sha256.New, r.key)
+	h.Write(ip.To16())
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// truncate bounds the value and drops invalid UTF-8 sequences, which the database can't keep.
+func truncate(s string) string {
+	if len(s) > maxFieldLength {
+		s = s[:maxFieldLength]
+	}
+	return strings.ToValidUTF8(s, "")
+}
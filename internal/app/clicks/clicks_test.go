@@ -0,0 +1,159 @@
+package clicks
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Shortener/internal/app/geoip"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// memStore keeps the saved batches of clicks and the times rollups and visitor sketches were deleted before.
+type memStore struct {
+	mu             sync.Mutex
+	batches        [][]models.Click
+	deleted        map[string]time.Time
+	sketchesBefore time.Time
+}
+
+func (s *memStore) AddClicks(_ context.Context, clicks []models.Click) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.batches = append(s.batches, append([]models.Click(nil), clicks...))
+	return nil
+}
+
+func (s *memStore) DeleteRollups(_ context.Context, interval string, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.deleted == nil {
+		s.deleted = make(map[string]time.Time)
+	}
+	s.deleted[interval] = before
+	return 1, nil
+}
+
+func (s *memStore) DeleteVisitorSketches(_ context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sketchesBefore = before
+	return 1, nil
+}
+
+func (s *memStore) clicks() []models.Click {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res []models.Click
+	for _, b := range s.batches {
+		res = append(res, b...)
+	}
+	return res
+}
+
+func newTestRecorder(store Store) *Recorder {
+	geo, err := geoip.Parse(strings.NewReader("192.0.2.0,192.0.2.255,DE\n"))
+	if err != nil {
+		panic(err)
+	}
+	return &Recorder{
+		visits: make(chan Visit, bufferSize),
+		store:  store,
+		geo:    geo,
+		key:    []byte("secret"),
+		logger: zap.NewNop().Sugar(),
+	}
+}
+
+func TestClick(t *testing.T) {
+	r := newTestRecorder(&memStore{})
+	now := time.Now()
+
+	c := r.click(Visit{Short: "a", IP: "192.0.2.1", Referrer: "https://example.com", UserAgent: "curl/7.79.1", Time: now})
+	require.Equal(t, "a", c.Short)
+	require.Equal(t, now, c.Time)
+	require.Equal(t, "https://example.com", c.Referrer)
+	require.Equal(t, "DE", c.Country)
+	require.Len(t, c.IPHash, 32)
+	require.NotContains(t, c.IPHash, "192.0.2.1")
+
+	// the same address is hashed the same way, the other key hashes it another way
+	require.Equal(t, c.IPHash, r.click(Visit{IP: "192.0.2.1"}).IPHash)
+	require.Equal(t, c.IPHash, r.click(Visit{IP: "::ffff:192.0.2.1"}).IPHash)
+	require.NotEqual(t, c.IPHash, r.click(Visit{IP: "192.0.2.2"}).IPHash)
+	other := newTestRecorder(&memStore{})
+	other.key = []byte("other")
+	require.NotEqual(t, c.IPHash, other.click(Visit{IP: "192.0.2.1"}).IPHash)
+
+	unknown := r.click(Visit{IP: "not an address", Referrer: strings.Repeat("a", 2*maxFieldLength), UserAgent: "bad \xff utf-8"})
+	require.Empty(t, unknown.IPHash)
+	require.Empty(t, unknown.Country)
+	require.Len(t, unknown.Referrer, maxFieldLength)
+	require.Equal(t, "bad  utf-8", unknown.UserAgent)
+
+	require.Empty(t, r.click(Visit{IP: "198.51.100.1"}).Country)
+}
+
+func TestRecordFlushes(t *testing.T) {
+	store := &memStore{}
+	r := newTestRecorder(store)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.run(ctx)
+
+	// a full batch is saved at once, the rest on the next tick
+	for i := 0; i < flushSize+1; i++ {
+		r.Record(Visit{Short: "a"})
+	}
+	require.Eventually(t, func() bool {
+		return len(store.clicks()) == flushSize+1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestRecordSavesQueuedOnStop(t *testing.T) {
+	store := &memStore{}
+	r := newTestRecorder(store)
+	for i := 0; i < 10; i++ {
+		r.Record(Visit{Short: "a"})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.run(ctx)
+	require.Len(t, store.clicks(), 10)
+}
+
+func TestRecordDropsWhenFull(t *testing.T) {
+	r := newTestRecorder(&memStore{})
+	for i := 0; i < bufferSize+5; i++ {
+		r.Record(Visit{Short: "a"})
+	}
+	require.Equal(t, int64(5), r.dropped)
+
+	r.flush(context.Background(), nil)
+	require.Zero(t, r.dropped)
+}
+
+func TestPrune(t *testing.T) {
+	store := &memStore{}
+	r := newTestRecorder(store)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	before := time.Now()
+	r.prune(ctx)
+	require.Len(t, store.deleted, len(models.Retention))
+	for interval, retention := range models.Retention {
+		require.WithinDuration(t, before.Add(-retention), store.deleted[interval], time.Second)
+	}
+	require.WithinDuration(t, before.Add(-models.VisitorRetention), store.sketchesBefore, time.Second)
+}
@@ -17,6 +17,31 @@ const (
 	dbConnectionString = "DATABASE_DSN"
 	authKey            = "AUTH_KEY"
 	workersCount       = "WORKERS_COUNT"
+	sweepInterval      = "EXPIRY_SWEEP_INTERVAL"
+	redirectCode       = "REDIRECT_CODE"
+	cachePolicy        = "CACHE_POLICY"
+	notActiveStatus    = "NOT_ACTIVE_STATUS"
+	notActiveURL       = "NOT_ACTIVE_URL"
+	blocklistDomains   = "BLOCKLIST_DOMAINS_FILE"
+	blocklistRules     = "BLOCKLIST_RULES_FILE"
+	blocklistReload    = "BLOCKLIST_RELOAD_INTERVAL"
+	healthInterval     = "HEALTH_CHECK_INTERVAL"
+	healthConcurrency  = "HEALTH_CHECK_CONCURRENCY"
+	healthHostDelay    = "HEALTH_CHECK_HOST_DELAY"
+	healthTimeout      = "HEALTH_CHECK_TIMEOUT"
+	outboundPrivate    = "OUTBOUND_ALLOW_PRIVATE"
+	metadataTimeout    = "METADATA_FETCH_TIMEOUT"
+	metadataMaxBytes   = "METADATA_MAX_BYTES"
+	geoIPFile          = "GEOIP_DB_FILE"
+	webhookRetryBase   = "WEBHOOK_RETRY_BASE"
+	webhookMaxAttempts = "WEBHOOK_MAX_ATTEMPTS"
+	eventLogDir        = "EVENT_LOG_DIR"
+	eventLogGzip       = "EVENT_LOG_GZIP"
+	eventLogMaxBytes   = "EVENT_LOG_MAX_BYTES"
+	eventLogMaxAge     = "EVENT_LOG_MAX_AGE"
+	eventLogFsync      = "EVENT_LOG_FSYNC"
+	trustedSubnet      = "TRUSTED_SUBNET"
+	trustedProxies     = "TRUSTED_PROXIES"
 	jsonConfig         = "CONFIG"
 )
 
@@ -28,21 +53,71 @@ const (
 )
 
 var (
-	defaultFilePath      = "./tmp"
-	defaultServerAddress = "localhost:8080"
-	defaultBaseURL       = "http://localhost:8080"
-	defaultAuthKey       = "secret"
-	defaultWorkersCount  = "10"
+	defaultFilePath           = "./tmp"
+	defaultServerAddress      = "localhost:8080"
+	defaultBaseURL            = "http://localhost:8080"
+	defaultAuthKey            = "secret"
+	defaultWorkersCount       = "10"
+	defaultSweepInterval      = "1m"
+	defaultRedirectCode       = "307"
+	defaultCachePolicy        = ""
+	defaultNotActiveCode      = "503"
+	defaultNotActiveURL       = ""
+	defaultBlocklistDomains   = ""
+	defaultBlocklistRules     = ""
+	defaultBlocklistReload    = "30s"
+	defaultHealthInterval     = "1h"
+	defaultHealthConcurrency  = "5"
+	defaultHealthHostDelay    = "1s"
+	defaultHealthTimeout      = "10s"
+	defaultOutboundPrivate    = "false"
+	defaultMetadataTimeout    = "10s"
+	defaultMetadataMaxBytes   = "1048576"
+	defaultGeoIPFile          = ""
+	defaultWebhookRetryBase   = "10s"
+	defaultWebhookMaxAttempts = "8"
+	defaultEventLogDir        = ""
+	defaultEventLogGzip       = "false"
+	defaultEventLogMaxBytes   = "67108864"
+	defaultEventLogMaxAge     = "1h"
+	defaultEventLogFsync      = "interval"
+	defaultTrustedSubnet      = ""
+	defaultTrustedProxies     = ""
 )
 
 type config struct {
-	BaseURL          string `json:"base_url"`
-	ServerAddress    string `json:"server_address"`
-	FilePath         string `json:"file_storage_path"`
-	ConnectionString string `json:"database_dsn"`
-	AuthKey          string `json:"auth_key"`
-	WorkersCount     string `json:"workers_count"`
-	IsHTTPS          bool   `json:"enable_https"`
+	BaseURL              string `json:"base_url"`
+	ServerAddress        string `json:"server_address"`
+	FilePath             string `json:"file_storage_path"`
+	ConnectionString     string `json:"database_dsn"`
+	AuthKey              string `json:"auth_key"`
+	WorkersCount         string `json:"workers_count"`
+	IsHTTPS              bool   `json:"enable_https"`
+	SweepInterval        string `json:"expiry_sweep_interval"`
+	RedirectCode         string `json:"redirect_code"`
+	CachePolicy          string `json:"cache_policy"`
+	NotActiveStatus      string `json:"not_active_status"`
+	NotActiveURL         string `json:"not_active_url"`
+	BlocklistDomains     string `json:"blocklist_domains_file"`
+	BlocklistRules       string `json:"blocklist_rules_file"`
+	BlocklistReload      string `json:"blocklist_reload_interval"`
+	HealthInterval       string `json:"health_check_interval"`
+	HealthConcurrency    string `json:"health_check_concurrency"`
+	HealthHostDelay      string `json:"health_check_host_delay"`
+	HealthTimeout        string `json:"health_check_timeout"`
+	OutboundAllowPrivate string `json:"outbound_allow_private"`
+	MetadataTimeout      string `json:"metadata_fetch_timeout"`
+	MetadataMaxBytes     string `json:"metadata_max_bytes"`
+	GeoIPFile            string `json:"geoip_db_file"`
+	WebhookRetryBase     string `json:"webhook_retry_base"`
+	WebhookMaxAttempts   string `json:"webhook_max_attempts"`
+	EventLogDir          string `json:"event_log_dir"`
+	EventLogGzip         string `json:"event_log_gzip"`
+	EventLogMaxBytes     string `json:"event_log_max_bytes"`
+	EventLogMaxAge       string `json:"event_log_max_age"`
+	EventLogFsync        string `json:"event_log_fsync"`
+	TrustedSubnet        string `json:"trusted_subnet"`
+	TrustedProxies       string `json:"trusted_proxies"`
 }
 
 func NewConfig() (*config, error) {
@@ -75,14 +150,17 @@ func NewConfig() (*config, error) {
 		if jsConf.ConnectionString != "" {
 			defaultConn = jsConf.ConnectionString
 		}
+		applyServiceOptions(jsConf)
 	}
 
 	c.AuthKey = setEnvOrDefault(authKey, defaultAuthKey)
 	c.WorkersCount = setEnvOrDefault(workersCount, defaultWorkersCount)
+	setServiceOptions(c)
 	flag.StringVar(&c.ServerAddress, "h", setEnvOrDefault(serverAddress, defaultServerAddress), "host to listen on")
 	flag.StringVar(&c.BaseURL, "b", setEnvOrDefault(baseURL, defaultBaseURL), "baseURl for short link")
 	flag.StringVar(&c.FilePath, "f", setEnvOrDefault(filePathEnv, defaultFilePath), "filePath for links")
 	flag.StringVar(&c.ConnectionString, "d", setEnvOrDefault(dbConnectionString, defaultConn), "postgres connection path")
+	flag.StringVar(&c.TrustedSubnet, "t", c.TrustedSubnet, "CIDR of clients allowed to use the internal api")
 	flag.Parse()
 	c.IsHTTPS = isFlagPassed("s")
 	return c, nil
@@ -95,9 +173,118 @@ func SetTestConfig() *config {
 	c.BaseURL = setEnvOrDefault(baseURL, defaultBaseURL)
 	c.FilePath = setEnvOrDefault(filePathEnv, defaultFilePath)
 	c.ConnectionString = setEnvOrDefault(dbConnectionString, "")
+	setServiceOptions(c)
 	return c
 }
 
+// applyServiceOptions makes options of the JSON config defaults of the service options.
+func applyServiceOptions(jsConf *config) {
+	if jsConf.SweepInterval != "" {
+		defaultSweepInterval = jsConf.SweepInterval
+	}
+	if jsConf.RedirectCode != "" {
+		defaultRedirectCode = jsConf.RedirectCode
+	}
+	if jsConf.CachePolicy != "" {
+		defaultCachePolicy = jsConf.CachePolicy
+	}
+	if jsConf.NotActiveStatus != "" {
+		defaultNotActiveCode = jsConf.NotActiveStatus
+	}
+	if jsConf.NotActiveURL != "" {
+		defaultNotActiveURL = jsConf.NotActiveURL
+	}
+	if jsConf.BlocklistDomains != "" {
+		defaultBlocklistDomains = jsConf.BlocklistDomains
+	}
+	if jsConf.BlocklistRules != "" {
+		defaultBlocklistRules = jsConf.BlocklistRules
+	}
+	if jsConf.BlocklistReload != "" {
+		defaultBlocklistReload = jsConf.BlocklistReload
+	}
+	if jsConf.HealthInterval != "" {
+		defaultHealthInterval = jsConf.HealthInterval
+	}
+	if jsConf.HealthConcurrency != "" {
+		defaultHealthConcurrency = jsConf.HealthConcurrency
+	}
+	if jsConf.HealthHostDelay != "" {
+		defaultHealthHostDelay = jsConf.HealthHostDelay
+	}
+	if jsConf.HealthTimeout != "" {
+		defaultHealthTimeout = jsConf.HealthTimeout
+	}
+	if jsConf.OutboundAllowPrivate != "" {
+		defaultOutboundPrivate = jsConf.OutboundAllowPrivate
+	}
+	if jsConf.MetadataTimeout != "" {
+		defaultMetadataTimeout = jsConf.MetadataTimeout
+	}
+	if jsConf.MetadataMaxBytes != "" {
+		defaultMetadataMaxBytes = jsConf.MetadataMaxBytes
+	}
+	if jsConf.GeoIPFile != "" {
+		defaultGeoIPFile = jsConf.GeoIPFile
+	}
+	if jsConf.WebhookRetryBase != "" {
+		defaultWebhookRetryBase = jsConf.WebhookRetryBase
+	}
+	if jsConf.WebhookMaxAttempts != "" {
+		defaultWebhookMaxAttempts = jsConf.WebhookMaxAttempts
+	}
+	if jsConf.EventLogDir != "" {
+		defaultEventLogDir = jsConf.EventLogDir
+	}
+	if jsConf.EventLogGzip != "" {
+		defaultEventLogGzip = jsConf.EventLogGzip
+	}
+	if jsConf.EventLogMaxBytes != "" {
+		defaultEventLogMaxBytes = jsConf.EventLogMaxBytes
+	}
+	if jsConf.EventLogMaxAge != "" {
+		defaultEventLogMaxAge = jsConf.EventLogMaxAge
+	}
+	if jsConf.EventLogFsync != "" {
+		defaultEventLogFsync = jsConf.EventLogFsync
+	}
+	if jsConf.TrustedSubnet != "" {
+		defaultTrustedSubnet = jsConf.TrustedSubnet
+	}
+	if jsConf.TrustedProxies != "" {
+		defaultTrustedProxies = jsConf.TrustedProxies
+	}
+}
+
+// setServiceOptions sets options which are configured via environment or JSON config only.
+func setServiceOptions(c *config) {
+	c.SweepInterval = setEnvOrDefault(sweepInterval, defaultSweepInterval)
+	c.RedirectCode = setEnvOrDefault(redirectCode, defaultRedirectCode)
+	c.CachePolicy = setEnvOrDefault(cachePolicy, defaultCachePolicy)
+	c.NotActiveStatus = setEnvOrDefault(notActiveStatus, defaultNotActiveCode)
+	c.NotActiveURL = setEnvOrDefault(notActiveURL, defaultNotActiveURL)
+	c.BlocklistDomains = setEnvOrDefault(blocklistDomains, defaultBlocklistDomains)
+	c.BlocklistRules = setEnvOrDefault(blocklistRules, defaultBlocklistRules)
+	c.BlocklistReload = setEnvOrDefault(blocklistReload, defaultBlocklistReload)
+	c.HealthInterval = setEnvOrDefault(healthInterval, defaultHealthInterval)
+	c.HealthConcurrency = setEnvOrDefault(healthConcurrency, defaultHealthConcurrency)
+	c.HealthHostDelay = setEnvOrDefault(healthHostDelay, defaultHealthHostDelay)
+	c.HealthTimeout = setEnvOrDefault(healthTimeout, defaultHealthTimeout)
+	c.OutboundAllowPrivate = setEnvOrDefault(outboundPrivate, defaultOutboundPrivate)
+	c.MetadataTimeout = setEnvOrDefault(metadataTimeout, defaultMetadataTimeout)
+	c.MetadataMaxBytes = setEnvOrDefault(metadataMaxBytes, defaultMetadataMaxBytes)
+	c.GeoIPFile = setEnvOrDefault(geoIPFile, defaultGeoIPFile)
+	c.WebhookRetryBase = setEnvOrDefault(webhookRetryBase, defaultWebhookRetryBase)
+	c.WebhookMaxAttempts = setEnvOrDefault(webhookMaxAttempts, defaultWebhookMaxAttempts)
+	c.EventLogDir = setEnvOrDefault(eventLogDir, defaultEventLogDir)
+	c.EventLogGzip = setEnvOrDefault(eventLogGzip, defaultEventLogGzip)
+	c.EventLogMaxBytes = setEnvOrDefault(eventLogMaxBytes, defaultEventLogMaxBytes)
+	c.EventLogMaxAge = setEnvOrDefault(eventLogMaxAge, defaultEventLogMaxAge)
+	c.EventLogFsync = setEnvOrDefault(eventLogFsync, defaultEventLogFsync)
+	c.TrustedSubnet = setEnvOrDefault(trustedSubnet, defaultTrustedSubnet)
+	c.TrustedProxies = setEnvOrDefault(trustedProxies, defaultTrustedProxies)
+}
+
 func Config() *config {
 	return c
 }
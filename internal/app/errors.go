@@ -3,11 +3,57 @@ package app
 import "errors"
 
 var (
-	ErrMethodNotAllowed  = errors.New("method not allowed")
-	ErrLinkNotFound      = errors.New("link is not located in the service")
-	ErrLinkAlreadyExists = errors.New("link already exists in the service")
-	ErrEmptyBodyPostReq  = errors.New("body can't be empty")
-	ErrUserHasNoRecords  = errors.New("user has no records")
-	ErrInvalidSignature  = errors.New("invalid signature")
-	ErrDeletedLink       = errors.New("deleted link")
+	ErrMethodNotAllowed      = errors.New("method not allowed")
+	ErrLinkNotFound          = errors.New("link is not located in the service")
+	ErrLinkAlreadyExists     = errors.New("link already exists in the service")
+	ErrEmptyBodyPostReq      = errors.New("body can't be empty")
+	ErrUserHasNoRecords      = errors.New("user has no records")
+	ErrInvalidSignature      = errors.New("invalid signature")
+	ErrDeletedLink           = errors.New("deleted link")
+	ErrExpiredLink           = errors.New("expired link")
+	ErrInvalidExpiry         = errors.New("expiry must be in the future")
+	ErrExhaustedLink         = errors.New("link click limit exhausted")
+	ErrInvalidMaxClicks      = errors.New("max clicks can't be negative")
+	ErrWrongPassword         = errors.New("wrong link password")
+	ErrTooManyAttempts       = errors.New("too many attempts, try again later")
+	ErrInvalidRedirect       = errors.New("redirect code must be one of 301, 302, 303, 307, 308")
+	ErrInvalidCache          = errors.New("invalid cache policy")
+	ErrInvalidConflict       = errors.New("query conflict policy must be one of destination, request, append")
+	ErrInvalidRules          = errors.New("invalid redirect rules")
+	ErrLinkNotActive         = errors.New("link is not yet available")
+	ErrLinkInactive          = errors.New("link is no longer available")
+	ErrInvalidSchedule       = errors.New("active_until must be after active_from")
+	ErrInvalidQRFormat       = errors.New("qr format must be one of png, svg")
+	ErrInvalidQRSize         = errors.New("qr size must be between 64 and 2048")
+	ErrInvalidQRECC          = errors.New("qr error correction level must be one of L, M, Q, H")
+	ErrBlockedURL            = errors.New("url is blocked")
+	ErrPrivateAddress        = errors.New("connections to private addresses are not allowed")
+	ErrRevisionNotFound      = errors.New("link revision not found")
+	ErrInvalidTags           = errors.New("at most 20 tags of up to 50 characters are allowed")
+	ErrInvalidFolder         = errors.New("folder can't be longer than 100 characters")
+	ErrInvalidNotes          = errors.New("notes can't be longer than 2000 characters")
+	ErrInvalidExportFormat   = errors.New("export format must be one of csv, json, ndjson")
+	ErrInvalidAlias          = errors.New("alias must be 3 to 64 letters, digits, '-' or '_', but not 8 characters long")
+	ErrAliasTaken            = errors.New("alias is already taken")
+	ErrInvalidConflictPolicy = errors.New("conflict policy must be one of rename, skip")
+	ErrJobNotFound           = errors.New("job not found")
+	ErrBatchTooLarge         = errors.New("batch can't contain more than 50000 links")
+	ErrNotLinkOwner          = errors.New("link belongs to another user")
+	ErrInvalidWait           = errors.New("wait must be a duration of up to 30s")
+	ErrInvalidInterval       = errors.New("interval must be one of minute, hour, day")
+	ErrInvalidTimeRange      = errors.New("from must be before to, both RFC 3339 times or dates, with at most 1440 buckets between")
+	ErrInvalidWebhook        = errors.New("webhook url must be an absolute http or https address and events must be known event types")
+	ErrTooManyWebhooks       = errors.New("at most 20 webhooks are allowed")
+	ErrInvalidDeliveryStatus = errors.New("status must be pending, delivered or dead")
+	ErrWebhookNotFound       = errors.New("webhook not found")
+	ErrDeliveryNotFound      = errors.New("webhook delivery not found")
+	ErrDeliveryNotDead       = errors.New("only dead deliveries can be retried")
+	ErrStreamingUnsupported  = errors.New("streaming is not supported by the connection")
+	ErrTooManyStreams        = errors.New("too many open streams")
+	ErrUntrustedClient       = errors.New("internal api is available to the trusted subnet only")
+	ErrJobInterrupted        = errors.New("job was interrupted by a service restart")
+	ErrNoFreeShortLink       = errors.New("no free short link for the address")
+	ErrLinkChanged           = errors.New("link has been changed meanwhile, try again")
+	ErrPoolBusy              = errors.New("too many background tasks, try again later")
+	ErrEventLogLocked        = errors.New("event log directory is used by another instance")
 )
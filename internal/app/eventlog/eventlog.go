@@ -0,0 +1,493 @@
+// Package eventlog writes events of links to rotated NDJSON files to be ingested by batch jobs.
+//
+// Events are written to a file with ".open" suffix. Once the file grows past the size limit or gets
+// older than the age limit it's closed, renamed without the suffix and described by a line of the
+// manifest, so readers should only ingest files listed in the manifest.
+//
+// A sink locks the directory, so files left open are sealed only once the instance which wrote them is gone.
+package eventlog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/config"
+	"github.com/DrGermanius/Shortener/internal/app/events"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// ManifestName is the name of the manifest file in the log directory.
+const ManifestName = "manifest.ndjson"
+
+// lockName is the name of the file locked by the sink writing to the log directory.
+const lockName = "events.lock"
+
+// Policies of syncing written events to the disk.
+const (
+	// FsyncAlways syncs as soon as the queued events are written.
+	FsyncAlways = "always"
+	// FsyncInterval syncs once per flush interval.
+	FsyncInterval = "interval"
+	// FsyncRotate syncs only when a file is closed.
+	FsyncRotate = "rotate"
+)
+
+const (
+	bufferSize    = 8192
+	flushInterval = time.Second
+	openSuffix    = ".open"
+	timeFormat    = "20060102T150405.000000000Z"
+)
+
+// Record is a line of a log file.
+type Record struct {
+	Seq uint64 `json:"seq"`
+	UID string `json:"uid"`
+	models.Event
+}
+
+// File is a line of the manifest describing a closed log file.
+type File struct {
+	Name     string    `json:"name"`
+	Events   int64     `json:"events"`
+	Bytes    int64     `json:"bytes"`
+	SHA256   string    `json:"sha256"`
+	FirstSeq uint64    `json:"first_seq,omitempty"`
+	LastSeq  uint64    `json:"last_seq,omitempty"`
+	OpenedAt time.Time `json:"opened_at"`
+	ClosedAt time.Time `json:"closed_at"`
+	// Recovered is set for files left open by a crash; their last line may be cut short.
+	Recovered bool `json:"recovered,omitempty"`
+}
+
+// options configure a sink.
+type options struct {
+	Dir      string
+	Gzip     bool
+	MaxBytes int64
+	MaxAge   time.Duration
+	Fsync    string
+}
+
+// Sink writes messages to log files; messages are dropped if the disk can't keep up.
+type Sink struct {
+	opts     options
+	messages chan events.Message
+	logger   *zap.SugaredLogger
+	dropped  int64
+	cur      *logFile
+	lock     *os.File
+	done     chan struct{}
+}
+
+// logFile is the open log file with the writers layered over it.
+type logFile struct {
+	path string
+	f    *os.File
+	hash hash.Hash
+	size int64
+	buf  *bufio.Writer
+	gz   *gzip.Writer
+	info File
+}
+
+// NewSink creates sink configured by EVENT_LOG_* options, which writes messages until ctx is done.
+// It returns nil if EVENT_LOG_DIR isn't set and app.ErrEventLogLocked if another instance writes to the directory.
+func NewSink(ctx context.Context, logger *zap.SugaredLogger) (*Sink, error) {
+	c := config.Config()
+	if c.EventLogDir == "" {
+		return nil, nil
+	}
+
+	opts := options{Dir: c.EventLogDir, Fsync: c.EventLogFsync}
+	opts.Gzip, _ = strconv.ParseBool(c.EventLogGzip)
+	var err error
+	opts.MaxBytes, err = strconv.ParseInt(c.EventLogMaxBytes, 10, 64)
+	if err != nil || opts.MaxBytes <= 0 {
+		opts.MaxBytes = 64 << 20
+		logger.Errorf("error while reading config event log max bytes %q, using %d", c.EventLogMaxBytes, opts.MaxBytes)
+	}
+	opts.MaxAge, err = time.ParseDuration(c.EventLogMaxAge)
+	if err != nil || opts.MaxAge <= 0 {
+		opts.MaxAge = time.Hour
+		logger.Errorf("error while reading config event log max age %q, using %v", c.EventLogMaxAge, opts.MaxAge)
+	}
+	switch opts.Fsync {
+	case FsyncAlways, FsyncInterval, FsyncRotate:
+	default:
+		logger.Errorf("error while reading config event log fsync policy %q, using %s", opts.Fsync, FsyncInterval)
+		opts.Fsync = FsyncInterval
+	}
+
+	return start(ctx, opts, logger)
+}
+
+// start creates sink with the options, closing files left open by a previous run first.
+func start(ctx context.Context, opts options, logger *zap.SugaredLogger) (*Sink, error) {
+	err := os.MkdirAll(opts.Dir, 0o750)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := lockDir(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sink{
+		opts:     opts,
+		messages: make(chan events.Message, bufferSize),
+		logger:   logger,
+		lock:     lock,
+		done:     make(chan struct{}),
+	}
+	err = s.recoverOpen()
+	if err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	go s.run(ctx)
+	return s, nil
+}
+
+// Write queues the message without blocking the caller.
+func (s *Sink) Write(m events.Message) {
+	select {
+	case s.messages <- m:
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+	}
+}
+
+// Done returns channel closed once the sink has written the queued messages and released the directory after ctx is done.
+func (s *Sink) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *Sink) run(ctx context.Context) {
+	t := time.NewTicker(flushInterval)
+	defer t.Stop()
+	defer close(s.done)
+
+	for {
+		select {
+		case m := <-s.messages:
+			s.write(m)
+			if s.opts.Fsync == FsyncAlways && len(s.messages) == 0 {
+				s.flush(true)
+			}
+		case <-t.C:
+			if n := atomic.SwapInt64(&s.dropped, 0); n > 0 {
+				s.logger.Warnf("%d events weren't logged since the event log buffer was full", n)
+			}
+			if s.cur != nil && time.Since(s.cur.info.OpenedAt) >= s.opts.MaxAge {
+				s.rotate()
+				continue
+			}
+			s.flush(s.opts.Fsync == FsyncInterval)
+		case <-ctx.Done():
+			for len(s.messages) > 0 {
+				s.write(<-s.messages)
+			}
+			s.rotate()
+			err := s.lock.Close()
+			if err != nil {
+				s.logger.Errorf("can't unlock %s: %v", s.opts.Dir, err)
+			}
+			return
+		}
+	}
+}
+
+func (s *Sink) write(m events.Message) {
+	b, err := json.Marshal(Record{Seq: m.Seq, UID: m.UID, Event: m.Event})
+	if err != nil {
+		s.logger.Errorf("can't encode event %d: %v", m.Seq, err)
+		return
+	}
+
+	if s.cur == nil {
+		s.cur, err = s.open(m.Seq)
+		if err != nil {
+			s.logger.Errorf("can't open event log file: %v", err)
+			return
+		}
+	}
+
+	err = s.cur.write(append(b, '\n'))
+	if err != nil {
+		s.logger.Errorf("can't write event %d to %s: %v", m.Seq, s.cur.path, err)
+		s.rotate()
+		return
+	}
+	if s.cur.info.FirstSeq == 0 {
+		s.cur.info.FirstSeq = m.Seq
+	}
+	s.cur.info.LastSeq = m.Seq
+	s.cur.info.Events++
+
+	if s.cur.size+int64(s.cur.buf.Buffered()) >= s.opts.MaxBytes {
+		s.rotate()
+	}
+}
+
+// flush writes buffered events of the open file, syncing them to the disk if sync is set.
+func (s *Sink) flush(sync bool) {
+	if s.cur == nil {
+		return
+	}
+
+	err := s.cur.flush()
+	if err == nil && sync {
+		err = s.cur.f.Sync()
+	}
+	if err != nil {
+		s.logger.Errorf("can't flush %s: %v", s.cur.path, err)
+	}
+}
+
+// rotate closes the open file and adds it to the manifest; the next event opens a new file.
+func (s *Sink) rotate() {
+	if s.cur == nil {
+		return
+	}
+	cur := s.cur
+	s.cur = nil
+
+	err := cur.close()
+	if err != nil {
+		s.logger.Errorf("can't close %s: %v", cur.path, err)
+	}
+	cur.info.ClosedAt = time.Now().UTC()
+	cur.info.Bytes = cur.size
+	cur.info.SHA256 = hex.EncodeToString(cur.hash.Sum(nil))
+
+	err = s.seal(cur.path, cur.info)
+	if err != nil {
+		s.logger.Errorf("can't add %s to the manifest: %v", cur.path, err)
+	}
+}
+
+func (s *Sink) open(seq uint64) (*logFile, error) {
+	now := time.Now().UTC()
+	name := fmt.Sprintf("events-%s-%d.ndjson", now.Format(timeFormat), seq)
+	if s.opts.Gzip {
+		name += ".gz"
+	}
+
+	p := filepath.Join(s.opts.Dir, name+openSuffix)
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return nil, err
+	}
+
+	lf := &logFile{
+		path: p,
+		f:    f,
+		hash: sha256.New(),
+		info: File{Name: name, OpenedAt: now},
+	}
+	lf.buf = bufio.NewWriter(lf)
+	if s.opts.Gzip {
+		lf.gz = gzip.NewWriter(lf.buf)
+	}
+	return lf, nil
+}
+
+// seal renames the closed file to its final name and describes it in the manifest.
+func (s *Sink) seal(p string, info File) error {
+	err := os.Rename(p, filepath.Join(s.opts.Dir, info.Name))
+	if err != nil {
+		return err
+	}
+	err = syncDir(s.opts.Dir)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	m, err := os.OpenFile(filepath.Join(s.opts.Dir, ManifestName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	_, err = m.Write(append(b, '\n'))
+	if err == nil {
+		err = m.Sync()
+	}
+	closeErr := m.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// recoverOpen seals files left open by a crash of a previous run; the directory is locked, so no one writes them.
+func (s *Sink) recoverOpen() error {
+	paths, err := filepath.Glob(filepath.Join(s.opts.Dir, "events-*"+openSuffix))
+	if err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		info, err := inspect(p)
+		if err != nil {
+			return err
+		}
+		err = s.seal(p, info)
+		if err != nil {
+			return err
+		}
+		s.logger.Warnf("event log file %s left open by a previous run was recovered", info.Name)
+	}
+	return nil
+}
+
+// inspect describes the file left open by its complete lines.
+func inspect(p string) (File, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return File{}, err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return File{}, err
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return File{}, err
+	}
+	info := File{
+		Name:      strings.TrimSuffix(filepath.Base(p), openSuffix),
+		Bytes:     st.Size(),
+		SHA256:    hex.EncodeToString(h.Sum(nil)),
+		OpenedAt:  st.ModTime().UTC(),
+		ClosedAt:  time.Now().UTC(),
+		Recovered: true,
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return File{}, err
+	}
+	var r io.Reader = f
+	if strings.HasSuffix(info.Name, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			// nothing was flushed before the crash
+			return info, nil
+		}
+		r = gz
+	}
+	// a truncated gzip stream fails at its end, lines read so far are still counted
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var rec struct {
+			Seq uint64 `json:"seq"`
+		}
+		// the last line is cut short if the crash happened while it was written
+		if json.Unmarshal(sc.Bytes(), &rec) != nil {
+			break
+		}
+		if info.FirstSeq == 0 {
+			info.FirstSeq = rec.Seq
+		}
+		info.LastSeq = rec.Seq
+		info.Events++
+	}
+	return info, nil
+}
+
+// Write writes the compressed output to the file, keeping its size and checksum.
+func (lf *logFile) Write(p []byte) (int, error) {
+	n, err := lf.f.Write(p)
+	lf.hash.Write(p[:n])
+	lf.size += int64(n)
+	return n, err
+}
+
+func (lf *logFile) write(line []byte) error {
+	if lf.gz != nil {
+		_, err := lf.gz.Write(line)
+		return err
+	}
+	_, err := lf.buf.Write(line)
+	return err
+}
+
+func (lf *logFile) flush() error {
+	if lf.gz != nil {
+		err := lf.gz.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	return lf.buf.Flush()
+}
+
+func (lf *logFile) close() error {
+	var err error
+	if lf.gz != nil {
+		err = lf.gz.Close()
+	}
+	if err == nil {
+		err = lf.buf.Flush()
+	}
+	if err == nil {
+		err = lf.f.Sync()
+	}
+	closeErr := lf.f.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// lockDir locks the directory for the sink until the returned file is closed or the process exits.
+func lockDir(dir string) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(dir, lockName), os.O_RDWR|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	err = lockFile(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%w: %v", app.ErrEventLogLocked, err)
+	}
+	return f, nil
+}
+
+// syncDir makes renames in the directory durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
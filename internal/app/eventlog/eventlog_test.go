@@ -0,0 +1,194 @@
+package eventlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/events"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+func message(seq uint64) events.Message {
+	return events.Message{Seq: seq, UID: "user", Event: models.Event{Type: models.EventLinkClicked}}
+}
+
+func startSink(t *testing.T, opts options) (*Sink, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := start(ctx, opts, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		cancel()
+		<-s.Done()
+	})
+	return s, cancel
+}
+
+func manifest(t *testing.T, dir string) []File {
+	b, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	require.NoError(t, err)
+
+	var files []File
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var f File
+		require.NoError(t, json.Unmarshal([]byte(line), &f))
+		files = append(files, f)
+	}
+	return files
+}
+
+func records(t *testing.T, dir string, f File) []Record {
+	b, err := os.ReadFile(filepath.Join(dir, f.Name))
+	require.NoError(t, err)
+	require.Equal(t, f.Bytes, int64(len(b)))
+
+	var r io.Reader = bytes.NewReader(b)
+	if strings.HasSuffix(f.Name, ".gz") {
+		r, err = gzip.NewReader(r)
+		require.NoError(t, err)
+	}
+	var res []Record
+	d := json.NewDecoder(r)
+	for d.More() {
+		var rec Record
+		require.NoError(t, d.Decode(&rec))
+		res = append(res, rec)
+	}
+	return res
+}
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	s, cancel := startSink(t, options{Dir: dir, MaxBytes: 200, MaxAge: time.Hour, Fsync: FsyncRotate})
+
+	for seq := uint64(1); seq <= 5; seq++ {
+		s.Write(message(seq))
+	}
+	cancel()
+	<-s.Done()
+
+	files := manifest(t, dir)
+	require.Greater(t, len(files), 1)
+	var seqs []uint64
+	for _, f := range files {
+		recs := records(t, dir, f)
+		require.Len(t, recs, int(f.Events))
+		require.Equal(t, recs[0].Seq, f.FirstSeq)
+		require.Equal(t, recs[len(recs)-1].Seq, f.LastSeq)
+		require.False(t, f.Recovered)
+		for _, r := range recs {
+			seqs = append(seqs, r.Seq)
+		}
+	}
+	require.Equal(t, []uint64{1, 2, 3, 4, 5}, seqs)
+
+	open, err := filepath.Glob(filepath.Join(dir, "*"+openSuffix))
+	require.NoError(t, err)
+	require.Empty(t, open)
+}
+
+func TestGzipRotatedByAge(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := startSink(t, options{Dir: dir, Gzip: true, MaxBytes: 1 << 20, MaxAge: time.Millisecond, Fsync: FsyncAlways})
+
+	s.Write(message(1))
+	s.Write(message(2))
+	require.Eventually(t, func() bool {
+		return len(manifest(t, dir)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	f := manifest(t, dir)[0]
+	require.True(t, strings.HasSuffix(f.Name, ".ndjson.gz"))
+	require.Equal(t, int64(2), f.Events)
+	recs := records(t, dir, f)
+	require.Len(t, recs, 2)
+	require.Equal(t, uint64(2), recs[1].Seq)
+}
+
+func TestRecoverOpen(t *testing.T) {
+	dir := t.TempDir()
+	lines := func(seqs ...uint64) []byte {
+		var b []byte
+		for _, seq := range seqs {
+			line, err := json.Marshal(Record{Seq: seq, UID: "user"})
+			require.NoError(t, err)
+			b = append(b, line...)
+			b = append(b, '\n')
+		}
+		return b
+	}
+
+	// the last line was cut short by the crash
+	plain := append(lines(3, 4), `{"seq":5,"ui`...)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "events-a-3.ndjson"+openSuffix), plain, 0o640))
+
+	// the gzip stream was flushed, but never closed
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	_, err := w.Write(lines(7, 8, 9))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "events-b-7.ndjson.gz"+openSuffix), gz.Bytes(), 0o640))
+
+	// nothing was flushed before the crash
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "events-c-10.ndjson.gz"+openSuffix), nil, 0o640))
+
+	startSink(t, options{Dir: dir, MaxBytes: 1 << 20, MaxAge: time.Hour, Fsync: FsyncInterval})
+
+	files := manifest(t, dir)
+	require.Len(t, files, 3)
+	for _, f := range files {
+		require.True(t, f.Recovered)
+		_, err := os.Stat(filepath.Join(dir, f.Name))
+		require.NoError(t, err)
+	}
+	require.Equal(t, "events-a-3.ndjson", files[0].Name)
+	require.Equal(t, int64(2), files[0].Events)
+	require.Equal(t, uint64(3), files[0].FirstSeq)
+	require.Equal(t, uint64(4), files[0].LastSeq)
+	require.Equal(t, int64(len(plain)), files[0].Bytes)
+
+	require.Equal(t, int64(3), files[1].Events)
+	require.Equal(t, uint64(7), files[1].FirstSeq)
+	require.Equal(t, uint64(9), files[1].LastSeq)
+
+	require.Zero(t, files[2].Events)
+	require.Zero(t, files[2].FirstSeq)
+}
+
+func TestLock(t *testing.T) {
+	dir := t.TempDir()
+	opts := options{Dir: dir, MaxBytes: 1 << 20, MaxAge: time.Hour, Fsync: FsyncAlways}
+	s, cancel := startSink(t, opts)
+
+	s.Write(message(1))
+	require.Eventually(t, func() bool {
+		open, err := filepath.Glob(filepath.Join(dir, "*"+openSuffix))
+		return err == nil && len(open) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// another instance doesn't seal the file the first one still writes
+	_, err := start(context.Background(), opts, zap.NewNop().Sugar())
+	require.ErrorIs(t, err, app.ErrEventLogLocked)
+	require.Empty(t, manifest(t, dir))
+
+	cancel()
+	<-s.Done()
+	require.Len(t, manifest(t, dir), 1)
+
+	startSink(t, opts)
+}
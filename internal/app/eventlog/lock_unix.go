@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package eventlog
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock of the file without waiting for it.
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
@@ -0,0 +1,250 @@
+// Package events fans out events of links to subscribers within the service.
+package events
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+const (
+	// replaySize is the number of the latest events of a user kept for subscribers resuming a stream.
+	replaySize = 64
+	// maxReplayUsers is the number of users whose events are kept; events of the least recently active user are dropped first.
+	maxReplayUsers = 4096
+	// bufferSize is the number of events a subscriber may fall behind before it's evicted.
+	bufferSize = 256
+)
+
+// EventReset tells a resuming subscriber that some of the events it missed are gone, so it has to start over.
+const EventReset = "stream.reset"
+
+// Message is an event of the user's link.
+type Message struct {
+	Seq   uint64
+	UID   string
+	Event models.Event
+}
+
+// Bus delivers published messages to subscribers of their users without ever blocking the publisher.
+type Bus struct {
+	mu   sync.Mutex
+	seq  uint64
+	subs map[string]map[*Subscription]struct{}
+	// replays keeps the latest messages of users, lru orders the users from the most recently active one.
+	replays map[string]*list.Element
+	lru     *list.List
+	// dropped is the sequence number of the latest message dropped along with a replay of a user.
+	dropped uint64
+	// listeners receive messages of every user.
+	listeners []func(Message)
+}
+
+// replay is a ring of the latest messages of a user.
+type replay struct {
+	uid      string
+	messages []Message
+	next     int
+	// dropped is the sequence number of the latest message of the user which isn't kept any more:
+	// overwritten in the ring, or dropped with an older replay of the user before this one was added.
+	dropped uint64
+}
+
+// Subscription receives messages of a user until it's closed or evicted.
+type Subscription struct {
+	uid      string
+	messages chan Message
+	evicted  chan struct{}
+}
+
+func NewBus() *Bus {
+	// sequence numbers start from the boot time, so they keep growing across restarts
+	// and ids seen before a restart don't skip events published after it
+	seq := uint64(time.Now().UnixNano() / int64(time.Microsecond))
+	return &Bus{
+		seq:     seq,
+		subs:    make(map[string]map[*Subscription]struct{}),
+		replays: make(map[string]*list.Element),
+		lru:     list.New(),
+		// events published before the restart are gone
+		dropped: seq,
+	}
+}
+
+// Publish sends the event to subscribers of the user and keeps it for resumed streams.
+// Subscribers which fall behind by more than bufferSize messages are evicted.
+func (b *Bus) Publish(uid, eventType string, data models.EventData) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	m := Message{
+		Seq: b.seq,
+		UID: uid,
+		Event: models.Event{
+			ID:        strconv.FormatUint(b.seq, 10),
+			Type:      eventType,
+			CreatedAt: time.Now().UTC(),
+			Data:      data,
+		},
+	}
+	b.keep(m)
+
+	for _, l := range b.listeners {
+		l(m)
+	}
+	for s := range b.subs[uid] {
+		select {
+		case s.messages <- m:
+		default:
+			b.unsubscribe(s)
+			close(s.evicted)
+		}
+	}
+}
+
+// keep adds the message to the replay of its user.
+func (b *Bus) keep(m Message) {
+	e, ok := b.replays[m.UID]
+	if ok {
+		b.lru.MoveToFront(e)
+	} else {
+		e = b.lru.PushFront(&replay{uid: m.UID, dropped: b.dropped})
+		b.replays[m.UID] = e
+		if b.lru.Len() > maxReplayUsers {
+			last := b.lru.Remove(b.lru.Back()).(*replay)
+			delete(b.replays, last.uid)
+			if seq := last.latest(); seq > b.dropped {
+				b.dropped = seq
+			}
+		}
+	}
+
+	r := e.Value.(*replay)
+	if len(r.messages) < replaySize {
+		r.messages = append(r.messages, m)
+		return
+	}
+	r.dropped = r.messages[r.next].Seq
+	r.messages[r.next] = m
+	r.next = (r.next + 1) % replaySize
+}
+
+// Subscribe subscribes to messages of the user and returns kept messages published after lastSeq,
+// so a stream resumes without gaps as long as the missed messages are still kept.
+// If some of them are gone, the only message returned is an EventReset one.
+// Subscribe fails with app.ErrTooManyStreams once the user has max subscriptions.
+func (b *Bus) Subscribe(uid string, lastSeq uint64, max int) (*Subscription, []Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.subs[uid]) >= max {
+		return nil, nil, app.ErrTooManyStreams
+	}
+
+	var missed []Message
+	if lastSeq > 0 {
+		var reset bool
+		missed, reset = b.missed(uid, lastSeq)
+		if reset {
+			missed = []Message{{
+				Seq: b.seq,
+				UID: uid,
+				Event: models.Event{
+					ID:        strconv.FormatUint(b.seq, 10),
+					Type:      EventReset,
+					CreatedAt: time.Now().UTC(),
+				},
+			}}
+		}
+	}
+
+	s := &Subscription{
+		uid:      uid,
+		messages: make(chan Message, bufferSize),
+		evicted:  make(chan struct{}),
+	}
+	subs, ok := b.subs[uid]
+	if !ok {
+		subs = make(map[*Subscription]struct{})
+		b.subs[uid] = subs
+	}
+	subs[s] = struct{}{}
+	return s, missed, nil
+}
+
+// missed returns kept messages of the user published after lastSeq, or reset if some of them were dropped.
+func (b *Bus) missed(uid string, lastSeq uint64) ([]Message, bool) {
+	e, ok := b.replays[uid]
+	if !ok {
+		// messages of the user may have been dropped with its replay
+		return nil, lastSeq < b.dropped
+	}
+
+	r := e.Value.(*replay)
+	if lastSeq < r.dropped {
+		return nil, true
+	}
+	var missed []Message
+	for i := range r.messages {
+		m := r.messages[(r.next+i)%len(r.messages)]
+		if m.Seq > lastSeq {
+			missed = append(missed, m)
+		}
+	}
+	return missed, false
+}
+
+// Listen registers fn called with messages of every user in the order they're published; fn must not block.
+func (b *Bus) Listen(fn func(Message)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.listeners = append(b.listeners, fn)
+}
+
+// Subscribers returns the number of subscriptions of the user.
+func (b *Bus) Subscribers(uid string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.subs[uid])
+}
+
+// Unsubscribe closes the subscription.
+func (b *Bus) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.unsubscribe(s)
+}
+
+func (b *Bus) unsubscribe(s *Subscription) {
+	subs := b.subs[s.uid]
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(b.subs, s.uid)
+	}
+}
+
+// latest returns the sequence number of the latest kept message.
+func (r *replay) latest() uint64 {
+	if len(r.messages) == 0 {
+		return 0
+	}
+	return r.messages[(r.next+len(r.messages)-1)%len(r.messages)].Seq
+}
+
+// Messages returns channel of messages of the subscription.
+func (s *Subscription) Messages() <-chan Message {
+	return s.messages
+}
+
+// Evicted returns channel closed once the subscription is evicted for falling behind.
+func (s *Subscription) Evicted() <-chan struct{} {
+	return s.evicted
+}
@@ -0,0 +1,156 @@
+package events
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+func seqs(messages []Message) []uint64 {
+	var res []uint64
+	for _, m := range messages {
+		res = append(res, m.Seq)
+	}
+	return res
+}
+
+func TestSubscribe(t *testing.T) {
+	b := NewBus()
+	start := b.seq
+
+	s, missed, err := b.Subscribe("user", 0, 2)
+	require.NoError(t, err)
+	require.Empty(t, missed)
+
+	var listened []Message
+	b.Listen(func(m Message) {
+		listened = append(listened, m)
+	})
+	b.Publish("user", models.EventLinkCreated, models.EventData{ShortURL: "a"})
+	b.Publish("other", models.EventLinkCreated, models.EventData{ShortURL: "b"})
+
+	m := <-s.Messages()
+	require.Equal(t, start+1, m.Seq)
+	require.Equal(t, strconv.FormatUint(m.Seq, 10), m.Event.ID)
+	require.Equal(t, "a", m.Event.Data.ShortURL)
+	require.Empty(t, s.Messages())
+	require.Len(t, listened, 2)
+
+	_, _, err = b.Subscribe("user", 0, 2)
+	require.NoError(t, err)
+	_, _, err = b.Subscribe("user", 0, 2)
+	require.ErrorIs(t, err, app.ErrTooManyStreams)
+	require.Equal(t, 2, b.Subscribers("user"))
+
+	b.Unsubscribe(s)
+	require.Equal(t, 1, b.Subscribers("user"))
+	_, _, err = b.Subscribe("user", 0, 2)
+	require.NoError(t, err)
+}
+
+func TestEviction(t *testing.T) {
+	b := NewBus()
+	slow, _, err := b.Subscribe("user", 0, 2)
+	require.NoError(t, err)
+	fast, _, err := b.Subscribe("user", 0, 2)
+	require.NoError(t, err)
+
+	for i := 0; i < bufferSize; i++ {
+		b.Publish("user", models.EventLinkClicked, models.EventData{})
+		<-fast.Messages()
+	}
+	select {
+	case <-slow.Evicted():
+		t.Fatal("subscription is evicted before it falls behind")
+	default:
+	}
+
+	b.Publish("user", models.EventLinkClicked, models.EventData{})
+	<-slow.Evicted()
+	require.Equal(t, 1, b.Subscribers("user"))
+	require.Len(t, fast.Messages(), 1)
+
+	// unsubscribing an evicted subscription keeps the others
+	b.Unsubscribe(slow)
+	require.Equal(t, 1, b.Subscribers("user"))
+}
+
+func TestReplay(t *testing.T) {
+	b := NewBus()
+	start := b.seq
+	for i := 0; i < 3; i++ {
+		b.Publish("user", models.EventLinkClicked, models.EventData{})
+		b.Publish("other", models.EventLinkClicked, models.EventData{})
+	}
+
+	_, missed, err := b.Subscribe("user", start+1, 10)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{start + 3, start + 5}, seqs(missed))
+
+	_, missed, err = b.Subscribe("user", start+5, 10)
+	require.NoError(t, err)
+	require.Empty(t, missed)
+
+	// ids seen before the bus started can't be resumed
+	_, missed, err = b.Subscribe("user", start-1, 10)
+	require.NoError(t, err)
+	require.Len(t, missed, 1)
+	require.Equal(t, EventReset, missed[0].Event.Type)
+	require.Equal(t, b.seq, missed[0].Seq)
+
+	// a user without events resumes from the start of the bus
+	_, missed, err = b.Subscribe("quiet", start, 10)
+	require.NoError(t, err)
+	require.Empty(t, missed)
+}
+
+func TestReplayOverwritten(t *testing.T) {
+	b := NewBus()
+	start := b.seq
+	for i := 0; i < replaySize+2; i++ {
+		b.Publish("user", models.EventLinkClicked, models.EventData{})
+	}
+
+	// the first two events are overwritten
+	_, missed, err := b.Subscribe("user", start+2, 10)
+	require.NoError(t, err)
+	require.Len(t, missed, replaySize)
+	require.Equal(t, start+3, missed[0].Seq)
+
+	_, missed, err = b.Subscribe("user", start+1, 10)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{b.seq}, seqs(missed))
+	require.Equal(t, EventReset, missed[0].Event.Type)
+}
+
+func TestReplayDroppedUsers(t *testing.T) {
+	b := NewBus()
+	start := b.seq
+	b.Publish("user", models.EventLinkClicked, models.EventData{})
+	for i := 0; i < maxReplayUsers; i++ {
+		b.Publish(strconv.Itoa(i), models.EventLinkClicked, models.EventData{})
+	}
+	require.NotContains(t, b.replays, "user")
+	require.Equal(t, start+1, b.dropped)
+
+	_, missed, err := b.Subscribe("user", start, 10)
+	require.NoError(t, err)
+	require.Equal(t, EventReset, missed[0].Event.Type)
+	_, missed, err = b.Subscribe("user", start+1, 10)
+	require.NoError(t, err)
+	require.Empty(t, missed)
+
+	// a new replay of the user doesn't hide events dropped with the old one
+	b.Publish("user", models.EventLinkClicked, models.EventData{})
+	_, missed, err = b.Subscribe("user", start, 10)
+	require.NoError(t, err)
+	require.Equal(t, EventReset, missed[0].Event.Type)
+	_, missed, err = b.Subscribe("user", start+1, 10)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{b.seq}, seqs(missed))
+	require.Equal(t, models.EventLinkClicked, missed[0].Event.Type)
+}
@@ -0,0 +1,129 @@
+// Package geoip resolves countries of IP addresses from a local range database.
+package geoip
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DB is a sorted list of IP address ranges with their countries.
+type DB struct {
+	ranges []ipRange
+}
+
+type ipRange struct {
+	start   net.IP
+	end     net.IP
+	country string
+}
+
+var maxIPv4 = big.NewInt(1<<32 - 1)
+
+// Open reads the database file; empty path stands for an empty database.
+func Open(path string) (*DB, error) {
+	if path == "" {
+		return &DB{}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// Parse reads CSV rows of the first address, the last address and the country code of a range,
+// the format of DB-IP and IP2Location LITE country databases.
+// Addresses are written either as IPs or as decimal numbers; a header row is skipped.
+func Parse(r io.Reader) (*DB, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	db := &DB{}
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 3 {
+			return nil, fmt.Errorf("geoip line %d: expected first address, last address and country", line)
+		}
+
+		start, end := parseIP(rec[0]), parseIP(rec[1])
+		if start == nil || end == nil {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("geoip line %d: malformed address", line)
+		}
+
+		country := strings.ToUpper(strings.TrimSpace(rec[2]))
+		if country == "-" || country == "ZZ" {
+			continue
+		}
+		db.ranges = append(db.ranges, ipRange{start: start, end: end, country: country})
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return bytes.Compare(db.ranges[i].start, db.ranges[j].start) < 0
+	})
+	return db, nil
+}
+
+// Country returns ISO code of the country of the address, empty if it's unknown.
+func (db *DB) Country(ip net.IP) string {
+	if db == nil || ip == nil {
+		return ""
+	}
+	ip = ip.To16()
+
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].start, ip) > 0
+	})
+	if i == 0 {
+		return ""
+	}
+	r := db.ranges[i-1]
+	if bytes.Compare(ip, r.end) > 0 {
+		return ""
+	}
+	return r.country
+}
+
+// Len returns the number of known ranges.
+func (db *DB) Len() int {
+	return len(db.ranges)
+}
+
+// parseIP parses an IP address or its decimal number; numbers up to 2^32-1 are IPv4 addresses.
+func parseIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.To16()
+	}
+
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 || n.BitLen() > 128 {
+		return nil
+	}
+	if n.Cmp(maxIPv4) <= 0 {
+		v := n.Uint64()
+		return net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)).To16()
+	}
+	ip := make(net.IP, net.IPv6len)
+	n.FillBytes(ip)
+	return ip
+}
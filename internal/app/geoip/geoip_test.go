@@ -0,0 +1,74 @@
+package geoip
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const ranges = `ip_from,ip_to,country_code
+2001:db8::,2001:db8:ffff:ffff:ffff:ffff:ffff:ffff,nl
+198.51.100.0,198.51.100.255,FR
+3221225984,3221226239,de
+203.0.113.0,203.0.113.255,-
+42540766490510755371168322545197776896,42540766569738917885432660138741727231,JP
+`
+
+func TestParse(t *testing.T) {
+	db, err := Parse(strings.NewReader(ranges))
+	require.NoError(t, err)
+	require.Equal(t, 4, db.Len())
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{ip: "192.0.2.0", want: "DE"},
+		{ip: "192.0.2.255", want: "DE"},
+		{ip: "192.0.3.0", want: ""},
+		{ip: "198.51.100.7", want: "FR"},
+		{ip: "203.0.113.1", want: ""},
+		{ip: "10.0.0.1", want: ""},
+		{ip: "2001:db8::1", want: "NL"},
+		{ip: "2001:db9::1", want: "JP"},
+		{ip: "2001:dba::1", want: ""},
+		{ip: "::ffff:198.51.100.1", want: "FR"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, db.Country(net.ParseIP(tt.ip)), tt.ip)
+	}
+	require.Empty(t, db.Country(nil))
+
+	var empty *DB
+	require.Empty(t, empty.Country(net.ParseIP("192.0.2.1")))
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := Parse(strings.NewReader("192.0.2.0,192.0.2.255,DE\n192.0.2.0,not an address,DE\n"))
+	require.EqualError(t, err, "geoip line 2: malformed address")
+
+	_, err = Parse(strings.NewReader("192.0.2.0,192.0.2.255\n"))
+	require.EqualError(t, err, "geoip line 1: expected first address, last address and country")
+
+	_, err = Parse(strings.NewReader("ip_from,ip_to,country\n192.0.2.0,-1,DE\n"))
+	require.Error(t, err)
+}
+
+func TestOpen(t *testing.T) {
+	db, err := Open("")
+	require.NoError(t, err)
+	require.Zero(t, db.Len())
+
+	p := filepath.Join(t.TempDir(), "ranges.csv")
+	require.NoError(t, os.WriteFile(p, []byte(ranges), 0o600))
+	db, err = Open(p)
+	require.NoError(t, err)
+	require.Equal(t, 4, db.Len())
+
+	_, err = Open(filepath.Join(t.TempDir(), "missing.csv"))
+	require.Error(t, err)
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// EditLinkHandler changes destination and settings of the user's link passed via JSON.
+// Fields absent from the request keep their values; every change is saved as a link revision.
+func (h Handlers) EditLinkHandler(w http.ResponseWriter, req *http.Request) {
+	uid, err := checkAuthCookie(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil || len(b) == 0 {
+		http.Error(w, app.ErrEmptyBodyPostReq.Error(), http.StatusBadRequest)
+		return
+	}
+
+	short := chi.URLParam(req, "id")
+	cur, err := h.store.Lookup(req.Context(), short)
+	if err != nil || cur.UUID != uid {
+		editErrorResponse(w, app.ErrLinkNotFound)
+		return
+	}
+
+	eReq := models.EditRequest{URL: cur.Long, LinkOptions: cur.Options()}
+	err = json.Unmarshal(b, &eReq)
+	if err != nil || eReq.URL == "" {
+		http.Error(w, app.ErrEmptyBodyPostReq.Error(), http.StatusBadRequest)
+		return
+	}
+	if eReq.RemovePassword {
+		eReq.PasswordHash = ""
+	}
+
+	err = prepareLinkOptions(&eReq.LinkOptions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.screenLink(req.Context(), eReq.URL, eReq.LinkOptions)
+	if err != nil {
+		screenErrorResponse(w, err)
+		return
+	}
+
+	// the password is hashed and the destination screened before the link is locked,
+	// so the change is only applied if the link is still in the state it has been made from
+	l, err := h.store.Update(req.Context(), uid, short, func(l *models.LinkInfo) error {
+		if l.Long != cur.Long || !reflect.DeepEqual(l.Options(), cur.Options()) {
+			return app.ErrLinkChanged
+		}
+		l.SetOptions(eReq.URL, eReq.LinkOptions)
+		return nil
+	})
+	if err != nil {
+		editErrorResponse(w, err)
+		return
+	}
+	if l.Metadata == nil {
+		h.enricher.Enrich(short, l.Long)
+	}
+
+	writeLink(w, short, l)
+}
+
+// LinkHistoryHandler returns revisions of the user's link, oldest first.
+func (h Handlers) LinkHistoryHandler(w http.ResponseWriter, req *http.Request) {
+	uid, err := checkAuthCookie(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	short := chi.URLParam(req, "id")
+	revisions, err := h.revisions(req.Context(), uid, short)
+	if err != nil {
+		editErrorResponse(w, err)
+		return
+	}
+
+	res := make([]models.RevisionJSON, 0, len(revisions))
+	for _, r := range revisions {
+		res = append(res, r.JSON(app.FullLink(short)))
+	}
+
+	jRes, err := json.Marshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(jRes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// RollbackLinkHandler restores settings of the user's link saved in the revision passed via JSON.
+// The rollback itself is saved as a new revision.
+func (h Handlers) RollbackLinkHandler(w http.ResponseWriter, req *http.Request) {
+	uid, err := checkAuthCookie(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		http.Error(w, app.ErrEmptyBodyPostReq.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var rReq models.RollbackRequest
+	err = json.Unmarshal(b, &rReq)
+	if err != nil {
+		http.Error(w, app.ErrEmptyBodyPostReq.Error(), http.StatusBadRequest)
+		return
+	}
+
+	short := chi.URLParam(req, "id")
+	revisions, err := h.revisions(req.Context(), uid, short)
+	if err != nil {
+		editErrorResponse(w, err)
+		return
+	}
+
+	var target *models.Revision
+	for i := range revisions {
+		if revisions[i].Number == rReq.Revision {
+			target = &revisions[i]
+		}
+	}
+	if target == nil {
+		http.Error(w, app.ErrRevisionNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	// the revision may hold an expiry or a schedule which isn't valid any more
+	opts := target.Link.Options()
+	err = prepareLinkOptions(&opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.screenLink(req.Context(), target.Link.Long, opts)
+	if err != nil {
+		screenErrorResponse(w, err)
+		return
+	}
+
+	l, err := h.store.Update(req.Context(), uid, short, func(l *models.LinkInfo) error {
+		l.SetOptions(target.Link.Long, opts)
+		return nil
+	})
+	if err != nil {
+		editErrorResponse(w, err)
+		return
+	}
+	if l.Metadata == nil {
+		h.enricher.Enrich(short, l.Long)
+	}
+
+	writeLink(w, short, l)
+}
+
+// revisions returns revisions of the user's link; a never edited link has its current state as the only revision.
+func (h Handlers) revisions(ctx context.Context, uid, short string) ([]models.Revision, error) {
+	l, err := h.store.Lookup(ctx, short)
+	if err != nil || l.UUID != uid {
+		return nil, app.ErrLinkNotFound
+	}
+
+	revisions, err := h.store.Revisions(ctx, short)
+	if err != nil {
+		return nil, err
+	}
+	if len(revisions) == 0 {
+		revisions = []models.Revision{{Number: 1, Author: l.UUID, CreatedAt: l.CreatedAt, Link: l}}
+	}
+	return revisions, nil
+}
+
+// editErrorResponse writes an error of a link change.
+func editErrorResponse(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, app.ErrLinkNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, app.ErrDeletedLink):
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, app.ErrLinkAlreadyExists), errors.Is(err, app.ErrLinkChanged):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		screenErrorResponse(w, err)
+	}
+}
+
+// writeLink writes the link as the JSON response.
+func writeLink(w http.ResponseWriter, short string, l models.LinkInfo) {
+	j := l.JSON(app.FullLink(short))
+	j.UUID = ""
+
+	jRes, err := json.Marshal(j)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(jRes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// linkCreated fetches metadata of the new link and publishes its creation.
+func (h Handlers) linkCreated(ctx context.Context, uid, short, long string) {
+	h.enricher.Enrich(short, long)
+	h.publish(ctx, uid, models.EventLinkCreated, models.EventData{ShortURL: app.FullLink(short), OriginalURL: long})
+}
+
+// clicked publishes the redirect to streams and webhooks of the link owner without delaying it.
+func (h Handlers) clicked(req *http.Request, short string, l models.LinkInfo) {
+	data := models.EventData{
+		ShortURL:    app.FullLink(short),
+		OriginalURL: l.Long,
+		Referrer:    req.Referer(),
+		UserAgent:   req.UserAgent(),
+	}
+	h.events.Publish(l.UUID, models.EventLinkClicked, data)
+
+	// webhooks of the owner are read in the worker pool, the redirect checks the cached ones only
+	if !h.webhooks.MaybeSubscribed(l.UUID, models.EventLinkClicked) {
+		return
+	}
+	err := h.workerPool.Submit(h.context, func(ctx context.Context) error {
+		return h.webhooks.Publish(ctx, l.UUID, models.EventLinkClicked, data)
+	})
+	if err != nil {
+		h.logger.Errorf("can't publish %s event of %s: %v", models.EventLinkClicked, data.ShortURL, err)
+	}
+}
+
+// publish sends the event to the user's streams and queues it for the user's webhooks;
+// failures are logged, they never fail the request.
+func (h Handlers) publish(ctx context.Context, uid, eventType string, data models.EventData) {
+	h.events.Publish(uid, eventType, data)
+
+	err := h.webhooks.Publish(ctx, uid, eventType, data)
+	if err != nil {
+		h.logger.Errorf("can't publish %s event of %s: %v", eventType, data.ShortURL, err)
+	}
+}
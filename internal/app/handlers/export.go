@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// Formats of the links export.
+const (
+	exportCSV    = "csv"
+	exportJSON   = "json"
+	exportNDJSON = "ndjson"
+)
+
+var exportContentTypes = map[string]string{
+	exportCSV:    "text/csv; charset=utf-8",
+	exportJSON:   "application/json",
+	exportNDJSON: "application/x-ndjson",
+}
+
+var exportCSVHeader = []string{"short_url", "original_url", "is_deleted", "created_at", "expires_at", "clicks", "max_clicks",
+	"title", "folder", "tags", "notes", "metadata_title", "metadata_description", "metadata_image", "metadata_site_name"}
+
+// ExportLinksHandler streams all user's links, including deleted ones, in the format set by "format" param:
+// csv (default), json or ndjson. The filter params of GetUserUrlsHandler are supported as well.
+func (h Handlers) ExportLinksHandler(w http.ResponseWriter, req *http.Request) {
+	uid, err := checkAuthCookie(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	format := req.URL.Query().Get("format")
+	if format == "" {
+		format = exportCSV
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		http.Error(w, app.ErrInvalidExportFormat.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="links.`+format+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	var out io.Writer = w
+	if acceptsGzip(req) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+	w.WriteHeader(http.StatusOK)
+
+	ew := newExportWriter(out, format)
+	err = ew.open()
+	if err == nil {
+		err = h.store.EachByUserID(req.Context(), uid, linkFilter(req), ew.write)
+	}
+	if err == nil {
+		err = ew.close()
+	}
+	if err != nil {
+		h.logger.Errorf("links export of %s failed: %v", uid, err)
+	}
+}
+
+func acceptsGzip(req *http.Request) bool {
+	for _, e := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(e, ";", 2)[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// exportWriter encodes links one by one.
+type exportWriter struct {
+	format string
+	out    io.Writer
+	csv    *csv.Writer
+	json   *json.Encoder
+	rows   int
+}
+
+func newExportWriter(out io.Writer, format string) *exportWriter {
+	ew := &exportWriter{format: format, out: out}
+	switch format {
+	case exportCSV:
+		ew.csv = csv.NewWriter(out)
+	default:
+		ew.json = json.NewEncoder(out)
+	}
+	return ew
+}
+
+// open writes the csv header or the opening bracket of the json array.
+func (ew *exportWriter) open() error {
+	switch ew.format {
+	case exportCSV:
+		return ew.csv.Write(exportCSVHeader)
+	case exportJSON:
+		_, err := io.WriteString(ew.out, "[")
+		return err
+	}
+	return nil
+}
+
+func (ew *exportWriter) write(l models.LinkJSON) error {
+	l.UUID = ""
+	ew.rows++
+
+	switch ew.format {
+	case exportCSV:
+		err := ew.csv.Write(csvRecord(l))
+		if err != nil {
+			return err
+		}
+		ew.csv.Flush()
+		return ew.csv.Error()
+	case exportJSON:
+		if ew.rows > 1 {
+			_, err := io.WriteString(ew.out, ",")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return ew.json.Encode(l)
+}
+
+func (ew *exportWriter) close() error {
+	switch ew.format {
+	case exportCSV:
+		ew.csv.Flush()
+		return ew.csv.Error()
+	case exportJSON:
+		_, err := io.WriteString(ew.out, "]\n")
+		return err
+	}
+	return nil
+}
+
+func csvRecord(l models.LinkJSON) []string {
+	var m models.LinkMetadata
+	if l.Metadata != nil {
+		m = *l.Metadata
+	}
+
+	r := []string{l.Short, l.Long, strconv.FormatBool(l.IsDeleted), csvTime(l.CreatedAt), csvTime(l.ExpiresAt),
+		strconv.FormatInt(l.Clicks, 10), strconv.FormatInt(l.MaxClicks, 10), l.Title, l.Folder, strings.Join(l.Tags, ";"),
+		l.Notes, m.Title, m.Description, m.Image, m.SiteName}
+	for i, v := range r {
+		r[i] = csvSafe(v)
+	}
+	return r
+}
+
+func csvTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// csvSafe keeps spreadsheet applications from evaluating user supplied values as formulas.
+func csvSafe(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
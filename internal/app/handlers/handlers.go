@@ -7,15 +7,28 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
-	"time"
+	"strconv"
+	"strings"
 
 	"go.uber.org/zap"
 
 	"github.com/DrGermanius/Shortener/internal/app"
 	"github.com/DrGermanius/Shortener/internal/app/auth"
+	"github.com/DrGermanius/Shortener/internal/app/clicks"
+	"github.com/DrGermanius/Shortener/internal/app/config"
+	"github.com/DrGermanius/Shortener/internal/app/eventlog"
+	"github.com/DrGermanius/Shortener/internal/app/events"
+	"github.com/DrGermanius/Shortener/internal/app/importer"
+	"github.com/DrGermanius/Shortener/internal/app/jobs"
+	"github.com/DrGermanius/Shortener/internal/app/metadata"
 	"github.com/DrGermanius/Shortener/internal/app/models"
+	"github.com/DrGermanius/Shortener/internal/app/qr"
+	"github.com/DrGermanius/Shortener/internal/app/screening"
+	"github.com/DrGermanius/Shortener/internal/app/webhooks"
 	"github.com/DrGermanius/Shortener/internal/store"
 )
 
@@ -24,13 +37,114 @@ type Handlers struct {
 	workerPool app.WorkerPool
 	logger     *zap.SugaredLogger
 	context    context.Context
+	attempts   *app.AttemptLimiter
+	screener   *screening.Screener
+	enricher   *metadata.Enricher
+	jobs       *jobs.Registry
+	clicks     *clicks.Recorder
+	webhooks   *webhooks.Dispatcher
+	events     *events.Bus
+	eventLog   *eventlog.Sink
+
+	redirectCode    int
+	cachePolicy     string
+	notActiveStatus int
+	notActiveURL    string
+	trustedSubnet   *net.IPNet
+	trustedProxies  []*net.IPNet
 }
 
 func NewHandlers(store store.LinksStorager, wp app.WorkerPool, logger *zap.SugaredLogger, context context.Context) Handlers {
-	return Handlers{store: store, workerPool: wp, logger: logger, context: context}
+	code, err := strconv.Atoi(config.Config().RedirectCode)
+	if err != nil || validateRedirectCode(code) != nil {
+		code = http.StatusTemporaryRedirect
+		logger.Errorf("error while reading config redirect code %q, using %d", config.Config().RedirectCode, code)
+	}
+
+	policy := config.Config().CachePolicy
+	if validateCachePolicy(policy) != nil {
+		policy = ""
+		logger.Errorf("error while reading config cache policy %q, using none", config.Config().CachePolicy)
+	}
+
+	notActive, err := strconv.Atoi(config.Config().NotActiveStatus)
+	if err != nil || notActive < 400 || notActive > 599 {
+		notActive = http.StatusServiceUnavailable
+		logger.Errorf("error while reading config not active status %q, using %d", config.Config().NotActiveStatus, notActive)
+	}
+
+	var subnet *net.IPNet
+	if s := config.Config().TrustedSubnet; s != "" {
+		_, subnet, err = net.ParseCIDR(s)
+		if err != nil {
+			logger.Errorf("error while reading config trusted subnet %q, internal api is disabled: %v", s, err)
+		}
+	}
+
+	// X-Real-IP is only taken from the proxies, clients connected directly could forge it
+	var proxies []*net.IPNet
+	for _, s := range strings.Split(config.Config().TrustedProxies, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		_, proxy, err := net.ParseCIDR(s)
+		if err != nil {
+			logger.Errorf("error while reading config trusted proxy %q, it isn't trusted: %v", s, err)
+			continue
+		}
+		proxies = append(proxies, proxy)
+	}
+
+	bus := events.NewBus()
+	sink, err := eventlog.NewSink(context, logger)
+	if err != nil {
+		logger.Errorf("error while opening event log %q, events won't be logged: %v", config.Config().EventLogDir, err)
+	}
+	if sink != nil {
+		bus.Listen(sink.Write)
+	}
+
+	return Handlers{
+		store:           store,
+		workerPool:      wp,
+		logger:          logger,
+		context:         context,
+		attempts:        app.NewAttemptLimiter(passwordAttempts, passwordAttemptsWindow),
+		screener:        newScreener(context, logger),
+		enricher:        metadata.NewEnricher(context, store, wp, logger),
+		jobs:            jobs.NewRegistry(context, store, logger),
+		clicks:          clicks.NewRecorder(context, store, logger),
+		webhooks:        webhooks.NewDispatcher(context, store, wp, logger),
+		events:          bus,
+		eventLog:        sink,
+		redirectCode:    code,
+		cachePolicy:     policy,
+		notActiveStatus: notActive,
+		notActiveURL:    config.Config().NotActiveURL,
+		trustedSubnet:   subnet,
+		trustedProxies:  proxies,
+	}
+}
+
+// Done returns channel closed once queued clicks are saved and events queued for the event log are written
+// after the handlers context is done.
+func (h Handlers) Done() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		<-h.clicks.Done()
+		if h.eventLog != nil {
+			<-h.eventLog.Done()
+		}
+		close(done)
+	}()
+	return done
 }
 
 // GetShortLinkHandler redirects client to full url address by short representation.
+// It serves HEAD requests as well, which never count against the link click limit.
+// Query params and path after the short code are forwarded to the destination if the link allows it.
+// A "+" after the short code shows the preview page of the link instead.
 func (h Handlers) GetShortLinkHandler(w http.ResponseWriter, req *http.Request) {
 	_, err := checkAuthCookie(w, req)
 	if err != nil {
@@ -38,25 +152,30 @@ func (h Handlers) GetShortLinkHandler(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	s := req.URL.Path[1:] // skip "/" from path; chi.UrlParam not working in tests
+	s, suffix := linkPath(req)
+	s, preview := previewRequested(req, s)
 
 	l, err := h.store.Get(req.Context(), s)
 	if err != nil {
-		if errors.Is(err, app.ErrDeletedLink) {
-			http.Error(w, err.Error(), http.StatusGone)
-			return
-		}
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.linkErrorResponse(w, req, s, err)
+		return
+	}
+	if suffix != "" && !l.ForwardPath {
+		http.NotFound(w, req)
 		return
 	}
 
-	w.Header().Add("Location", l)
-	w.WriteHeader(http.StatusTemporaryRedirect)
+	if preview {
+		renderPreview(w, s, l, "")
+		return
+	}
 
-	_, err = w.Write([]byte{})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if l.PasswordHash != "" && !hasLinkAccess(req, s, l) {
+		renderPasswordForm(w, req, nil, http.StatusOK)
+		return
 	}
+
+	h.redirect(w, req, s, suffix, l)
 }
 
 // PingDatabaseHandler checks if links storage is available.
@@ -72,6 +191,7 @@ func (h Handlers) PingDatabaseHandler(w http.ResponseWriter, req *http.Request)
 }
 
 // GetUserUrlsHandler returns user's loaded links by userID.
+// Links can be filtered by "tag" (repeatable) and "folder" params and searched by "q" param.
 func (h Handlers) GetUserUrlsHandler(w http.ResponseWriter, req *http.Request) {
 	uid, err := checkAuthCookie(w, req)
 	if err != nil {
@@ -79,7 +199,7 @@ func (h Handlers) GetUserUrlsHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	res, err := h.store.GetByUserID(req.Context(), uid)
+	res, err := h.store.GetByUserID(req.Context(), uid, linkFilter(req))
 	if err != nil {
 		if errors.Is(err, app.ErrUserHasNoRecords) {
 			http.Error(w, err.Error(), http.StatusNoContent)
@@ -124,8 +244,14 @@ func (h Handlers) AddShortLinkHandler(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
+	err = h.screenLink(req.Context(), string(b), models.LinkOptions{})
+	if err != nil {
+		screenErrorResponse(w, err)
+		return
+	}
+
 	linkAlreadyExist := false
-	s, err := h.store.Write(req.Context(), uid, string(b))
+	s, err := h.store.Write(req.Context(), uid, string(b), models.LinkOptions{})
 	if err != nil {
 		if errors.Is(err, app.ErrLinkAlreadyExists) {
 			linkAlreadyExist = true
@@ -133,6 +259,8 @@ func (h Handlers) AddShortLinkHandler(w http.ResponseWriter, req *http.Request)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+	} else {
+		h.linkCreated(req.Context(), uid, s, string(b))
 	}
 	full := app.FullLink(s)
 
@@ -172,8 +300,20 @@ func (h Handlers) ShortenHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	err = prepareLinkOptions(&sReq.LinkOptions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.screenLink(req.Context(), sReq.URL, sReq.LinkOptions)
+	if err != nil {
+		screenErrorResponse(w, err)
+		return
+	}
+
 	linkAlreadyExist := false
-	s, err := h.store.Write(req.Context(), uid, sReq.URL)
+	s, err := h.store.Write(req.Context(), uid, sReq.URL, sReq.LinkOptions)
 	if err != nil {
 		if errors.Is(err, app.ErrLinkAlreadyExists) {
 			linkAlreadyExist = true
@@ -181,6 +321,8 @@ func (h Handlers) ShortenHandler(w http.ResponseWriter, req *http.Request) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+	} else {
+		h.linkCreated(req.Context(), uid, s, sReq.URL)
 	}
 
 	sRes.Result = app.FullLink(s)
@@ -204,6 +346,9 @@ func (h Handlers) ShortenHandler(w http.ResponseWriter, req *http.Request) {
 }
 
 // BatchHandler takes a couple of URL addresses via JSON, creates and returns short representation of that and saves it.
+// Addresses shortened before get short representations of their existing links.
+// With "qr" query param set to png, svg or true it returns QR code data URIs as well.
+// With "async" query param set to true it queues the batch as a job instead, see asyncBatch.
 func (h Handlers) BatchHandler(w http.ResponseWriter, req *http.Request) {
 	uid, err := checkAuthCookie(w, req)
 	if err != nil {
@@ -211,33 +356,90 @@ func (h Handlers) BatchHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	b, err := ioutil.ReadAll(req.Body)
-	defer req.Body.Close()
-	if err != nil {
-		http.Error(w, app.ErrEmptyBodyPostReq.Error(), http.StatusBadRequest)
+	var qrOpts *qr.Options
+	if f := req.URL.Query().Get("qr"); f != "" {
+		if f == "true" {
+			f = qr.FormatPNG
+		}
+		o, err := qrOptions(f, req.URL.Query().Get("qr_size"), req.URL.Query().Get("qr_ecc"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		qrOpts = &o
+	}
+
+	if req.URL.Query().Get("async") == "true" {
+		h.asyncBatch(w, req, uid)
 		return
 	}
 
 	var batchReq []models.BatchOriginal
 
-	err = json.Unmarshal(b, &batchReq)
+	err = json.NewDecoder(req.Body).Decode(&batchReq)
+	defer req.Body.Close()
 	if err != nil {
 		http.Error(w, app.ErrEmptyBodyPostReq.Error(), http.StatusBadRequest)
 		return
 	}
 
-	shorts, err := h.store.BatchWrite(req.Context(), uid, batchReq)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+	for i := range batchReq {
+		err = prepareLinkOptions(&batchReq[i].LinkOptions)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = h.screenLink(req.Context(), batchReq[i].OriginalURL, batchReq[i].LinkOptions)
+		if err != nil {
+			screenErrorResponse(w, err)
+			return
+		}
+	}
+
+	shorts := make([]string, len(batchReq))
+	var originals []models.BatchOriginal
+	var written []int
+	for i, o := range batchReq {
+		s, err := h.store.FindLink(req.Context(), o.OriginalURL)
+		switch {
+		case err == nil:
+			shorts[i] = s
+		case errors.Is(err, app.ErrLinkNotFound):
+			originals = append(originals, o)
+			written = append(written, i)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if len(originals) > 0 {
+		created, err := h.store.BatchWrite(req.Context(), uid, originals)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for j, s := range created {
+			shorts[written[j]] = s
+			h.linkCreated(req.Context(), uid, s, originals[j].OriginalURL)
+		}
 	}
 
 	batchRes := make([]models.BatchShort, 0, len(batchReq))
 	for i := 0; i < len(batchReq); i++ {
-		batchRes = append(batchRes, models.BatchShort{
+		bs := models.BatchShort{
 			CorrelationID: batchReq[i].CorrelationID,
 			ShortURL:      app.FullLink(shorts[i]),
-		})
+		}
+		if qrOpts != nil {
+			bs.QR, err = qr.DataURI(bs.ShortURL, *qrOpts)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		batchRes = append(batchRes, bs)
 	}
 
 	jRes, err := json.Marshal(batchRes)
@@ -255,7 +457,66 @@ func (h Handlers) BatchHandler(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-// DeleteLinksHandler takes a couple of user's URL addresses via JSON and deletes from store.
+// asyncBatch queues the batch as a job processed in the worker pool and returns the job.
+// Items are validated and written independently, so an invalid item or a taken alias fails the item only.
+// QR codes aren't generated for queued batches.
+func (h Handlers) asyncBatch(w http.ResponseWriter, req *http.Request, uid string) {
+	items, err := decodeBatch(req.Body)
+	defer req.Body.Close()
+	if errors.Is(err, app.ErrBatchTooLarge) {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, app.ErrEmptyBodyPostReq.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.startJob(req.Context(), uid, batchJobKind, len(items), func(progress func([]models.JobResult)) jobStep {
+		return h.bulkWriter(importer.OnConflictSkip, progress).Chunks(uid, items)
+	})
+	if err != nil {
+		startJobErrorResponse(w, err)
+		return
+	}
+
+	writeJob(w, "/api/jobs/"+job.ID, job)
+}
+
+// decodeBatch reads the JSON array of batch links one element at a time,
+// so a batch of more than importer.MaxRows links is refused without reading the rest of it.
+func decodeBatch(r io.Reader) ([]importer.Item, error) {
+	dec := json.NewDecoder(r)
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := t.(json.Delim); !ok || d != '[' {
+		return nil, app.ErrEmptyBodyPostReq
+	}
+
+	var items []importer.Item
+	for dec.More() {
+		if len(items) == importer.MaxRows {
+			return nil, app.ErrBatchTooLarge
+		}
+
+		var o models.BatchOriginal
+		err = dec.Decode(&o)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, importer.Item{ID: o.CorrelationID, Link: o})
+	}
+
+	// the closing bracket
+	_, err = dec.Token()
+	return items, err
+}
+
+// DeleteLinksHandler takes a couple of user's URL addresses via JSON and queues a job deleting them from store.
+// The job reports whether every link was deleted, not found, not owned by the user or failed to be deleted.
+// With "wait" param set to a duration it waits for the job to finish up to that long.
 func (h Handlers) DeleteLinksHandler(w http.ResponseWriter, req *http.Request) {
 	uid, err := checkAuthCookie(w, req)
 	if err != nil {
@@ -263,30 +524,86 @@ func (h Handlers) DeleteLinksHandler(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	b, err := ioutil.ReadAll(req.Body)
-	defer req.Body.Close()
+	wait, err := waitParam(req)
 	if err != nil {
-		http.Error(w, app.ErrEmptyBodyPostReq.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	var links []string
-	err = json.Unmarshal(b, &links)
+	err = json.NewDecoder(req.Body).Decode(&links)
+	defer req.Body.Close()
 	if err != nil {
 		http.Error(w, app.ErrEmptyBodyPostReq.Error(), http.StatusBadRequest)
 		return
 	}
-	ctx, cancel := context.WithTimeout(h.context, time.Second*20)
-	time.AfterFunc(time.Second*20, cancel)
-	go h.workerPool.StartDeleteWorker(uid, links, h.store.Delete, ctx)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusAccepted)
 
-	_, err = w.Write([]byte{})
+	job, err := h.startJob(req.Context(), uid, deleteJobKind, len(links), func(progress func([]models.JobResult)) jobStep {
+		return h.deleteLinks(uid, links, progress)
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		startJobErrorResponse(w, err)
+		return
+	}
+
+	if wait > 0 {
+		h.waitJob(req.Context(), job.ID, wait)
+		job, err = h.jobs.Get(req.Context(), uid, job.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
+
+	writeJob(w, "/api/jobs/"+job.ID, job)
+}
+
+// deleteLinks returns step which deletes the next chunk of the user's links and reports its results.
+func (h Handlers) deleteLinks(uid string, links []string, progress func([]models.JobResult)) jobStep {
+	start := 0
+	return func(ctx context.Context) (bool, error) {
+		if start >= len(links) {
+			return false, nil
+		}
+		err := ctx.Err()
+		if err != nil {
+			return false, err
+		}
+
+		end := start + deleteChunkSize
+		if end > len(links) {
+			end = len(links)
+		}
+
+		results := make([]models.JobResult, 0, end-start)
+		for _, link := range links[start:end] {
+			err = h.store.Delete(ctx, uid, link)
+			if err == nil {
+				h.publish(ctx, uid, models.EventLinkDeleted, models.EventData{ShortURL: app.FullLink(link)})
+			}
+			results = append(results, deleteResult(link, err))
+		}
+		progress(results)
+
+		start = end
+		return start < len(links), nil
+	}
+}
+
+func deleteResult(link string, err error) models.JobResult {
+	res := models.JobResult{Item: link, Status: deleteStatusDeleted}
+	switch {
+	case err == nil:
+		return res
+	case errors.Is(err, app.ErrLinkNotFound):
+		res.Status = deleteStatusNotFound
+	case errors.Is(err, app.ErrNotLinkOwner):
+		res.Status = deleteStatusNotOwner
+	default:
+		res.Status = deleteStatusFailed
+	}
+	res.Error = err.Error()
+	return res
 }
 
 // checkAuthCookie sets or validates user cookie and authenticates user.
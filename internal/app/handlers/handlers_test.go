@@ -1,18 +1,34 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
+	"math"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 
 	"github.com/DrGermanius/Shortener/internal/store/memory"
@@ -23,7 +39,14 @@ import (
 	"github.com/DrGermanius/Shortener/internal/app"
 	"github.com/DrGermanius/Shortener/internal/app/auth"
 	"github.com/DrGermanius/Shortener/internal/app/config"
+	"github.com/DrGermanius/Shortener/internal/app/eventlog"
+	"github.com/DrGermanius/Shortener/internal/app/events"
+	"github.com/DrGermanius/Shortener/internal/app/health"
+	"github.com/DrGermanius/Shortener/internal/app/hll"
+	"github.com/DrGermanius/Shortener/internal/app/importer"
 	"github.com/DrGermanius/Shortener/internal/app/models"
+	"github.com/DrGermanius/Shortener/internal/app/screening"
+	"github.com/DrGermanius/Shortener/internal/app/webhooks"
 )
 
 const (
@@ -34,6 +57,9 @@ const (
 var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 var H Handlers
 
+// stopH stops background work of H, so it doesn't outlive the test it was created for.
+var stopH = func() {}
+
 func TestPostHandler(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -345,6 +371,56 @@ func TestButchLinks(t *testing.T) {
 	}
 }
 
+func TestBatchExistingLinks(t *testing.T) {
+	tests := []struct {
+		name string
+		want want
+	}{
+		{
+			name: "positive test #122",
+			want: want{
+				code: http.StatusCreated,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			owner, err := auth.GetSignature()
+			require.NoError(t, err)
+			ownerUID, err := auth.CheckSignature(owner)
+			require.NoError(t, err)
+			existing, err := H.store.Write(context.Background(), ownerUID, yandexLink, models.LinkOptions{})
+			require.NoError(t, err)
+
+			other, err := auth.GetSignature()
+			require.NoError(t, err)
+			body, err := json.Marshal([]models.BatchOriginal{
+				{CorrelationID: "1", OriginalURL: yandexLink},
+				{CorrelationID: "2", OriginalURL: yandexLink + "/new"},
+			})
+			require.NoError(t, err)
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", bytes.NewBuffer(body))
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: other})
+			w := httptest.NewRecorder()
+			H.BatchHandler(w, request)
+			require.Equal(t, tt.want.code, w.Code)
+
+			var res []models.BatchShort
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+			require.Equal(t, []models.BatchShort{
+				{CorrelationID: "1", ShortURL: app.FullLink(existing)},
+				{CorrelationID: "2", ShortURL: app.FullLink(app.ShortLink([]byte(yandexLink + "/new")))},
+			}, res)
+
+			// the existing link is left to its owner
+			info, err := H.store.Lookup(context.Background(), existing)
+			require.NoError(t, err)
+			require.Equal(t, ownerUID, info.UUID)
+		})
+	}
+}
+
 func TestDeleteLinks(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -406,134 +482,3036 @@ func TestDeleteLinks(t *testing.T) {
 	}
 }
 
-func BenchmarkAddGet(b *testing.B) {
-	initTestData()
-	authCookieValue, err := auth.GetSignature()
-	if err != nil {
-		b.Fatal(err)
+func TestDeleteJob(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		want     want
+		statuses []string
+	}{
+		{
+			name:     "positive test #73",
+			query:    "?wait=5s",
+			want:     want{code: http.StatusOK},
+			statuses: []string{"deleted", "not_found", "not_owner", "deleted"},
+		},
+		{
+			name:     "positive test #74",
+			want:     want{code: http.StatusAccepted},
+			statuses: []string{"deleted", "not_found", "not_owner", "deleted"},
+		},
+		{
+			name:  "negative test #75",
+			query: "?wait=1h",
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrInvalidWait,
+			},
+		},
+		{
+			name:  "negative test #76",
+			query: "?wait=soon",
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrInvalidWait,
+			},
+		},
 	}
-	authCookie := &http.Cookie{Name: auth.AuthCookie, Value: authCookieValue}
-	w := httptest.NewRecorder()
-	hAdd := http.HandlerFunc(H.AddShortLinkHandler)
-	hGet := http.HandlerFunc(H.GetShortLinkHandler)
-
-	b.ResetTimer()
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			authCookieValue, err := auth.GetSignature()
+			require.NoError(t, err)
+			uid, err := auth.CheckSignature(authCookieValue)
+			require.NoError(t, err)
 
-	for i := 0; i < b.N; i++ {
-		b.StopTimer()
-		s := randStringRunes(10)
-		addRequest := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(s))
-		addRequest.AddCookie(authCookie)
-		getRequest := httptest.NewRequest(http.MethodGet, "/", strings.NewReader(s))
-		getRequest.AddCookie(authCookie)
-		b.StartTimer()
+			own, err := H.store.Write(context.Background(), uid, "https://go.dev/doc/"+uid, models.LinkOptions{})
+			require.NoError(t, err)
+			foreign, err := H.store.Write(context.Background(), "someone", "https://go.dev/doc/foreign/"+uid, models.LinkOptions{})
+			require.NoError(t, err)
 
-		hAdd.ServeHTTP(w, addRequest)
-		hGet.ServeHTTP(w, getRequest)
-	}
-}
+			body, err := json.Marshal([]string{own, "missing" + uid, foreign, own})
+			require.NoError(t, err)
+			request := httptest.NewRequest(http.MethodDelete, "/api/user/urls"+tt.query, bytes.NewBuffer(body))
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: authCookieValue})
+			w := httptest.NewRecorder()
+			H.DeleteLinksHandler(w, request)
+			require.Equal(t, tt.want.code, w.Code)
+			if tt.want.err != nil {
+				require.Contains(t, w.Body.String(), tt.want.err.Error())
+				return
+			}
 
-func ExampleHandlers_AddShortLinkHandler() {
-	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(yandexLink))
-	w := httptest.NewRecorder()
-	h := http.HandlerFunc(H.AddShortLinkHandler)
-	h.ServeHTTP(w, request)
-}
+			var job models.Job
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&job))
+			require.Equal(t, "delete", job.Kind)
+			require.Equal(t, "/api/jobs/"+job.ID, w.Header().Get("Location"))
+			if !job.Finished() {
+				request := withURLParam(httptest.NewRequest(http.MethodGet, "/api/jobs/"+job.ID+"?wait=5", nil), "id", job.ID)
+				request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: authCookieValue})
+				w := httptest.NewRecorder()
+				H.JobHandler(w, request)
+				require.Equal(t, http.StatusOK, w.Code)
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&job))
+			}
 
-func ExampleHandlers_GetShortLinkHandler() {
-	request := httptest.NewRequest(http.MethodGet, "/"+yandexLink, nil)
-	w := httptest.NewRecorder()
-	h := http.HandlerFunc(H.GetShortLinkHandler)
-	h.ServeHTTP(w, request)
-}
+			require.Equal(t, models.JobDone, job.Status)
+			require.Equal(t, len(tt.statuses), job.Processed)
+			require.Equal(t, 2, job.Failed)
+			for i, status := range tt.statuses {
+				assert.Equal(t, status, job.Results[i].Status)
+			}
 
-func ExampleHandlers_DeleteLinksHandler() {
-	link := app.ShortLink([]byte(yandexLink))
-	req, err := json.Marshal([]string{
-		link,
-	})
-	if err != nil {
-		log.Fatal(err)
+			_, err = H.store.Get(context.Background(), own)
+			require.ErrorIs(t, err, app.ErrDeletedLink)
+			_, err = H.store.Get(context.Background(), foreign)
+			require.NoError(t, err)
+		})
 	}
-	authCookie := &http.Cookie{Name: auth.AuthCookie, Value: "authCookieValue"}
-
-	request := httptest.NewRequest(http.MethodDelete, "/api/user/urls", bytes.NewBuffer(req))
-	request.AddCookie(authCookie)
-	w := httptest.NewRecorder()
-	h := http.HandlerFunc(H.DeleteLinksHandler)
-	h.ServeHTTP(w, request)
 }
 
-func ExampleHandlers_ShortenHandler() {
-	sReq := models.ShortenRequest{URL: yandexLink}
-	body, err := json.Marshal(sReq)
-	request := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(body))
-	if err != nil {
-		log.Fatal(err)
+func TestExpiredLink(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		link      string
+		expiresAt time.Time
+		want      want
+	}{
+		{
+			name:      "positive test #10",
+			method:    http.MethodGet,
+			link:      yandexLink,
+			expiresAt: time.Now().Add(time.Hour),
+			want: want{
+				code: http.StatusTemporaryRedirect,
+			},
+		},
+		{
+			name:      "negative test #11",
+			method:    http.MethodGet,
+			link:      yandexLink,
+			expiresAt: time.Now().Add(-time.Minute),
+			want: want{
+				code: http.StatusGone,
+				err:  app.ErrExpiredLink,
+			},
+		},
 	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			short, err := H.store.Write(context.Background(), "", tt.link, models.LinkOptions{ExpiresAt: &tt.expiresAt})
+			require.NoError(t, err)
 
-	w := httptest.NewRecorder()
-	h := http.HandlerFunc(H.ShortenHandler)
-	h.ServeHTTP(w, request)
-}
+			request := httptest.NewRequest(tt.method, "/"+short, nil)
+			w := httptest.NewRecorder()
+			h := http.HandlerFunc(H.GetShortLinkHandler)
+			h.ServeHTTP(w, request)
 
-func ExampleHandlers_GetUserUrlsHandler() {
-	request := httptest.NewRequest(http.MethodGet, "/user/urls", nil)
-	authCookie := &http.Cookie{Name: auth.AuthCookie, Value: "authCookieValue"}
-	request.AddCookie(authCookie)
+			res := w.Result()
+			defer res.Body.Close()
+			require.Equal(t, tt.want.code, res.StatusCode)
 
-	w := httptest.NewRecorder()
-	h := http.HandlerFunc(H.GetUserUrlsHandler)
-	h.ServeHTTP(w, request)
+			n, err := H.store.DeleteExpired(context.Background())
+			require.NoError(t, err)
+			if tt.want.err != nil {
+				require.Equal(t, int64(1), n)
+				return
+			}
+			require.Equal(t, int64(0), n)
+		})
+	}
 }
 
-func ExampleHandlers_BatchHandler() {
-	req, err := json.Marshal([]models.BatchOriginal{
-		{CorrelationID: "1",
-			OriginalURL: gitLink},
-		{CorrelationID: "2",
-			OriginalURL: yandexLink},
-	})
-	if err != nil {
-		log.Fatal(err)
+func TestShortenWithTTL(t *testing.T) {
+	tests := []struct {
+		name string
+		ttl  int64
+		want want
+	}{
+		{
+			name: "positive test #12",
+			ttl:  3600,
+			want: want{
+				code: http.StatusCreated,
+			},
+		},
+		{
+			name: "negative test #13",
+			ttl:  -1,
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrInvalidExpiry,
+			},
+		},
 	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			authCookieValue, err := auth.GetSignature()
+			require.NoError(t, err)
+			authCookie := &http.Cookie{Name: auth.AuthCookie, Value: authCookieValue}
 
-	request := httptest.NewRequest(http.MethodPost, "/user/urls", bytes.NewBuffer(req))
-	w := httptest.NewRecorder()
-	h := http.HandlerFunc(H.BatchHandler)
-	h.ServeHTTP(w, request)
-}
+			body, err := json.Marshal(models.ShortenRequest{URL: yandexLink, LinkOptions: models.LinkOptions{TTL: tt.ttl}})
+			require.NoError(t, err)
 
-func initTestData() {
-	config.SetTestConfig()
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(body))
+			request.AddCookie(authCookie)
+			w := httptest.NewRecorder()
+			h := http.HandlerFunc(H.ShortenHandler)
+			h.ServeHTTP(w, request)
 
-	zapl, err := zap.NewProduction()
-	if err != nil {
-		log.Fatalf("can't initialize zap logger: %v", err)
-	}
-	defer zapl.Sync()
-	logger := zapl.Sugar()
+			res := w.Result()
+			res.Body.Close()
+			require.Equal(t, tt.want.code, res.StatusCode)
+			if tt.want.err != nil {
+				return
+			}
 
-	linksMemoryStore, err := memory.NewLinkMemoryStore()
-	if err != nil {
-		logger.Fatalf("tests init error: %v", err)
-	}
+			request = httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+			request.AddCookie(authCookie)
+			w = httptest.NewRecorder()
+			h = H.GetUserUrlsHandler
+			h.ServeHTTP(w, request)
 
-	ctx := context.Background()
-	wp := app.NewWorkerPool(ctx, logger)
-	H = NewHandlers(linksMemoryStore, wp, logger, ctx)
+			res = w.Result()
+			defer res.Body.Close()
+			var links []models.LinkJSON
+			err = json.NewDecoder(res.Body).Decode(&links)
+			require.NoError(t, err)
+			require.Len(t, links, 1)
+			require.NotNil(t, links[0].ExpiresAt)
+			assert.WithinDuration(t, time.Now().Add(time.Hour), *links[0].ExpiresAt, time.Minute)
+		})
+	}
+}
 
-	err = memory.Clear()
-	if err != nil {
-		logger.Fatalf("tests init error: %v", err)
+func TestOneTimeLink(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxClicks int64
+		requests  int
+		want      want
+	}{
+		{
+			name:      "positive test #14",
+			maxClicks: 1,
+			requests:  20,
+			want: want{
+				code: http.StatusGone,
+				err:  app.ErrExhaustedLink,
+			},
+		},
+		{
+			name:      "positive test #15",
+			maxClicks: 5,
+			requests:  20,
+			want: want{
+				code: http.StatusGone,
+				err:  app.ErrExhaustedLink,
+			},
+		},
 	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			short, err := H.store.Write(context.Background(), "", yandexLink, models.LinkOptions{MaxClicks: tt.maxClicks})
+			require.NoError(t, err)
 
-	_, err = linksMemoryStore.Write(context.Background(), "", gitLink)
-	if err != nil {
-		logger.Fatalf("tests init error: %v", err)
+			codes := make(chan int, tt.requests)
+			var wg sync.WaitGroup
+			for i := 0; i < tt.requests; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					request := httptest.NewRequest(http.MethodGet, "/"+short, nil)
+					w := httptest.NewRecorder()
+					H.GetShortLinkHandler(w, request)
+
+					res := w.Result()
+					defer res.Body.Close()
+					body, _ := io.ReadAll(res.Body)
+					if res.StatusCode == tt.want.code {
+						assert.Contains(t, string(body), tt.want.err.Error())
+					}
+					codes <- res.StatusCode
+				}()
+			}
+			wg.Wait()
+			close(codes)
+
+			redirects := 0
+			for c := range codes {
+				if c == http.StatusTemporaryRedirect {
+					redirects++
+					continue
+				}
+				require.Equal(t, tt.want.code, c)
+			}
+			require.Equal(t, int(tt.maxClicks), redirects)
+		})
 	}
 }
 
+func TestPasswordProtectedLink(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		attempts []string
+		want     want
+	}{
+		{
+			name:     "positive test #16",
+			password: "secret",
+			attempts: []string{"wrong", "secret"},
+			want: want{
+				code: http.StatusSeeOther,
+			},
+		},
+		{
+			name:     "negative test #17",
+			password: "secret",
+			attempts: []string{"1", "2", "3", "4", "5", "secret"},
+			want: want{
+				code: http.StatusTooManyRequests,
+				err:  app.ErrTooManyAttempts,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(models.ShortenRequest{URL: yandexLink, LinkOptions: models.LinkOptions{Password: tt.password}})
+			require.NoError(t, err)
+
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(body))
+			w := httptest.NewRecorder()
+			H.ShortenHandler(w, request)
+			require.Equal(t, http.StatusCreated, w.Code)
+
+			short := app.ShortLink([]byte(yandexLink))
+			request = httptest.NewRequest(http.MethodGet, "/"+short, nil)
+			w = httptest.NewRecorder()
+			H.GetShortLinkHandler(w, request)
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Contains(t, w.Body.String(), `name="password"`)
+
+			var res *http.Response
+			for _, p := range tt.attempts {
+				form := url.Values{"password": {p}}
+				request = httptest.NewRequest(http.MethodPost, "/"+short, strings.NewReader(form.Encode()))
+				request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				w = httptest.NewRecorder()
+				H.UnlockLinkHandler(w, request)
+				res = w.Result()
+				res.Body.Close()
+			}
+
+			require.Equal(t, tt.want.code, res.StatusCode)
+			if tt.want.err != nil {
+				require.Contains(t, w.Body.String(), tt.want.err.Error())
+				return
+			}
+			require.Equal(t, yandexLink, res.Header.Get("Location"))
+
+			request = httptest.NewRequest(http.MethodGet, "/"+short, nil)
+			for _, c := range res.Cookies() {
+				request.AddCookie(c)
+			}
+			w = httptest.NewRecorder()
+			H.GetShortLinkHandler(w, request)
+			require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+			require.Equal(t, yandexLink, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestLinkTakeover(t *testing.T) {
+	tests := []struct {
+		name string
+		opts models.LinkOptions
+		want want
+	}{
+		{
+			name: "negative test #103",
+			want: want{
+				code: http.StatusConflict,
+			},
+		},
+		{
+			name: "negative test #104",
+			opts: models.LinkOptions{Password: "other", Title: "mine"},
+			want: want{
+				code: http.StatusConflict,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			owner, err := auth.GetSignature()
+			require.NoError(t, err)
+			ownerUID, err := auth.CheckSignature(owner)
+			require.NoError(t, err)
+			other, err := auth.GetSignature()
+			require.NoError(t, err)
+
+			body, err := json.Marshal(models.ShortenRequest{URL: yandexLink, LinkOptions: models.LinkOptions{Password: "secret"}})
+			require.NoError(t, err)
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(body))
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: owner})
+			w := httptest.NewRecorder()
+			H.ShortenHandler(w, request)
+			require.Equal(t, http.StatusCreated, w.Code)
+
+			body, err = json.Marshal(models.ShortenRequest{URL: yandexLink, LinkOptions: tt.opts})
+			require.NoError(t, err)
+			request = httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(body))
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: other})
+			w = httptest.NewRecorder()
+			H.ShortenHandler(w, request)
+			require.Equal(t, tt.want.code, w.Code)
+
+			short := app.ShortLink([]byte(yandexLink))
+			info, err := H.store.Lookup(context.Background(), short)
+			require.NoError(t, err)
+			require.Equal(t, ownerUID, info.UUID)
+			require.Empty(t, info.Title)
+
+			request = httptest.NewRequest(http.MethodGet, "/"+short, nil)
+			w = httptest.NewRecorder()
+			H.GetShortLinkHandler(w, request)
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Contains(t, w.Body.String(), `name="password"`)
+		})
+	}
+}
+
+func TestShortenAlias(t *testing.T) {
+	tests := []struct {
+		name  string
+		alias string
+		want  want
+	}{
+		{
+			name:  "negative test #111",
+			alias: "mine",
+			want: want{
+				code: http.StatusCreated,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"url": "` + yandexLink + `", "alias": "` + tt.alias + `"}`
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			H.ShortenHandler(w, request)
+			require.Equal(t, tt.want.code, w.Code)
+
+			// aliases are given by imports only
+			var res models.ShortenResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+			require.Equal(t, app.FullLink(app.ShortLink([]byte(yandexLink))), res.Result)
+		})
+	}
+}
+
+func TestRedirectPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		opts   models.LinkOptions
+		want   want
+		cache  string
+	}{
+		{
+			name:   "positive test #18",
+			method: http.MethodGet,
+			want: want{
+				code: http.StatusTemporaryRedirect,
+			},
+		},
+		{
+			name:   "positive test #19",
+			method: http.MethodHead,
+			opts:   models.LinkOptions{RedirectCode: http.StatusPermanentRedirect, CachePolicy: "public, max-age=86400"},
+			want: want{
+				code: http.StatusPermanentRedirect,
+			},
+			cache: "public, max-age=86400",
+		},
+		{
+			name:   "positive test #20",
+			method: http.MethodGet,
+			opts:   models.LinkOptions{RedirectCode: http.StatusFound, CachePolicy: "no-store"},
+			want: want{
+				code: http.StatusFound,
+			},
+			cache: "no-store",
+		},
+		{
+			name:   "negative test #21",
+			method: http.MethodGet,
+			opts:   models.LinkOptions{RedirectCode: http.StatusOK},
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrInvalidRedirect,
+			},
+		},
+		{
+			name:   "negative test #22",
+			method: http.MethodGet,
+			opts:   models.LinkOptions{CachePolicy: "max-age=1\r\nSet-Cookie: a=b"},
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrInvalidCache,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(models.ShortenRequest{URL: yandexLink, LinkOptions: tt.opts})
+			require.NoError(t, err)
+
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(body))
+			w := httptest.NewRecorder()
+			H.ShortenHandler(w, request)
+			if tt.want.err != nil {
+				require.Equal(t, tt.want.code, w.Code)
+				require.Contains(t, w.Body.String(), tt.want.err.Error())
+				return
+			}
+			require.Equal(t, http.StatusCreated, w.Code)
+
+			request = httptest.NewRequest(tt.method, "/"+app.ShortLink([]byte(yandexLink)), nil)
+			w = httptest.NewRecorder()
+			H.GetShortLinkHandler(w, request)
+
+			require.Equal(t, tt.want.code, w.Code)
+			require.Equal(t, yandexLink, w.Header().Get("Location"))
+			require.Equal(t, tt.cache, w.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestRedirectPassthrough(t *testing.T) {
+	tests := []struct {
+		name string
+		long string
+		path string
+		opts models.LinkOptions
+		want want
+	}{
+		{
+			name: "positive test #23",
+			long: "https://example.com/docs?lang=en",
+			path: "?utm_source=mail&lang=de",
+			opts: models.LinkOptions{ForwardQuery: true},
+			want: want{
+				code:     http.StatusTemporaryRedirect,
+				response: "https://example.com/docs?lang=en&utm_source=mail",
+			},
+		},
+		{
+			name: "positive test #24",
+			long: "https://example.com/docs?lang=en",
+			path: "?lang=de",
+			opts: models.LinkOptions{ForwardQuery: true, QueryConflict: models.QueryConflictRequest},
+			want: want{
+				code:     http.StatusTemporaryRedirect,
+				response: "https://example.com/docs?lang=de",
+			},
+		},
+		{
+			name: "positive test #25",
+			long: "https://example.com/docs/",
+			path: "/guide/../../page?lang=de",
+			opts: models.LinkOptions{ForwardPath: true},
+			want: want{
+				code:     http.StatusTemporaryRedirect,
+				response: "https://example.com/docs/page",
+			},
+		},
+		{
+			name: "positive test #26",
+			long: "https://example.com/docs",
+			path: "?lang=de",
+			want: want{
+				code:     http.StatusTemporaryRedirect,
+				response: "https://example.com/docs",
+			},
+		},
+		{
+			name: "negative test #27",
+			long: "https://example.com/docs",
+			path: "/page",
+			want: want{
+				code: http.StatusNotFound,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			short, err := H.store.Write(context.Background(), "", tt.long, tt.opts)
+			require.NoError(t, err)
+
+			request := httptest.NewRequest(http.MethodGet, "/"+short+tt.path, nil)
+			w := httptest.NewRecorder()
+			H.GetShortLinkHandler(w, request)
+
+			require.Equal(t, tt.want.code, w.Code)
+			require.Equal(t, tt.want.response, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestRedirectRules(t *testing.T) {
+	const (
+		appStore = "https://apps.apple.com/app/1"
+		play     = "https://play.google.com/store/apps/details?id=1"
+		landingA = "https://example.com/a"
+		landingB = "https://example.com/b"
+	)
+	rules := []models.RedirectRule{
+		{Device: "ios", Target: appStore},
+		{Device: "android", Target: play},
+		{Languages: []string{"de"}, Target: yandexLink + "/de"},
+		{Split: []models.SplitTarget{{URL: landingA, Weight: 1}, {URL: landingB, Weight: 1}}},
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    []string
+	}{
+		{
+			name:    "positive test #28",
+			headers: map[string]string{"User-Agent": "Mozilla/5.0 (iPhone; CPU iPhone OS 15_0 like Mac OS X)"},
+			want:    []string{appStore},
+		},
+		{
+			name:    "positive test #29",
+			headers: map[string]string{"User-Agent": "Mozilla/5.0 (Linux; Android 12; Pixel 6)"},
+			want:    []string{play},
+		},
+		{
+			name:    "positive test #30",
+			headers: map[string]string{"Accept-Language": "en;q=0.5, de-DE"},
+			want:    []string{yandexLink + "/de"},
+		},
+		{
+			name:    "positive test #31",
+			headers: map[string]string{"Accept-Language": "en-US"},
+			want:    []string{landingA, landingB},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			authCookieValue, err := auth.GetSignature()
+			require.NoError(t, err)
+			authCookie := &http.Cookie{Name: auth.AuthCookie, Value: authCookieValue}
+
+			uid, err := auth.CheckSignature(authCookieValue)
+			require.NoError(t, err)
+			short, err := H.store.Write(context.Background(), uid, yandexLink, models.LinkOptions{})
+			require.NoError(t, err)
+
+			body, err := json.Marshal(rules)
+			require.NoError(t, err)
+			request := withURLParam(httptest.NewRequest(http.MethodPut, "/api/user/urls/"+short+"/rules", bytes.NewBuffer(body)), "id", short)
+			request.AddCookie(authCookie)
+			w := httptest.NewRecorder()
+			H.SetRulesHandler(w, request)
+			require.Equal(t, http.StatusNoContent, w.Code)
+
+			request = httptest.NewRequest(http.MethodGet, "/"+short, nil)
+			for k, v := range tt.headers {
+				request.Header.Set(k, v)
+			}
+			w = httptest.NewRecorder()
+			H.GetShortLinkHandler(w, request)
+			res := w.Result()
+			res.Body.Close()
+
+			require.Equal(t, http.StatusTemporaryRedirect, res.StatusCode)
+			location := res.Header.Get("Location")
+			require.Contains(t, tt.want, location)
+
+			// split variants are sticky
+			for i := 0; i < 10; i++ {
+				request = httptest.NewRequest(http.MethodGet, "/"+short, nil)
+				for k, v := range tt.headers {
+					request.Header.Set(k, v)
+				}
+				for _, c := range res.Cookies() {
+					request.AddCookie(c)
+				}
+				w = httptest.NewRecorder()
+				H.GetShortLinkHandler(w, request)
+				require.Equal(t, location, w.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestRuleHourWindow(t *testing.T) {
+	hour := func(h int) *int {
+		return &h
+	}
+
+	tests := []struct {
+		name string
+		from *int
+		to   *int
+		want want
+	}{
+		{
+			name: "positive test #101",
+			from: hour(0),
+			to:   hour(24),
+			want: want{code: http.StatusNoContent},
+		},
+		{
+			name: "negative test #102",
+			from: hour(9),
+			to:   hour(9),
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrInvalidRules,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			authCookieValue, err := auth.GetSignature()
+			require.NoError(t, err)
+			uid, err := auth.CheckSignature(authCookieValue)
+			require.NoError(t, err)
+			short, err := H.store.Write(context.Background(), uid, yandexLink+"/hours/"+uid, models.LinkOptions{})
+			require.NoError(t, err)
+
+			body, err := json.Marshal([]models.RedirectRule{{HourFrom: tt.from, HourTo: tt.to, Target: yandexLink}})
+			require.NoError(t, err)
+			request := withURLParam(httptest.NewRequest(http.MethodPut, "/api/user/urls/"+short+"/rules", bytes.NewBuffer(body)), "id", short)
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: authCookieValue})
+			w := httptest.NewRecorder()
+			H.SetRulesHandler(w, request)
+			require.Equal(t, tt.want.code, w.Code)
+			if tt.want.err != nil {
+				require.Contains(t, w.Body.String(), tt.want.err.Error())
+				return
+			}
+
+			w = httptest.NewRecorder()
+			H.GetShortLinkHandler(w, httptest.NewRequest(http.MethodGet, "/"+short, nil))
+			require.Equal(t, yandexLink, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestRuleSplitLimits(t *testing.T) {
+	split := func(n, weight int) []models.SplitTarget {
+		res := make([]models.SplitTarget, n)
+		for i := range res {
+			res[i] = models.SplitTarget{URL: yandexLink + "/" + strconv.Itoa(i), Weight: weight}
+		}
+		return res
+	}
+
+	tests := []struct {
+		name  string
+		split []models.SplitTarget
+		want  want
+	}{
+		{
+			name:  "positive test #119",
+			split: split(20, 10000),
+			want:  want{code: http.StatusNoContent},
+		},
+		{
+			name:  "negative test #120",
+			split: split(2, math.MaxInt/2+1),
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrInvalidRules,
+			},
+		},
+		{
+			name:  "negative test #121",
+			split: split(21, 1),
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrInvalidRules,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			authCookieValue, err := auth.GetSignature()
+			require.NoError(t, err)
+			uid, err := auth.CheckSignature(authCookieValue)
+			require.NoError(t, err)
+			short, err := H.store.Write(context.Background(), uid, yandexLink+"/split/"+uid, models.LinkOptions{})
+			require.NoError(t, err)
+
+			body, err := json.Marshal([]models.RedirectRule{{Split: tt.split}})
+			require.NoError(t, err)
+			request := withURLParam(httptest.NewRequest(http.MethodPut, "/api/user/urls/"+short+"/rules", bytes.NewBuffer(body)), "id", short)
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: authCookieValue})
+			w := httptest.NewRecorder()
+			H.SetRulesHandler(w, request)
+			require.Equal(t, tt.want.code, w.Code)
+			if tt.want.err != nil {
+				require.Contains(t, w.Body.String(), tt.want.err.Error())
+				return
+			}
+
+			w = httptest.NewRecorder()
+			H.GetShortLinkHandler(w, httptest.NewRequest(http.MethodGet, "/"+short, nil))
+			require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		})
+	}
+}
+
+func TestScheduledLink(t *testing.T) {
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	tests := []struct {
+		name string
+		opts models.LinkOptions
+		want want
+	}{
+		{
+			name: "positive test #32",
+			opts: models.LinkOptions{ActiveFrom: &past, ActiveUntil: &future},
+			want: want{
+				code: http.StatusTemporaryRedirect,
+			},
+		},
+		{
+			name: "negative test #33",
+			opts: models.LinkOptions{ActiveFrom: &future},
+			want: want{
+				code: http.StatusServiceUnavailable,
+				err:  app.ErrLinkNotActive,
+			},
+		},
+		{
+			name: "negative test #34",
+			opts: models.LinkOptions{ActiveUntil: &past},
+			want: want{
+				code: http.StatusGone,
+				err:  app.ErrLinkInactive,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			short, err := H.store.Write(context.Background(), "", yandexLink, tt.opts)
+			require.NoError(t, err)
+
+			request := httptest.NewRequest(http.MethodGet, "/"+short, nil)
+			w := httptest.NewRecorder()
+			H.GetShortLinkHandler(w, request)
+
+			require.Equal(t, tt.want.code, w.Code)
+			if tt.want.err != nil {
+				require.Contains(t, w.Body.String(), tt.want.err.Error())
+				if tt.want.code == http.StatusServiceUnavailable {
+					require.NotEmpty(t, w.Header().Get("Retry-After"))
+				}
+				return
+			}
+			require.Equal(t, yandexLink, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestLinkPreview(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    models.LinkOptions
+		path    string
+		headers map[string]string
+		want    want
+	}{
+		{
+			name: "positive test #35",
+			opts: models.LinkOptions{Title: "Search engine"},
+			path: "+",
+			want: want{
+				code:     http.StatusOK,
+				response: yandexLink,
+			},
+		},
+		{
+			name:    "positive test #36",
+			opts:    models.LinkOptions{Title: "Search engine"},
+			path:    "?preview",
+			headers: map[string]string{"Accept": "text/html"},
+			want: want{
+				code:     http.StatusOK,
+				response: "Search engine",
+			},
+		},
+		{
+			name: "positive test #37",
+			opts: models.LinkOptions{Preview: true},
+			want: want{
+				code:     http.StatusOK,
+				response: `name="confirm"`,
+			},
+		},
+		{
+			name: "negative test #38",
+			opts: models.LinkOptions{Password: "secret"},
+			path: "+",
+			want: want{
+				code:     http.StatusOK,
+				response: "password-protected",
+			},
+		},
+		{
+			name: "negative test #39",
+			path: "?preview",
+			want: want{
+				code: http.StatusTemporaryRedirect,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, prepareLinkOptions(&tt.opts))
+			short, err := H.store.Write(context.Background(), "", yandexLink, tt.opts)
+			require.NoError(t, err)
+
+			request := httptest.NewRequest(http.MethodGet, "/"+short+tt.path, nil)
+			for k, v := range tt.headers {
+				request.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			H.GetShortLinkHandler(w, request)
+
+			require.Equal(t, tt.want.code, w.Code)
+			require.Contains(t, w.Body.String(), tt.want.response)
+			if tt.opts.PasswordHash != "" {
+				require.NotContains(t, w.Body.String(), yandexLink)
+			}
+		})
+	}
+}
+
+func TestInterstitialClickLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  models.LinkOptions
+		views int
+		want  want
+	}{
+		{
+			name:  "positive test #105",
+			opts:  models.LinkOptions{Preview: true, MaxClicks: 1},
+			views: 3,
+			want: want{
+				code: http.StatusSeeOther,
+			},
+		},
+		{
+			name: "negative test #106",
+			opts: models.LinkOptions{Preview: true, MaxClicks: 1},
+			want: want{
+				code: http.StatusGone,
+				err:  app.ErrExhaustedLink,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, prepareLinkOptions(&tt.opts))
+			short, err := H.store.Write(context.Background(), "", yandexLink, tt.opts)
+			require.NoError(t, err)
+
+			// showing the interstitial must not use up the click
+			for i := 0; i < tt.views; i++ {
+				request := httptest.NewRequest(http.MethodGet, "/"+short, nil)
+				w := httptest.NewRecorder()
+				H.GetShortLinkHandler(w, request)
+				require.Equal(t, http.StatusOK, w.Code)
+				require.Contains(t, w.Body.String(), `name="confirm"`)
+			}
+
+			confirm := func() *httptest.ResponseRecorder {
+				form := url.Values{"confirm": {"1"}}
+				request := httptest.NewRequest(http.MethodPost, "/"+short, strings.NewReader(form.Encode()))
+				request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				w := httptest.NewRecorder()
+				H.UnlockLinkHandler(w, request)
+				return w
+			}
+
+			w := confirm()
+			require.Equal(t, http.StatusSeeOther, w.Code)
+			require.Equal(t, yandexLink, w.Header().Get("Location"))
+			if tt.want.err == nil {
+				return
+			}
+
+			w = confirm()
+			require.Equal(t, tt.want.code, w.Code)
+			require.Contains(t, w.Body.String(), tt.want.err.Error())
+		})
+	}
+}
+
+func TestQRHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		short       string
+		query       string
+		contentType string
+		want        want
+	}{
+		{
+			name:        "positive test #40",
+			short:       app.ShortLink([]byte(gitLink)),
+			contentType: "image/png",
+			want: want{
+				code:     http.StatusOK,
+				response: "\x89PNG",
+			},
+		},
+		{
+			name:        "positive test #41",
+			short:       app.ShortLink([]byte(gitLink)),
+			query:       "?format=svg&size=512&ecc=h",
+			contentType: "image/svg+xml",
+			want: want{
+				code:     http.StatusOK,
+				response: "<svg",
+			},
+		},
+		{
+			name:  "negative test #42",
+			short: app.ShortLink([]byte(yandexLink)),
+			want: want{
+				code: http.StatusNotFound,
+				err:  app.ErrLinkNotFound,
+			},
+		},
+		{
+			name:  "negative test #43",
+			short: app.ShortLink([]byte(gitLink)),
+			query: "?size=10",
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrInvalidQRSize,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			request := withURLParam(httptest.NewRequest(http.MethodGet, "/api/qr/"+tt.short+tt.query, nil), "id", tt.short)
+			w := httptest.NewRecorder()
+			H.QRHandler(w, request)
+
+			require.Equal(t, tt.want.code, w.Code)
+			if tt.want.err != nil {
+				require.Contains(t, w.Body.String(), tt.want.err.Error())
+				return
+			}
+			require.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			require.True(t, strings.HasPrefix(w.Body.String(), tt.want.response))
+			require.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+			etag := w.Header().Get("ETag")
+
+			request = withURLParam(httptest.NewRequest(http.MethodGet, "/api/qr/"+tt.short+tt.query, nil), "id", tt.short)
+			request.Header.Set("If-None-Match", etag)
+			w = httptest.NewRecorder()
+			H.QRHandler(w, request)
+			require.Equal(t, http.StatusNotModified, w.Code)
+
+			require.NoError(t, H.store.Delete(context.Background(), "", tt.short))
+			request = withURLParam(httptest.NewRequest(http.MethodGet, "/api/qr/"+tt.short+tt.query, nil), "id", tt.short)
+			request.Header.Set("If-None-Match", etag)
+			w = httptest.NewRecorder()
+			H.QRHandler(w, request)
+			require.Equal(t, http.StatusNotFound, w.Code)
+		})
+	}
+}
+
+func TestBatchQR(t *testing.T) {
+	initTestData()
+
+	req, err := json.Marshal([]models.BatchOriginal{{CorrelationID: "1", OriginalURL: yandexLink}})
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodPost, "/api/shorten/batch?qr=svg", bytes.NewBuffer(req))
+	w := httptest.NewRecorder()
+	H.BatchHandler(w, request)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var res []models.BatchShort
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	require.Len(t, res, 1)
+	require.True(t, strings.HasPrefix(res[0].QR, "data:image/svg+xml;base64,"))
+}
+
+func TestScreening(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains string
+		rules   string
+		link    string
+		want    want
+	}{
+		{
+			name:    "negative test #44",
+			domains: "# phishing\nyandex.ru\n",
+			link:    "https://mail.yandex.ru/login",
+			want: want{
+				code: http.StatusUnprocessableEntity,
+				err:  app.ErrBlockedURL,
+			},
+		},
+		{
+			name:  "negative test #45",
+			rules: `^https?://[^/]+/wp-admin/`,
+			link:  "https://example.com/wp-admin/login.php",
+			want: want{
+				code: http.StatusUnprocessableEntity,
+				err:  app.ErrBlockedURL,
+			},
+		},
+		{
+			name:    "positive test #46",
+			domains: "yandex.ru\n",
+			link:    "https://notyandex.ru",
+			want: want{
+				code: http.StatusCreated,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			domains, rules := filepath.Join(dir, "domains.txt"), filepath.Join(dir, "rules.txt")
+			screener, err := screening.New(domains, rules)
+			require.NoError(t, err)
+			H.screener = screener
+
+			short, err := H.store.Write(context.Background(), "", tt.link+"/old", models.LinkOptions{})
+			require.NoError(t, err)
+
+			require.NoError(t, os.WriteFile(domains, []byte(tt.domains), 0644))
+			require.NoError(t, os.WriteFile(rules, []byte(tt.rules), 0644))
+			reloaded, err := screener.Reload()
+			require.NoError(t, err)
+			require.True(t, reloaded)
+
+			body, err := json.Marshal(models.ShortenRequest{URL: tt.link})
+			require.NoError(t, err)
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(body))
+			w := httptest.NewRecorder()
+			H.ShortenHandler(w, request)
+			require.Equal(t, tt.want.code, w.Code)
+
+			request = httptest.NewRequest(http.MethodGet, "/"+short, nil)
+			w = httptest.NewRecorder()
+			H.GetShortLinkHandler(w, request)
+			if tt.want.err != nil {
+				require.Contains(t, w.Body.String(), "blocked")
+				require.Equal(t, http.StatusForbidden, w.Code)
+				return
+			}
+			require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+		})
+	}
+}
+
+func TestBrokenScreeningList(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "rules.txt")
+	require.NoError(t, os.WriteFile(rules, []byte("(wp-admin\n"), 0644))
+	t.Setenv("BLOCKLIST_RULES_FILE", rules)
+	initTestData()
+
+	shorten := func(link string) int {
+		body, err := json.Marshal(models.ShortenRequest{URL: link})
+		require.NoError(t, err)
+		request := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(body))
+		w := httptest.NewRecorder()
+		H.ShortenHandler(w, request)
+		return w.Code
+	}
+
+	// the broken list is replaced with an empty one at startup
+	require.Equal(t, http.StatusCreated, shorten("https://example.com/wp-admin/"))
+
+	require.NoError(t, os.WriteFile(rules, []byte("^https?://[^/]+/wp-admin/\n"), 0644))
+	reloaded, err := H.screener.Reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	require.Equal(t, http.StatusUnprocessableEntity, shorten("https://example.com/wp-admin/login.php"))
+}
+
+func TestHealthCheck(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+	gone := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer gone.Close()
+	noHead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer noHead.Close()
+
+	tests := []struct {
+		name    string
+		link    string
+		healthy bool
+		status  int
+	}{
+		{
+			name:    "positive test #47",
+			link:    ok.URL,
+			healthy: true,
+			status:  http.StatusOK,
+		},
+		{
+			name:    "negative test #48",
+			link:    gone.URL + "/missing",
+			healthy: false,
+			status:  http.StatusNotFound,
+		},
+		{
+			name:    "positive test #49",
+			link:    noHead.URL,
+			healthy: true,
+			status:  http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			config.Config().OutboundAllowPrivate = "true"
+			checker := health.NewChecker(H.store, H.logger)
+			// keep the checker off the network: preloaded links are fresh
+			preloaded, err := H.store.HealthTargets(context.Background(), time.Now(), 1000)
+			require.NoError(t, err)
+			for _, l := range preloaded {
+				require.NoError(t, H.store.SetHealth(context.Background(), l.Short,
+					models.LinkHealth{Healthy: true, CheckedAt: time.Now()}))
+			}
+
+			authCookieValue, err := auth.GetSignature()
+			require.NoError(t, err)
+			uid, err := auth.CheckSignature(authCookieValue)
+			require.NoError(t, err)
+			short, err := H.store.Write(context.Background(), uid, tt.link, models.LinkOptions{})
+			require.NoError(t, err)
+
+			n, err := checker.CheckDue(context.Background(), time.Now())
+			require.NoError(t, err)
+			require.Equal(t, 1, n)
+
+			request := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: authCookieValue})
+			w := httptest.NewRecorder()
+			H.GetUserUrlsHandler(w, request)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var links []models.LinkJSON
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&links))
+			require.Len(t, links, 1)
+			require.NotNil(t, links[0].Health)
+			assert.Equal(t, tt.healthy, links[0].Health.Healthy)
+			assert.Equal(t, tt.status, links[0].Health.Status)
+			assert.False(t, links[0].Health.CheckedAt.IsZero())
+
+			request = httptest.NewRequest(http.MethodGet, "/api/user/urls/broken", nil)
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: authCookieValue})
+			w = httptest.NewRecorder()
+			H.BrokenLinksHandler(w, request)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var broken []models.LinkJSON
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&broken))
+			if tt.healthy {
+				require.Empty(t, broken)
+				return
+			}
+			require.Len(t, broken, 1)
+			assert.Equal(t, app.FullLink(short), broken[0].Short)
+
+			n, err = checker.CheckDue(context.Background(), time.Now())
+			require.NoError(t, err)
+			require.Zero(t, n)
+		})
+	}
+}
+
+func TestEditLink(t *testing.T) {
+	const typoLink = "https://githb.com/DrGermanius"
+
+	tests := []struct {
+		name      string
+		patch     string
+		otherUser bool
+		rollback  int
+		want      want
+	}{
+		{
+			name:     "positive test #50",
+			patch:    `{"url": "https://github.com/DrGermanius", "title": "profile"}`,
+			rollback: 1,
+			want: want{
+				code:     http.StatusOK,
+				response: "https://github.com/DrGermanius",
+			},
+		},
+		{
+			name:  "negative test #51",
+			patch: `{"redirect_code": 200}`,
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrInvalidRedirect,
+			},
+		},
+		{
+			name:      "negative test #52",
+			patch:     `{"url": "https://github.com/DrGermanius"}`,
+			otherUser: true,
+			want: want{
+				code: http.StatusNotFound,
+				err:  app.ErrLinkNotFound,
+			},
+		},
+		{
+			name:     "negative test #53",
+			patch:    `{"preview": true}`,
+			rollback: 9,
+			want: want{
+				code:     http.StatusOK,
+				response: typoLink,
+				err:      app.ErrRevisionNotFound,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			owner, err := auth.GetSignature()
+			require.NoError(t, err)
+			uid, err := auth.CheckSignature(owner)
+			require.NoError(t, err)
+			short, err := H.store.Write(context.Background(), uid, typoLink, models.LinkOptions{})
+			require.NoError(t, err)
+
+			cookie := owner
+			if tt.otherUser {
+				cookie, err = auth.GetSignature()
+				require.NoError(t, err)
+			}
+
+			request := withURLParam(httptest.NewRequest(http.MethodPatch, "/api/user/urls/"+short, strings.NewReader(tt.patch)), "id", short)
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: cookie})
+			w := httptest.NewRecorder()
+			H.EditLinkHandler(w, request)
+			require.Equal(t, tt.want.code, w.Code)
+			if tt.rollback == 0 {
+				require.Contains(t, w.Body.String(), tt.want.err.Error())
+				return
+			}
+
+			var l models.LinkJSON
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&l))
+			assert.Equal(t, tt.want.response, l.Long)
+
+			request = withURLParam(httptest.NewRequest(http.MethodGet, "/api/user/urls/"+short+"/history", nil), "id", short)
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: owner})
+			w = httptest.NewRecorder()
+			H.LinkHistoryHandler(w, request)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var history []models.RevisionJSON
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+			require.Len(t, history, 2)
+			assert.Equal(t, typoLink, history[0].Link.Long)
+			assert.Equal(t, uid, history[1].Author)
+
+			body := fmt.Sprintf(`{"revision": %d}`, tt.rollback)
+			request = withURLParam(httptest.NewRequest(http.MethodPost, "/api/user/urls/"+short+"/rollback", strings.NewReader(body)), "id", short)
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: owner})
+			w = httptest.NewRecorder()
+			H.RollbackLinkHandler(w, request)
+			if tt.want.err != nil {
+				require.Equal(t, http.StatusNotFound, w.Code)
+				require.Contains(t, w.Body.String(), tt.want.err.Error())
+				return
+			}
+			require.Equal(t, http.StatusOK, w.Code)
+
+			request = httptest.NewRequest(http.MethodGet, "/"+short, nil)
+			w = httptest.NewRecorder()
+			H.GetShortLinkHandler(w, request)
+			require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+			assert.Equal(t, typoLink, w.Header().Get("Location"))
+
+			revisions, err := H.store.Revisions(context.Background(), short)
+			require.NoError(t, err)
+			require.Len(t, revisions, 3)
+			assert.Equal(t, "", revisions[2].Link.Title)
+		})
+	}
+}
+
+func TestEditedLinkDestination(t *testing.T) {
+	const (
+		typoLink  = "https://githb.com/DrGermanius"
+		fixedLink = "https://github.com/DrGermanius"
+	)
+
+	tests := []struct {
+		name   string
+		link   string
+		edited bool
+		want   want
+	}{
+		{
+			name: "positive test #107",
+			link: typoLink,
+			want: want{
+				code: http.StatusCreated,
+			},
+		},
+		{
+			name:   "negative test #108",
+			link:   fixedLink,
+			edited: true,
+			want: want{
+				code: http.StatusConflict,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			owner, err := auth.GetSignature()
+			require.NoError(t, err)
+			uid, err := auth.CheckSignature(owner)
+			require.NoError(t, err)
+			edited, err := H.store.Write(context.Background(), uid, typoLink, models.LinkOptions{})
+			require.NoError(t, err)
+
+			patch := `{"url": "` + fixedLink + `"}`
+			request := withURLParam(httptest.NewRequest(http.MethodPatch, "/api/user/urls/"+edited, strings.NewReader(patch)), "id", edited)
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: owner})
+			w := httptest.NewRecorder()
+			H.EditLinkHandler(w, request)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			body, err := json.Marshal(models.ShortenRequest{URL: tt.link})
+			require.NoError(t, err)
+			request = httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(body))
+			w = httptest.NewRecorder()
+			H.ShortenHandler(w, request)
+			require.Equal(t, tt.want.code, w.Code)
+
+			var res models.ShortenResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+			short := strings.TrimPrefix(res.Result, config.Config().BaseURL+"/")
+			if tt.edited {
+				require.Equal(t, edited, short)
+				return
+			}
+			require.NotEqual(t, edited, short)
+
+			// the new link must lead to its own address, not to the edited destination
+			request = httptest.NewRequest(http.MethodGet, "/"+short, nil)
+			w = httptest.NewRecorder()
+			H.GetShortLinkHandler(w, request)
+			require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+			require.Equal(t, tt.link, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestRollbackRevalidation(t *testing.T) {
+	tests := []struct {
+		name string
+		want want
+	}{
+		{
+			name: "negative test #109",
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrInvalidExpiry,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			owner, err := auth.GetSignature()
+			require.NoError(t, err)
+			uid, err := auth.CheckSignature(owner)
+			require.NoError(t, err)
+			soon := time.Now().Add(time.Second)
+			short, err := H.store.Write(context.Background(), uid, yandexLink, models.LinkOptions{ExpiresAt: &soon})
+			require.NoError(t, err)
+
+			request := withURLParam(httptest.NewRequest(http.MethodPatch, "/api/user/urls/"+short, strings.NewReader(`{"expires_at": null}`)), "id", short)
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: owner})
+			w := httptest.NewRecorder()
+			H.EditLinkHandler(w, request)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			// revision 1 still has the expiry which has passed by now
+			time.Sleep(time.Until(soon))
+			request = withURLParam(httptest.NewRequest(http.MethodPost, "/api/user/urls/"+short+"/rollback", strings.NewReader(`{"revision": 1}`)), "id", short)
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: owner})
+			w = httptest.NewRecorder()
+			H.RollbackLinkHandler(w, request)
+			require.Equal(t, tt.want.code, w.Code)
+			require.Contains(t, w.Body.String(), tt.want.err.Error())
+
+			l, err := H.store.Lookup(context.Background(), short)
+			require.NoError(t, err)
+			require.True(t, l.ExpiresAt.IsZero())
+		})
+	}
+}
+
+func TestLinkFilters(t *testing.T) {
+	links := []string{
+		`{"url": "https://github.com/DrGermanius/Shortener", "tags": ["Go", " work "], "folder": "projects", "title": "Shortener"}`,
+		`{"url": "https://go.dev/doc", "tags": ["go"], "notes": "language reference"}`,
+		`{"url": "https://yandex.ru/maps", "folder": "projects", "notes": "office route"}`,
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  want
+		count int
+	}{
+		{
+			name:  "positive test #54",
+			query: "?tag=go",
+			want:  want{code: http.StatusOK},
+			count: 2,
+		},
+		{
+			name:  "positive test #55",
+			query: "?tag=GO&folder=projects",
+			want:  want{code: http.StatusOK},
+			count: 1,
+		},
+		{
+			name:  "positive test #56",
+			query: "?q=Reference+go.dev",
+			want:  want{code: http.StatusOK},
+			count: 1,
+		},
+		{
+			name:  "positive test #57",
+			query: "?q=route&tag=work",
+			want:  want{code: http.StatusNoContent},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			authCookieValue, err := auth.GetSignature()
+			require.NoError(t, err)
+
+			for _, l := range links {
+				request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(l))
+				request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: authCookieValue})
+				w := httptest.NewRecorder()
+				H.ShortenHandler(w, request)
+				require.Equal(t, http.StatusCreated, w.Code)
+			}
+
+			request := httptest.NewRequest(http.MethodGet, "/api/user/urls"+tt.query, nil)
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: authCookieValue})
+			w := httptest.NewRecorder()
+			H.GetUserUrlsHandler(w, request)
+			require.Equal(t, tt.want.code, w.Code)
+			if tt.count == 0 {
+				return
+			}
+
+			var res []models.LinkJSON
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+			require.Len(t, res, tt.count)
+			for _, l := range res {
+				assert.NotContains(t, l.Tags, "Go")
+			}
+		})
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/api/shorten",
+		strings.NewReader(`{"url": "https://go.dev", "folder": "`+strings.Repeat("f", 101)+`"}`))
+	w := httptest.NewRecorder()
+	H.ShortenHandler(w, request)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), app.ErrInvalidFolder.Error())
+}
+
+func TestClickStats(t *testing.T) {
+	geoDB := filepath.Join(t.TempDir(), "geoip.csv")
+	require.NoError(t, os.WriteFile(geoDB, []byte("start_ip,end_ip,country\n1.2.3.0,1.2.3.255,DE\n"+
+		`"84281088","84281343","FR","France"`+"\n"), 0644))
+	t.Setenv("GEOIP_DB_FILE", geoDB)
+
+	type visit struct {
+		method    string
+		ip        string
+		referrer  string
+		userAgent string
+	}
+	tests := []struct {
+		name      string
+		visits    []visit
+		query     string
+		otherUser bool
+		want      want
+		stats     models.LinkStats
+	}{
+		{
+			name: "positive test #77",
+			visits: []visit{
+				{method: http.MethodGet, ip: "1.2.3.4", referrer: "https://www.news.example/post", userAgent: "Mozilla/5.0 (iPhone)"},
+				{method: http.MethodGet, ip: "5.6.7.8", referrer: "https://news.example/", userAgent: "Mozilla/5.0 (X11; Linux)"},
+				{method: http.MethodGet, ip: "9.9.9.9"},
+			},
+			want: want{code: http.StatusOK},
+			stats: models.LinkStats{
+				Clicks:         3,
+				UniqueVisitors: 3,
+				Referrers:      []models.StatsEntry{{Value: "news.example", Clicks: 2}, {Value: "", Clicks: 1}},
+				Countries:      []models.StatsEntry{{Value: "", Clicks: 1}, {Value: "DE", Clicks: 1}, {Value: "FR", Clicks: 1}},
+				Devices:        []models.StatsEntry{{Value: "desktop", Clicks: 2}, {Value: "ios", Clicks: 1}},
+			},
+		},
+		{
+			name: "positive test #78",
+			visits: []visit{
+				{method: http.MethodHead, ip: "1.2.3.4"},
+				{method: http.MethodGet, ip: "1.2.3.4", userAgent: "curl/7.79.1"},
+			},
+			want: want{code: http.StatusOK},
+			stats: models.LinkStats{
+				Clicks:         1,
+				UniqueVisitors: 1,
+				Referrers:      []models.StatsEntry{{Value: "", Clicks: 1}},
+				Countries:      []models.StatsEntry{{Value: "DE", Clicks: 1}},
+				Devices:        []models.StatsEntry{{Value: "bot", Clicks: 1}},
+			},
+		},
+		{
+			name:      "negative test #79",
+			otherUser: true,
+			want: want{
+				code: http.StatusNotFound,
+				err:  app.ErrLinkNotFound,
+			},
+		},
+		{
+			name:  "negative test #115",
+			query: "?from=2020-01-01&to=2023-01-01",
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrInvalidTimeRange,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			authCookieValue, err := auth.GetSignature()
+			require.NoError(t, err)
+			uid, err := auth.CheckSignature(authCookieValue)
+			require.NoError(t, err)
+			short, err := H.store.Write(context.Background(), uid, "https://go.dev/stats/"+uid, models.LinkOptions{})
+			require.NoError(t, err)
+
+			for _, v := range tt.visits {
+				request := httptest.NewRequest(v.method, "/"+short, nil)
+				request.RemoteAddr = v.ip + ":40000"
+				request.Header.Set("Referer", v.referrer)
+				request.Header.Set("User-Agent", v.userAgent)
+				w := httptest.NewRecorder()
+				H.GetShortLinkHandler(w, request)
+				require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+			}
+
+			if tt.otherUser {
+				authCookieValue, err = auth.GetSignature()
+				require.NoError(t, err)
+			}
+			var stats models.LinkStats
+			require.Eventually(t, func() bool {
+				request := withURLParam(httptest.NewRequest(http.MethodGet, "/api/user/urls/"+short+"/stats"+tt.query, nil), "id", short)
+				request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: authCookieValue})
+				w := httptest.NewRecorder()
+				H.LinkStatsHandler(w, request)
+				require.Equal(t, tt.want.code, w.Code)
+				if tt.want.err != nil {
+					require.Contains(t, w.Body.String(), tt.want.err.Error())
+					return true
+				}
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&stats))
+				return stats.Clicks == tt.stats.Clicks
+			}, 5*time.Second, 50*time.Millisecond)
+			if tt.want.err != nil {
+				return
+			}
+
+			assert.Equal(t, "http://localhost:8080/"+short, stats.ShortURL)
+			assert.Equal(t, tt.stats.Referrers, stats.Referrers)
+			assert.Equal(t, tt.stats.Countries, stats.Countries)
+			assert.Equal(t, tt.stats.Devices, stats.Devices)
+			require.NotNil(t, stats.FirstClick)
+			require.NotNil(t, stats.LastClick)
+			assert.WithinDuration(t, time.Now().Add(-defaultVisitorsSpan), stats.UniqueVisitorsFrom, 24*time.Hour)
+			assert.Equal(t, tt.stats.UniqueVisitors, stats.UniqueVisitors)
+		})
+	}
+}
+
+func TestClickTimeSeries(t *testing.T) {
+	const (
+		chromeWindows = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0 Safari/537.36"
+		firefoxLinux  = "Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/118.0"
+		safariIPhone  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1"
+	)
+	start := time.Date(2023, 10, 1, 10, 0, 0, 0, time.UTC)
+	clicks := []models.Click{
+		{Time: start.Add(5 * time.Minute), UserAgent: chromeWindows, Referrer: "https://news.example/a"},
+		{Time: start.Add(59 * time.Minute), UserAgent: chromeWindows},
+		{Time: start.Add(2*time.Hour + time.Second), UserAgent: firefoxLinux, Referrer: "https://news.example/b"},
+		{Time: start.Add(26 * time.Hour), UserAgent: safariIPhone},
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		want      want
+		clicks    []int64
+		browsers  [][]models.StatsEntry
+		os        []models.StatsEntry
+		referrers []models.StatsEntry
+	}{
+		{
+			name:   "positive test #80",
+			query:  "?interval=hour&from=2023-10-01T10:30:00Z&to=2023-10-01T13:00:00Z",
+			want:   want{code: http.StatusOK},
+			clicks: []int64{2, 0, 1},
+			browsers: [][]models.StatsEntry{
+				{{Value: "chrome", Clicks: 2}},
+				{},
+				{{Value: "firefox", Clicks: 1}},
+			},
+			os:        []models.StatsEntry{{Value: "windows", Clicks: 2}},
+			referrers: []models.StatsEntry{{Value: "", Clicks: 1}, {Value: "news.example", Clicks: 1}},
+		},
+		{
+			name:   "positive test #81",
+			query:  "?interval=day&from=2023-10-01&to=2023-10-03",
+			want:   want{code: http.StatusOK},
+			clicks: []int64{3, 1},
+			browsers: [][]models.StatsEntry{
+				{{Value: "chrome", Clicks: 2}, {Value: "firefox", Clicks: 1}},
+				{{Value: "safari", Clicks: 1}},
+			},
+			os:        []models.StatsEntry{{Value: "windows", Clicks: 2}, {Value: "linux", Clicks: 1}},
+			referrers: []models.StatsEntry{{Value: "news.example", Clicks: 2}, {Value: "", Clicks: 1}},
+		},
+		{
+			name:  "negative test #82",
+			query: "?interval=week",
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrInvalidInterval,
+			},
+		},
+		{
+			name:  "negative test #83",
+			query: "?interval=minute&from=2023-10-01&to=2023-10-03",
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrInvalidTimeRange,
+			},
+		},
+		{
+			name:  "negative test #114",
+			query: "?interval=minute&from=2023-10-01T10:00:00Z&to=2023-10-01T11:00:00Z",
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrInvalidTimeRange,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			authCookieValue, err := auth.GetSignature()
+			require.NoError(t, err)
+			uid, err := auth.CheckSignature(authCookieValue)
+			require.NoError(t, err)
+			short, err := H.store.Write(context.Background(), uid, "https://go.dev/series/"+uid, models.LinkOptions{})
+			require.NoError(t, err)
+			for i := range clicks {
+				clicks[i].Short = short
+			}
+			require.NoError(t, H.store.AddClicks(context.Background(), clicks))
+
+			request := withURLParam(httptest.NewRequest(http.MethodGet, "/api/user/urls/"+short+"/stats/timeseries"+tt.query, nil), "id", short)
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: authCookieValue})
+			w := httptest.NewRecorder()
+			H.LinkTimeSeriesHandler(w, request)
+			require.Equal(t, tt.want.code, w.Code)
+			if tt.want.err != nil {
+				require.Contains(t, w.Body.String(), tt.want.err.Error())
+				return
+			}
+
+			var series models.TimeSeries
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&series))
+			require.Len(t, series.Buckets, len(tt.clicks))
+			for i, b := range series.Buckets {
+				assert.Equal(t, tt.clicks[i], b.Clicks)
+				assert.Equal(t, tt.browsers[i], b.Browsers)
+			}
+			assert.True(t, series.Buckets[0].Start.Equal(series.From))
+			assert.Equal(t, tt.os, series.Buckets[0].OS)
+			assert.Equal(t, tt.referrers, series.Buckets[0].Referrers)
+		})
+	}
+}
+
+func TestUniqueVisitors(t *testing.T) {
+	day := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	visits := func(visitors, repeats int, userAgent string) []models.Click {
+		var clicks []models.Click
+		for r := 0; r < repeats; r++ {
+			for v := 0; v < visitors; v++ {
+				clicks = append(clicks, models.Click{
+					Time:      day.Add(time.Duration(v%48) * time.Hour),
+					IPHash:    fmt.Sprintf("visitor-%d", v),
+					UserAgent: userAgent,
+				})
+			}
+		}
+		return clicks
+	}
+
+	tests := []struct {
+		name   string
+		clicks []models.Click
+		want   int64
+		daily  []int64
+	}{
+		{
+			name:   "positive test #84",
+			clicks: visits(5000, 3, "Firefox/118.0"),
+			want:   5000,
+			daily:  []int64{2500, 2500},
+		},
+		{
+			name:   "positive test #85",
+			clicks: visits(3, 10, "Firefox/118.0"),
+			want:   3,
+			daily:  []int64{3, 0},
+		},
+		{
+			name:   "positive test #86",
+			clicks: append(append(visits(2, 1, "Firefox/118.0"), visits(2, 1, "Chrome/118.0")...), models.Click{Time: day}),
+			want:   4,
+			daily:  []int64{4, 0},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			authCookieValue, err := auth.GetSignature()
+			require.NoError(t, err)
+			uid, err := auth.CheckSignature(authCookieValue)
+			require.NoError(t, err)
+			short, err := H.store.Write(context.Background(), uid, "https://go.dev/visitors/"+uid, models.LinkOptions{})
+			require.NoError(t, err)
+			for i := range tt.clicks {
+				tt.clicks[i].Short = short
+			}
+			require.NoError(t, H.store.AddClicks(context.Background(), tt.clicks))
+
+			request := withURLParam(httptest.NewRequest(http.MethodGet, "/api/user/urls/"+short+"/stats?from=2023-10-01&to=2023-10-03", nil), "id", short)
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: authCookieValue})
+			w := httptest.NewRecorder()
+			H.LinkStatsHandler(w, request)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var stats models.LinkStats
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&stats))
+			require.Equal(t, int64(len(tt.clicks)), stats.Clicks)
+			require.Equal(t, hll.StdError, stats.UniqueVisitorsError)
+			require.InDelta(t, tt.want, stats.UniqueVisitors, 3*hll.StdError*float64(tt.want))
+
+			request = withURLParam(httptest.NewRequest(http.MethodGet,
+				"/api/user/urls/"+short+"/stats/timeseries?interval=day&from=2023-10-01&to=2023-10-03", nil), "id", short)
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: authCookieValue})
+			w = httptest.NewRecorder()
+			H.LinkTimeSeriesHandler(w, request)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var series models.TimeSeries
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&series))
+			require.Len(t, series.Buckets, len(tt.daily))
+			for i, b := range series.Buckets {
+				require.NotNil(t, b.UniqueVisitors)
+				require.InDelta(t, tt.daily[i], *b.UniqueVisitors, 3*hll.StdError*float64(tt.daily[i]))
+			}
+		})
+	}
+}
+
+func TestMetadataEnrichment(t *testing.T) {
+	t.Setenv("OUTBOUND_ALLOW_PRIVATE", "true")
+
+	tests := []struct {
+		name        string
+		contentType string
+		page        string
+		want        *models.LinkMetadata
+	}{
+		{
+			name:        "positive test #58",
+			contentType: "text/html; charset=utf-8",
+			page: `<html><head><title> Shortener
+				repository</title><meta name="description" content="URL shortener">
+				<meta property="og:image" content="/logo.png"></head><body><title>ignored</title></body></html>`,
+			want: &models.LinkMetadata{Title: "Shortener repository", Description: "URL shortener", Image: "/logo.png"},
+		},
+		{
+			name:        "positive test #59",
+			contentType: "text/html",
+			page:        `<head><title>Page</title><meta property="og:title" content="Open Graph title"><meta property="og:type" content="article">`,
+			want:        &models.LinkMetadata{Title: "Open Graph title", Type: "article"},
+		},
+		{
+			name:        "negative test #60",
+			contentType: "application/json",
+			page:        `{"title": "not a page"}`,
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&hits, 1)
+				w.Header().Set("Content-Type", tt.contentType)
+				_, _ = io.WriteString(w, tt.page)
+			}))
+			defer srv.Close()
+
+			body, err := json.Marshal(models.ShortenRequest{URL: srv.URL + "/repo"})
+			require.NoError(t, err)
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(body))
+			w := httptest.NewRecorder()
+			H.ShortenHandler(w, request)
+			require.Equal(t, http.StatusCreated, w.Code)
+			short := app.ShortLink([]byte(srv.URL + "/repo"))
+
+			if tt.want == nil {
+				require.Eventually(t, func() bool { return atomic.LoadInt32(&hits) > 0 }, 5*time.Second, 10*time.Millisecond)
+				time.Sleep(50 * time.Millisecond)
+				l, err := H.store.Lookup(context.Background(), short)
+				require.NoError(t, err)
+				require.Nil(t, l.Metadata)
+				return
+			}
+
+			var l models.LinkInfo
+			require.Eventually(t, func() bool {
+				l, err = H.store.Lookup(context.Background(), short)
+				return err == nil && l.Metadata != nil
+			}, 5*time.Second, 10*time.Millisecond)
+			assert.Equal(t, tt.want.Title, l.Metadata.Title)
+			assert.Equal(t, tt.want.Description, l.Metadata.Description)
+			assert.Equal(t, tt.want.Type, l.Metadata.Type)
+			if tt.want.Image != "" {
+				assert.Equal(t, srv.URL+tt.want.Image, l.Metadata.Image)
+			}
+
+			request = httptest.NewRequest(http.MethodGet, "/"+short+"+", nil)
+			w = httptest.NewRecorder()
+			H.GetShortLinkHandler(w, request)
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), "<h1>"+tt.want.Title+"</h1>")
+		})
+	}
+}
+
+func TestExportLinks(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		gzip   bool
+		want   want
+	}{
+		{
+			name:   "positive test #61",
+			format: "csv",
+			want: want{
+				code:        http.StatusOK,
+				contentType: "text/csv; charset=utf-8",
+			},
+		},
+		{
+			name:   "positive test #62",
+			format: "json",
+			gzip:   true,
+			want: want{
+				code:        http.StatusOK,
+				contentType: "application/json",
+			},
+		},
+		{
+			name:   "positive test #63",
+			format: "ndjson",
+			want: want{
+				code:        http.StatusOK,
+				contentType: "application/x-ndjson",
+			},
+		},
+		{
+			name:   "negative test #64",
+			format: "xml",
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrInvalidExportFormat,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			authCookieValue, err := auth.GetSignature()
+			require.NoError(t, err)
+			uid, err := auth.CheckSignature(authCookieValue)
+			require.NoError(t, err)
+
+			kept, err := H.store.Write(context.Background(), uid, yandexLink, models.LinkOptions{Title: "=cmd()"})
+			require.NoError(t, err)
+			require.NoError(t, H.store.SetMetadata(context.Background(), kept, yandexLink, models.LinkMetadata{Title: "Yandex"}))
+			deleted, err := H.store.Write(context.Background(), uid, gitLink+"/DrGermanius", models.LinkOptions{})
+			require.NoError(t, err)
+			require.NoError(t, H.store.Delete(context.Background(), uid, deleted))
+
+			request := httptest.NewRequest(http.MethodGet, "/api/user/urls/export?format="+tt.format, nil)
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: authCookieValue})
+			if tt.gzip {
+				request.Header.Set("Accept-Encoding", "gzip, deflate")
+			}
+			w := httptest.NewRecorder()
+			H.ExportLinksHandler(w, request)
+			require.Equal(t, tt.want.code, w.Code)
+			if tt.want.err != nil {
+				require.Contains(t, w.Body.String(), tt.want.err.Error())
+				return
+			}
+			require.Equal(t, tt.want.contentType, w.Header().Get("Content-Type"))
+
+			var body io.Reader = w.Body
+			if tt.gzip {
+				require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+				body, err = gzip.NewReader(w.Body)
+				require.NoError(t, err)
+			}
+
+			var links []models.LinkJSON
+			switch tt.format {
+			case "csv":
+				records, err := csv.NewReader(body).ReadAll()
+				require.NoError(t, err)
+				require.Len(t, records, 3)
+				assert.Equal(t, "short_url", records[0][0])
+				assert.Equal(t, []string{app.FullLink(kept), yandexLink, "false"}, records[1][:3])
+				assert.Equal(t, "'=cmd()", records[1][7])
+				assert.Equal(t, "Yandex", records[1][11])
+				assert.Equal(t, "true", records[2][2])
+				return
+			case "json":
+				require.NoError(t, json.NewDecoder(body).Decode(&links))
+			case "ndjson":
+				d := json.NewDecoder(body)
+				for d.More() {
+					var l models.LinkJSON
+					require.NoError(t, d.Decode(&l))
+					links = append(links, l)
+				}
+			}
+			require.Len(t, links, 2)
+			assert.Equal(t, "Yandex", links[0].Metadata.Title)
+			assert.True(t, links[1].IsDeleted)
+			assert.Empty(t, links[1].UUID)
+		})
+	}
+}
+
+func TestImportLinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		file     string
+		want     want
+		statuses []string
+	}{
+		{
+			name:  "positive test #65",
+			query: "?format=csv",
+			file: "long_url,link,title,tags\n" +
+				"https://go.dev/doc,https://bit.ly/godoc,Docs,go|docs\n" +
+				"https://go.dev/blog,https://bit.ly/taken,,\n" +
+				"not a url,,,\n",
+			want:     want{code: http.StatusAccepted},
+			statuses: []string{"created", "renamed", "failed"},
+		},
+		{
+			name:     "positive test #66",
+			query:    "?format=csv",
+			file:     "https://go.dev/play,goplay,go;play,2099-01-01\nhttps://go.dev/play,goplay,,\n",
+			want:     want{code: http.StatusAccepted},
+			statuses: []string{"created", "exists"},
+		},
+		{
+			name:  "positive test #67",
+			query: "?format=ndjson&on_conflict=skip",
+			file: `{"original_url": "https://go.dev/ref", "short_url": "http://localhost:8080/taken"}` + "\n" +
+				`{"original_url": "https://go.dev/tour", "tags": ["go"], "expires_at": "2001-01-01"}` + "\n",
+			want:     want{code: http.StatusAccepted},
+			statuses: []string{"failed", "failed"},
+		},
+		{
+			name:  "negative test #110",
+			query: "?format=csv&on_conflict=skip",
+			file: "https://go.dev/squat," + app.ShortLink([]byte("https://go.dev/squatted")) + "\n" +
+				gitLink + ",,,\n",
+			want:     want{code: http.StatusAccepted},
+			statuses: []string{"failed", "exists"},
+		},
+		{
+			name:  "negative test #68",
+			query: "?format=xlsx",
+			want: want{
+				code: http.StatusBadRequest,
+				err:  importer.ErrUnknownFormat,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := H.store.Write(context.Background(), "someone", "https://example.com", models.LinkOptions{Alias: "taken"})
+			if err != nil {
+				require.ErrorIs(t, err, app.ErrAliasTaken)
+			}
+
+			authCookieValue, err := auth.GetSignature()
+			require.NoError(t, err)
+			request := httptest.NewRequest(http.MethodPost, "/api/user/urls/import"+tt.query, strings.NewReader(tt.file))
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: authCookieValue})
+			w := httptest.NewRecorder()
+			H.ImportLinksHandler(w, request)
+			require.Equal(t, tt.want.code, w.Code)
+			if tt.want.err != nil {
+				require.Contains(t, w.Body.String(), tt.want.err.Error())
+				return
+			}
+
+			var job models.Job
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&job))
+			require.Equal(t, len(tt.statuses), job.Total)
+			require.Equal(t, "/api/user/urls/import/"+job.ID, w.Header().Get("Location"))
+
+			require.Eventually(t, func() bool {
+				request := withURLParam(httptest.NewRequest(http.MethodGet, "/api/user/urls/import/"+job.ID, nil), "id", job.ID)
+				request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: authCookieValue})
+				w := httptest.NewRecorder()
+				H.ImportJobHandler(w, request)
+				require.Equal(t, http.StatusOK, w.Code)
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&job))
+				return job.Finished()
+			}, 5*time.Second, 10*time.Millisecond)
+
+			require.Equal(t, models.JobDone, job.Status)
+			require.Len(t, job.Results, len(tt.statuses))
+			for i, status := range tt.statuses {
+				assert.Equal(t, status, job.Results[i].Status, job.Results[i].Error)
+				if status == "created" || status == "exists" {
+					short := strings.TrimPrefix(job.Results[i].ShortURL, "http://localhost:8080/")
+					l, err := H.store.Lookup(context.Background(), short)
+					require.NoError(t, err)
+					assert.Equal(t, job.Results[i].OriginalURL, l.Long)
+				}
+			}
+			if tt.statuses[0] == "created" {
+				assert.Equal(t, "http://localhost:8080/go"+strings.TrimPrefix(job.Results[0].OriginalURL, "https://go.dev/"),
+					job.Results[0].ShortURL)
+			}
+		})
+	}
+}
+
+func TestAsyncBatch(t *testing.T) {
+	// a batch written by several steps of the job
+	var chunked []string
+	var chunkedStatuses []string
+	for i := 0; i < 250; i++ {
+		id := "a"
+		if i > 0 {
+			id = fmt.Sprintf("b%d", i)
+		}
+		chunked = append(chunked, fmt.Sprintf(`{"correlation_id": %q, "original_url": "https://go.dev/blog/%d"}`, id, i))
+		chunkedStatuses = append(chunkedStatuses, "created")
+	}
+	chunkedBatch := "[" + strings.Join(chunked, ",") + "]"
+
+	tests := []struct {
+		name      string
+		body      string
+		restart   bool
+		otherUser bool
+		want      want
+		statuses  []string
+	}{
+		{
+			name: "positive test #69",
+			body: `[{"correlation_id": "a", "original_url": "https://go.dev/blog/a"},` +
+				`{"correlation_id": "b", "original_url": "https://go.dev/blog/b", "max_clicks": -1},` +
+				`{"correlation_id": "c", "original_url": "not a url"},` +
+				`{"correlation_id": "d", "original_url": "https://go.dev/blog/d", "tags": ["go"]}]`,
+			want:     want{code: http.StatusAccepted},
+			statuses: []string{"created", "failed", "failed", "created"},
+		},
+		{
+			name:     "positive test #70",
+			body:     `[{"correlation_id": "a", "original_url": "https://go.dev/blog/restart"}]`,
+			restart:  true,
+			want:     want{code: http.StatusAccepted},
+			statuses: []string{"created"},
+		},
+		{
+			name:      "negative test #71",
+			body:      `[{"correlation_id": "a", "original_url": "https://go.dev/blog/other"}]`,
+			otherUser: true,
+			want:      want{code: http.StatusAccepted},
+			statuses:  []string{"created"},
+		},
+		{
+			name: "negative test #72",
+			body: `{"correlation_id": "a"`,
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrEmptyBodyPostReq,
+			},
+		},
+		{
+			name:     "positive test #112",
+			body:     chunkedBatch,
+			want:     want{code: http.StatusAccepted},
+			statuses: chunkedStatuses,
+		},
+		{
+			name: "negative test #113",
+			body: "[" + strings.Repeat(`{"original_url": "https://go.dev"},`, importer.MaxRows) + "{}]",
+			want: want{
+				code: http.StatusRequestEntityTooLarge,
+				err:  app.ErrBatchTooLarge,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := H.store.Write(context.Background(), "someone", "https://example.com", models.LinkOptions{Alias: "taken"})
+			if err != nil {
+				require.ErrorIs(t, err, app.ErrAliasTaken)
+			}
+
+			authCookieValue, err := auth.GetSignature()
+			require.NoError(t, err)
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten/batch?async=true", strings.NewReader(tt.body))
+			request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: authCookieValue})
+			w := httptest.NewRecorder()
+			H.BatchHandler(w, request)
+			require.Equal(t, tt.want.code, w.Code)
+			if tt.want.err != nil {
+				require.Contains(t, w.Body.String(), tt.want.err.Error())
+				return
+			}
+
+			var job models.Job
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&job))
+			require.Equal(t, "batch", job.Kind)
+			require.Equal(t, len(tt.statuses), job.Total)
+			require.Equal(t, "/api/jobs/"+job.ID, w.Header().Get("Location"))
+
+			getJob := func(h Handlers, cookie string) *httptest.ResponseRecorder {
+				request := withURLParam(httptest.NewRequest(http.MethodGet, "/api/jobs/"+job.ID, nil), "id", job.ID)
+				request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: cookie})
+				w := httptest.NewRecorder()
+				h.JobHandler(w, request)
+				return w
+			}
+			require.Eventually(t, func() bool {
+				w := getJob(H, authCookieValue)
+				require.Equal(t, http.StatusOK, w.Code)
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&job))
+				return job.Finished()
+			}, 5*time.Second, 10*time.Millisecond)
+
+			if tt.otherUser {
+				otherCookie, err := auth.GetSignature()
+				require.NoError(t, err)
+				require.Equal(t, http.StatusNotFound, getJob(H, otherCookie).Code)
+				return
+			}
+
+			if tt.restart {
+				running := models.Job{ID: uuid.NewString(), UID: "someone", Kind: "batch", Status: models.JobRunning, Total: 1}
+				require.NoError(t, H.store.SaveJob(context.Background(), running))
+
+				store, err := memory.NewLinkMemoryStore()
+				require.NoError(t, err)
+				n, err := store.InterruptJobs(context.Background())
+				require.NoError(t, err)
+				require.Equal(t, int64(1), n)
+				interrupted, err := store.GetJob(context.Background(), running.ID)
+				require.NoError(t, err)
+				require.Equal(t, models.JobFailed, interrupted.Status)
+
+				h := NewHandlers(store, H.workerPool, H.logger, context.Background())
+				w := getJob(h, authCookieValue)
+				require.Equal(t, http.StatusOK, w.Code)
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&job))
+			}
+
+			require.Equal(t, models.JobDone, job.Status)
+			require.Len(t, job.Results, len(tt.statuses))
+			for i, status := range tt.statuses {
+				assert.Equal(t, status, job.Results[i].Status, job.Results[i].Error)
+				if status == "created" {
+					short := strings.TrimPrefix(job.Results[i].ShortURL, "http://localhost:8080/")
+					l, err := H.store.Lookup(context.Background(), short)
+					require.NoError(t, err)
+					assert.Equal(t, job.Results[i].OriginalURL, l.Long)
+				}
+			}
+			assert.Equal(t, "a", job.Results[0].Item)
+		})
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	t.Setenv("OUTBOUND_ALLOW_PRIVATE", "true")
+	t.Setenv("WEBHOOK_RETRY_BASE", "10ms")
+	t.Setenv("WEBHOOK_MAX_ATTEMPTS", "3")
+
+	tests := []struct {
+		name    string
+		url     string
+		events  []string
+		failing bool
+		click   bool
+		want    want
+		event   string
+	}{
+		{
+			name:   "positive test #87",
+			events: []string{models.EventLinkCreated},
+			want:   want{code: http.StatusCreated},
+			event:  models.EventLinkCreated,
+		},
+		{
+			name:    "positive test #88",
+			failing: true,
+			want:    want{code: http.StatusCreated},
+			event:   models.EventLinkCreated,
+		},
+		{
+			name:   "positive test #89",
+			events: []string{models.EventLinkClicked},
+			click:  true,
+			want:   want{code: http.StatusCreated},
+			event:  models.EventLinkClicked,
+		},
+		{
+			name: "negative test #90",
+			url:  "ftp://example.com/hook",
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrInvalidWebhook,
+			},
+		},
+		{
+			name:   "negative test #91",
+			events: []string{"link.updated"},
+			want: want{
+				code: http.StatusBadRequest,
+				err:  app.ErrInvalidWebhook,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			var failing int32
+			if tt.failing {
+				failing = 1
+			}
+			received := make(chan *http.Request, 10)
+			bodies := make(chan []byte, 10)
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.LoadInt32(&failing) == 1 {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				b, _ := io.ReadAll(r.Body)
+				received <- r
+				bodies <- b
+			}))
+			defer receiver.Close()
+
+			authCookieValue, err := auth.GetSignature()
+			require.NoError(t, err)
+			cookie := &http.Cookie{Name: auth.AuthCookie, Value: authCookieValue}
+
+			hookURL := tt.url
+			if hookURL == "" {
+				hookURL = receiver.URL
+			}
+			body, err := json.Marshal(models.WebhookRequest{URL: hookURL, Events: tt.events})
+			require.NoError(t, err)
+			request := httptest.NewRequest(http.MethodPost, "/api/user/webhooks", bytes.NewBuffer(body))
+			request.AddCookie(cookie)
+			w := httptest.NewRecorder()
+			H.CreateWebhookHandler(w, request)
+			require.Equal(t, tt.want.code, w.Code)
+			if tt.want.err != nil {
+				require.Contains(t, w.Body.String(), tt.want.err.Error())
+				return
+			}
+
+			var hook models.Webhook
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&hook))
+			require.NotEmpty(t, hook.Secret)
+
+			body, err = json.Marshal(models.ShortenRequest{URL: "https://go.dev/webhooks/" + hook.ID})
+			require.NoError(t, err)
+			request = httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(body))
+			request.AddCookie(cookie)
+			w = httptest.NewRecorder()
+			H.ShortenHandler(w, request)
+			require.Equal(t, http.StatusCreated, w.Code)
+			var sRes models.ShortenResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&sRes))
+
+			if tt.click {
+				request = httptest.NewRequest(http.MethodGet, "/"+path.Base(sRes.Result), nil)
+				request.Header.Set("Referer", "https://news.ycombinator.com/")
+				w = httptest.NewRecorder()
+				H.GetShortLinkHandler(w, request)
+				require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+			}
+
+			deliveries := func(status string) []models.Delivery {
+				request := withURLParam(httptest.NewRequest(http.MethodGet, "/api/user/webhooks/"+hook.ID+"/deliveries?status="+status, nil), "id", hook.ID)
+				request.AddCookie(cookie)
+				w := httptest.NewRecorder()
+				H.WebhookDeliveriesHandler(w, request)
+				require.Equal(t, http.StatusOK, w.Code)
+				var res []models.Delivery
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&res))
+				return res
+			}
+
+			if tt.failing {
+				require.Eventually(t, func() bool {
+					return len(deliveries(models.DeliveryDead)) == 1
+				}, 5*time.Second, 10*time.Millisecond)
+				dead := deliveries(models.DeliveryDead)[0]
+				require.Equal(t, 3, dead.Attempts)
+				require.Equal(t, http.StatusInternalServerError, dead.LastStatus)
+
+				atomic.StoreInt32(&failing, 0)
+				request := httptest.NewRequest(http.MethodPost, "/api/user/webhooks/"+hook.ID+"/deliveries/"+dead.ID+"/retry", nil)
+				rctx := chi.NewRouteContext()
+				rctx.URLParams.Add("id", hook.ID)
+				rctx.URLParams.Add("delivery", dead.ID)
+				request = request.WithContext(context.WithValue(request.Context(), chi.RouteCtxKey, rctx))
+				request.AddCookie(cookie)
+				w := httptest.NewRecorder()
+				H.RetryDeliveryHandler(w, request)
+				require.Equal(t, http.StatusAccepted, w.Code)
+			}
+
+			var r *http.Request
+			select {
+			case r = <-received:
+			case <-time.After(5 * time.Second):
+				t.Fatal("webhook wasn't delivered")
+			}
+			b := <-bodies
+
+			var event models.Event
+			require.NoError(t, json.Unmarshal(b, &event))
+			assert.Equal(t, tt.event, event.Type)
+			assert.Equal(t, tt.event, r.Header.Get(webhooks.EventHeader))
+			assert.Equal(t, hook.ID, r.Header.Get(webhooks.WebhookHeader))
+			assert.Equal(t, sRes.Result, event.Data.ShortURL)
+			if tt.click {
+				assert.Equal(t, "https://news.ycombinator.com/", event.Data.Referrer)
+			}
+
+			signature := r.Header.Get(webhooks.SignatureHeader)
+			var ts int64
+			_, err = fmt.Sscanf(signature, "t=%d,", &ts)
+			require.NoError(t, err)
+			assert.Equal(t, webhooks.Signature(hook.Secret, time.Unix(ts, 0), b), signature)
+
+			require.Eventually(t, func() bool {
+				return len(deliveries(models.DeliveryDelivered)) == 1
+			}, 5*time.Second, 10*time.Millisecond)
+			all := deliveries("")
+			require.Len(t, all, 1)
+			assert.Equal(t, r.Header.Get(webhooks.DeliveryHeader), all[0].ID)
+		})
+	}
+}
+
+func TestClickStream(t *testing.T) {
+	tests := []struct {
+		name        string
+		open        int
+		resume      bool
+		lastEventID string
+		want        want
+	}{
+		{
+			name: "positive test #92",
+			want: want{code: http.StatusOK, contentType: "text/event-stream"},
+		},
+		{
+			name:   "positive test #93",
+			resume: true,
+			want:   want{code: http.StatusOK, contentType: "text/event-stream"},
+		},
+		{
+			name:        "positive test #116",
+			lastEventID: "1",
+			want:        want{code: http.StatusOK, contentType: "text/event-stream"},
+		},
+		{
+			name: "negative test #94",
+			open: 5,
+			want: want{
+				code: http.StatusTooManyRequests,
+				err:  app.ErrTooManyStreams,
+			},
+		},
+	}
+	for _, tt := range tests {
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(H.StreamHandler))
+			defer server.Close()
+
+			authCookieValue, err := auth.GetSignature()
+			require.NoError(t, err)
+			uid, err := auth.CheckSignature(authCookieValue)
+			require.NoError(t, err)
+			short, err := H.store.Write(context.Background(), uid, "https://go.dev/stream/"+uid, models.LinkOptions{})
+			require.NoError(t, err)
+
+			connect := func(lastEventID string) (*http.Response, *bufio.Reader) {
+				request, err := http.NewRequest(http.MethodGet, server.URL+"/api/user/urls/stream", nil)
+				require.NoError(t, err)
+				request.AddCookie(&http.Cookie{Name: auth.AuthCookie, Value: authCookieValue})
+				if lastEventID != "" {
+					request.Header.Set("Last-Event-ID", lastEventID)
+				}
+				res, err := http.DefaultClient.Do(request)
+				require.NoError(t, err)
+				r := bufio.NewReader(res.Body)
+				if res.StatusCode == http.StatusOK {
+					// the retry hint is written once the stream is subscribed
+					line, err := r.ReadString('\n')
+					require.NoError(t, err)
+					require.True(t, strings.HasPrefix(line, "retry:"))
+				}
+				return res, r
+			}
+			click := func() {
+				request := httptest.NewRequest(http.MethodGet, "/"+short, nil)
+				w := httptest.NewRecorder()
+				H.GetShortLinkHandler(w, request)
+				require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+			}
+			next := func(r *bufio.Reader) (string, models.Event) {
+				var id string
+				var event models.Event
+				for {
+					line, err := r.ReadString('\n')
+					require.NoError(t, err)
+					line = strings.TrimSuffix(line, "\n")
+					switch {
+					case line == "" && id != "":
+						return id, event
+					case strings.HasPrefix(line, "id: "):
+						id = strings.TrimPrefix(line, "id: ")
+					case strings.HasPrefix(line, "data: "):
+						require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+					}
+				}
+			}
+
+			for i := 0; i < tt.open; i++ {
+				res, _ := connect("")
+				defer res.Body.Close()
+				require.Equal(t, http.StatusOK, res.StatusCode)
+			}
+
+			res, r := connect(tt.lastEventID)
+			require.Equal(t, tt.want.code, res.StatusCode)
+			if tt.want.err != nil {
+				b, err := io.ReadAll(res.Body)
+				require.NoError(t, err)
+				require.Contains(t, string(b), tt.want.err.Error())
+				res.Body.Close()
+				return
+			}
+			require.Equal(t, tt.want.contentType, res.Header.Get("Content-Type"))
+			if tt.lastEventID != "" {
+				// events published before the service started are gone
+				_, event := next(r)
+				assert.Equal(t, events.EventReset, event.Type)
+			}
+
+			click()
+			click()
+			first, event := next(r)
+			assert.Equal(t, models.EventLinkClicked, event.Type)
+			assert.Equal(t, app.FullLink(short), event.Data.ShortURL)
+			second, _ := next(r)
+			require.NotEqual(t, first, second)
+			if !tt.resume {
+				res.Body.Close()
+				return
+			}
+
+			res.Body.Close()
+			require.Eventually(t, func() bool {
+				return H.events.Subscribers(uid) == 0
+			}, 5*time.Second, 10*time.Millisecond)
+			click()
+
+			res, r = connect(first)
+			defer res.Body.Close()
+			id, _ := next(r)
+			assert.Equal(t, second, id)
+			id, event = next(r)
+			assert.NotEqual(t, second, id)
+			assert.Equal(t, models.EventLinkClicked, event.Type)
+		})
+	}
+}
+
+func TestEventLog(t *testing.T) {
+	tests := []struct {
+		name     string
+		gzip     string
+		maxBytes string
+		maxAge   string
+		fsync    string
+		files    int
+	}{
+		{
+			name:     "positive test #95",
+			gzip:     "false",
+			maxBytes: "1",
+			maxAge:   "1h",
+			fsync:    eventlog.FsyncRotate,
+			files:    3,
+		},
+		{
+			name:     "positive test #96",
+			gzip:     "true",
+			maxBytes: "1048576",
+			maxAge:   "50ms",
+			fsync:    eventlog.FsyncAlways,
+		},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		t.Setenv("EVENT_LOG_DIR", dir)
+		t.Setenv("EVENT_LOG_GZIP", tt.gzip)
+		t.Setenv("EVENT_LOG_MAX_BYTES", tt.maxBytes)
+		t.Setenv("EVENT_LOG_MAX_AGE", tt.maxAge)
+		t.Setenv("EVENT_LOG_FSYNC", tt.fsync)
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			authCookieValue, err := auth.GetSignature()
+			require.NoError(t, err)
+			cookie := &http.Cookie{Name: auth.AuthCookie, Value: authCookieValue}
+
+			body, err := json.Marshal(models.ShortenRequest{URL: "https://go.dev/eventlog/" + authCookieValue})
+			require.NoError(t, err)
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(body))
+			request.AddCookie(cookie)
+			w := httptest.NewRecorder()
+			H.ShortenHandler(w, request)
+			require.Equal(t, http.StatusCreated, w.Code)
+			var sRes models.ShortenResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&sRes))
+			short := path.Base(sRes.Result)
+
+			request = httptest.NewRequest(http.MethodGet, "/"+short, nil)
+			w = httptest.NewRecorder()
+			H.GetShortLinkHandler(w, request)
+			require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+
+			body, err = json.Marshal([]string{short})
+			require.NoError(t, err)
+			request = httptest.NewRequest(http.MethodDelete, "/api/user/urls?wait=5s", bytes.NewBuffer(body))
+			request.AddCookie(cookie)
+			w = httptest.NewRecorder()
+			H.DeleteLinksHandler(w, request)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var files []eventlog.File
+			require.Eventually(t, func() bool {
+				b, err := os.ReadFile(filepath.Join(dir, eventlog.ManifestName))
+				if err != nil {
+					return false
+				}
+				files = files[:0]
+				var events int64
+				for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+					var f eventlog.File
+					require.NoError(t, json.Unmarshal([]byte(line), &f))
+					files = append(files, f)
+					events += f.Events
+				}
+				return events == 3
+			}, 5*time.Second, 10*time.Millisecond)
+			if tt.files > 0 {
+				require.Len(t, files, tt.files)
+			}
+
+			var records []eventlog.Record
+			for _, f := range files {
+				b, err := os.ReadFile(filepath.Join(dir, f.Name))
+				require.NoError(t, err)
+				require.Equal(t, int64(len(b)), f.Bytes)
+				sum := sha256.Sum256(b)
+				require.Equal(t, hex.EncodeToString(sum[:]), f.SHA256)
+
+				var r io.Reader = bytes.NewReader(b)
+				if tt.gzip == "true" {
+					require.True(t, strings.HasSuffix(f.Name, ".ndjson.gz"))
+					r, err = gzip.NewReader(r)
+					require.NoError(t, err)
+				}
+				d := json.NewDecoder(r)
+				for d.More() {
+					var rec eventlog.Record
+					require.NoError(t, d.Decode(&rec))
+					records = append(records, rec)
+				}
+			}
+
+			require.Len(t, records, 3)
+			for i, eventType := range []string{models.EventLinkCreated, models.EventLinkClicked, models.EventLinkDeleted} {
+				assert.Equal(t, eventType, records[i].Type)
+				assert.Equal(t, sRes.Result, records[i].Data.ShortURL)
+			}
+			assert.Less(t, records[0].Seq, records[2].Seq)
+
+			open, err := filepath.Glob(filepath.Join(dir, "*.open"))
+			require.NoError(t, err)
+			assert.Empty(t, open)
+		})
+	}
+}
+
+func TestInternalStats(t *testing.T) {
+	tests := []struct {
+		name       string
+		subnet     string
+		proxies    string
+		remoteAddr string
+		realIP     string
+		want       want
+	}{
+		{
+			name:       "positive test #97",
+			subnet:     "192.0.2.0/24",
+			remoteAddr: "192.0.2.10:41000",
+			want:       want{code: http.StatusOK, contentType: "application/json"},
+		},
+		{
+			name:       "positive test #98",
+			subnet:     "2001:db8::/32",
+			remoteAddr: "[2001:db8::1]:41000",
+			want:       want{code: http.StatusOK, contentType: "application/json"},
+		},
+		{
+			name:       "negative test #99",
+			subnet:     "192.0.2.0/24",
+			remoteAddr: "198.51.100.7:41000",
+			realIP:     "192.0.2.10",
+			want: want{
+				code: http.StatusForbidden,
+				err:  app.ErrUntrustedClient,
+			},
+		},
+		{
+			name:       "negative test #100",
+			remoteAddr: "192.0.2.10:41000",
+			want: want{
+				code: http.StatusForbidden,
+				err:  app.ErrUntrustedClient,
+			},
+		},
+		{
+			name:       "positive test #117",
+			subnet:     "192.0.2.0/24",
+			proxies:    "10.0.0.0/8, not a cidr",
+			remoteAddr: "10.1.2.3:41000",
+			realIP:     "192.0.2.10",
+			want:       want{code: http.StatusOK, contentType: "application/json"},
+		},
+		{
+			name:       "negative test #118",
+			subnet:     "192.0.2.0/24",
+			proxies:    "192.0.2.1/32",
+			remoteAddr: "192.0.2.1:41000",
+			realIP:     "198.51.100.7",
+			want: want{
+				code: http.StatusForbidden,
+				err:  app.ErrUntrustedClient,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Setenv("TRUSTED_SUBNET", tt.subnet)
+		t.Setenv("TRUSTED_PROXIES", tt.proxies)
+		initTestData()
+		t.Run(tt.name, func(t *testing.T) {
+			stats := func() models.InternalStats {
+				request := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+				request.RemoteAddr = tt.remoteAddr
+				if tt.realIP != "" {
+					request.Header.Set("X-Real-IP", tt.realIP)
+					request.Header.Set("X-Forwarded-For", tt.realIP)
+				}
+				w := httptest.NewRecorder()
+				H.InternalStatsHandler(w, request)
+				require.Equal(t, tt.want.code, w.Code)
+				if tt.want.err != nil {
+					require.Contains(t, w.Body.String(), tt.want.err.Error())
+					return models.InternalStats{}
+				}
+				require.Equal(t, tt.want.contentType, w.Header().Get("Content-Type"))
+
+				var s models.InternalStats
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&s))
+				return s
+			}
+
+			before := stats()
+			if tt.want.err != nil {
+				return
+			}
+
+			owner, other := uuid.NewString(), uuid.NewString()
+			first, err := H.store.Write(context.Background(), owner, "https://go.dev/stats/"+owner, models.LinkOptions{})
+			require.NoError(t, err)
+			_, err = H.store.Write(context.Background(), owner, "https://go.dev/stats/second/"+owner, models.LinkOptions{})
+			require.NoError(t, err)
+			_, err = H.store.Write(context.Background(), other, "https://go.dev/stats/"+other, models.LinkOptions{})
+			require.NoError(t, err)
+			require.NoError(t, H.store.Delete(context.Background(), owner, first))
+			require.NoError(t, H.store.Delete(context.Background(), owner, first))
+
+			after := stats()
+			assert.Equal(t, before.URLs+2, after.URLs)
+			assert.Equal(t, before.DeletedURLs+1, after.DeletedURLs)
+			assert.Equal(t, before.Users+2, after.Users)
+			assert.Equal(t, before.CreatedLast24h+3, after.CreatedLast24h)
+		})
+	}
+}
+
+func BenchmarkAddGet(b *testing.B) {
+	initTestData()
+	authCookieValue, err := auth.GetSignature()
+	if err != nil {
+		b.Fatal(err)
+	}
+	authCookie := &http.Cookie{Name: auth.AuthCookie, Value: authCookieValue}
+	w := httptest.NewRecorder()
+	hAdd := http.HandlerFunc(H.AddShortLinkHandler)
+	hGet := http.HandlerFunc(H.GetShortLinkHandler)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		s := randStringRunes(10)
+		addRequest := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(s))
+		addRequest.AddCookie(authCookie)
+		getRequest := httptest.NewRequest(http.MethodGet, "/", strings.NewReader(s))
+		getRequest.AddCookie(authCookie)
+		b.StartTimer()
+
+		hAdd.ServeHTTP(w, addRequest)
+		hGet.ServeHTTP(w, getRequest)
+	}
+}
+
+func ExampleHandlers_AddShortLinkHandler() {
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(yandexLink))
+	w := httptest.NewRecorder()
+	h := http.HandlerFunc(H.AddShortLinkHandler)
+	h.ServeHTTP(w, request)
+}
+
+func ExampleHandlers_GetShortLinkHandler() {
+	request := httptest.NewRequest(http.MethodGet, "/"+yandexLink, nil)
+	w := httptest.NewRecorder()
+	h := http.HandlerFunc(H.GetShortLinkHandler)
+	h.ServeHTTP(w, request)
+}
+
+func ExampleHandlers_DeleteLinksHandler() {
+	link := app.ShortLink([]byte(yandexLink))
+	req, err := json.Marshal([]string{
+		link,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	authCookie := &http.Cookie{Name: auth.AuthCookie, Value: "authCookieValue"}
+
+	request := httptest.NewRequest(http.MethodDelete, "/api/user/urls", bytes.NewBuffer(req))
+	request.AddCookie(authCookie)
+	w := httptest.NewRecorder()
+	h := http.HandlerFunc(H.DeleteLinksHandler)
+	h.ServeHTTP(w, request)
+}
+
+func ExampleHandlers_ShortenHandler() {
+	sReq := models.ShortenRequest{URL: yandexLink}
+	body, err := json.Marshal(sReq)
+	request := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewBuffer(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h := http.HandlerFunc(H.ShortenHandler)
+	h.ServeHTTP(w, request)
+}
+
+func ExampleHandlers_GetUserUrlsHandler() {
+	request := httptest.NewRequest(http.MethodGet, "/user/urls", nil)
+	authCookie := &http.Cookie{Name: auth.AuthCookie, Value: "authCookieValue"}
+	request.AddCookie(authCookie)
+
+	w := httptest.NewRecorder()
+	h := http.HandlerFunc(H.GetUserUrlsHandler)
+	h.ServeHTTP(w, request)
+}
+
+func ExampleHandlers_BatchHandler() {
+	req, err := json.Marshal([]models.BatchOriginal{
+		{CorrelationID: "1",
+			OriginalURL: gitLink},
+		{CorrelationID: "2",
+			OriginalURL: yandexLink},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	request := httptest.NewRequest(http.MethodPost, "/user/urls", bytes.NewBuffer(req))
+	w := httptest.NewRecorder()
+	h := http.HandlerFunc(H.BatchHandler)
+	h.ServeHTTP(w, request)
+}
+
+func initTestData() {
+	stopH()
+	config.SetTestConfig()
+
+	zapl, err := zap.NewProduction()
+	if err != nil {
+		log.Fatalf("can't initialize zap logger: %v", err)
+	}
+	defer zapl.Sync()
+	logger := zapl.Sugar()
+
+	err = memory.Clear()
+	if err != nil {
+		logger.Fatalf("tests init error: %v", err)
+	}
+
+	linksMemoryStore, err := memory.NewLinkMemoryStore()
+	if err != nil {
+		logger.Fatalf("tests init error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wp := app.NewWorkerPool(ctx, logger)
+	H = NewHandlers(linksMemoryStore, wp, logger, ctx)
+	h := H
+	stopH = func() {
+		cancel()
+		<-h.Done()
+	}
+
+	_, err = linksMemoryStore.Write(context.Background(), "", gitLink, models.LinkOptions{})
+	if err != nil {
+		logger.Fatalf("tests init error: %v", err)
+	}
+}
+
+func withURLParam(req *http.Request, key, value string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add(key, value)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
 func randStringRunes(n int) string {
 	b := make([]rune, n)
 	for i := range b {
@@ -543,7 +3521,8 @@ func randStringRunes(n int) string {
 }
 
 type want struct {
-	code     int
-	response string
-	err      error
+	code        int
+	response    string
+	contentType string
+	err         error
 }
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// BrokenLinksHandler returns user's links whose destinations failed the last health check.
+func (h Handlers) BrokenLinksHandler(w http.ResponseWriter, req *http.Request) {
+	uid, err := checkAuthCookie(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	links, err := h.store.GetByUserID(req.Context(), uid, models.LinkFilter{})
+	if err != nil && !errors.Is(err, app.ErrUserHasNoRecords) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	broken := make([]models.LinkJSON, 0)
+	for _, l := range links {
+		if l.Health != nil && !l.Health.Healthy && !l.IsDeleted {
+			broken = append(broken, l)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(broken)
+	if err != nil {
+		h.logger.Errorf("broken links response: %v", err)
+	}
+}
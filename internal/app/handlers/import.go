@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"mime"
+	"net/http"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/importer"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+const maxImportSize = 10 << 20
+
+// ImportLinksHandler starts importing user's links from the file in the request body and returns the import job.
+// The format is set by "format" param (csv, json, ndjson) or the content type, csv by default.
+// Taken aliases get generated short codes unless "on_conflict" param is "skip".
+func (h Handlers) ImportLinksHandler(w http.ResponseWriter, req *http.Request) {
+	uid, err := checkAuthCookie(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	onConflict := req.URL.Query().Get("on_conflict")
+	if onConflict == "" {
+		onConflict = importer.OnConflictRename
+	}
+	if onConflict != importer.OnConflictRename && onConflict != importer.OnConflictSkip {
+		http.Error(w, app.ErrInvalidConflictPolicy.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := importer.Parse(http.MaxBytesReader(w, req.Body, maxImportSize), importFormat(req))
+	defer req.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.startJob(req.Context(), uid, importJobKind, len(rows), func(progress func([]models.JobResult)) jobStep {
+		return h.bulkWriter(onConflict, progress).Chunks(uid, importer.Items(rows))
+	})
+	if err != nil {
+		startJobErrorResponse(w, err)
+		return
+	}
+
+	writeJob(w, "/api/user/urls/import/"+job.ID, job)
+}
+
+// ImportJobHandler returns progress of the user's import job.
+func (h Handlers) ImportJobHandler(w http.ResponseWriter, req *http.Request) {
+	h.serveJob(w, req, importJobKind)
+}
+
+// Import writes links read from an import file on behalf of the user, reporting results of every chunk.
+// New links are validated, screened and enriched the same way as links created via the api.
+func (h Handlers) Import(ctx context.Context, uid string, rows []importer.Row, onConflict string, progress func([]models.JobResult)) error {
+	return h.bulkWriter(onConflict, progress).Run(ctx, uid, rows)
+}
+
+// bulkWriter returns importer which validates, screens and enriches links the same way as the api.
+func (h Handlers) bulkWriter(onConflict string, progress func([]models.JobResult)) importer.Importer {
+	return importer.Importer{
+		Store:      h.store,
+		OnConflict: onConflict,
+		Validate: func(ctx context.Context, o *models.BatchOriginal) error {
+			err := prepareLinkOptions(&o.LinkOptions)
+			if err != nil {
+				return err
+			}
+			return h.screenLink(ctx, o.OriginalURL, o.LinkOptions)
+		},
+		Progress: progress,
+		Created:  h.linkCreated,
+	}
+}
+
+// importFormat returns format of the import file set by "format" param or the content type.
+func importFormat(req *http.Request) string {
+	if f := req.URL.Query().Get("format"); f != "" {
+		return f
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		return importer.FormatJSON
+	case "application/x-ndjson", "application/jsonl":
+		return importer.FormatNDJSON
+	}
+	return importer.FormatCSV
+}
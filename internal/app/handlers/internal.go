@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+)
+
+// InternalStatsHandler returns totals of the service to clients of the trusted subnet.
+// The client address is taken from the connection; X-Real-IP header is used only if the connection
+// comes from one of TRUSTED_PROXIES, since clients connected directly can forge it.
+func (h Handlers) InternalStatsHandler(w http.ResponseWriter, req *http.Request) {
+	ip := net.ParseIP(h.clientIP(req))
+	if h.trustedSubnet == nil || ip == nil || !h.trustedSubnet.Contains(ip) {
+		http.Error(w, app.ErrUntrustedClient.Error(), http.StatusForbidden)
+		return
+	}
+
+	stats, err := h.store.InternalStats(req.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// Kinds of jobs started by the handlers.
+const (
+	importJobKind = "import"
+	batchJobKind  = "batch"
+	deleteJobKind = "delete"
+)
+
+// Outcomes of deleting a link by a delete job.
+const (
+	deleteStatusDeleted  = "deleted"
+	deleteStatusNotFound = "not_found"
+	deleteStatusNotOwner = "not_owner"
+	deleteStatusFailed   = "failed"
+)
+
+const (
+	// maxWait limits how long a request may wait for a job to finish.
+	maxWait = 30 * time.Second
+	// deleteChunkSize is the number of links deleted by a single step of a delete job.
+	deleteChunkSize = 100
+	// stepRetryDelay is how long the next step of a job waits for room in the busy worker pool.
+	stepRetryDelay = 100 * time.Millisecond
+)
+
+// JobHandler returns progress and per item results of the user's job of any kind.
+// With "wait" param set to a duration it waits for the job to finish up to that long.
+func (h Handlers) JobHandler(w http.ResponseWriter, req *http.Request) {
+	h.serveJob(w, req, "")
+}
+
+// serveJob writes the job set by "id" URL param; a non-empty kind restricts jobs which may be served.
+func (h Handlers) serveJob(w http.ResponseWriter, req *http.Request, kind string) {
+	uid, err := checkAuthCookie(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	wait, err := waitParam(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.waitJob(req.Context(), chi.URLParam(req, "id"), wait)
+
+	job, err := h.jobs.Get(req.Context(), uid, chi.URLParam(req, "id"))
+	if err == nil && kind != "" && job.Kind != kind {
+		err = app.ErrJobNotFound
+	}
+	if errors.Is(err, app.ErrJobNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	jRes, err := json.Marshal(job)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(jRes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// jobStep processes the next part of a job and reports whether parts remain.
+type jobStep func(ctx context.Context) (bool, error)
+
+// startJob registers a job of the user and queues its first step to the worker pool.
+// newStep gets the function the job reports results with.
+func (h Handlers) startJob(ctx context.Context, uid, kind string, total int, newStep func(progress func([]models.JobResult)) jobStep) (models.Job, error) {
+	job, err := h.jobs.Create(ctx, uid, kind, total)
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	step := newStep(func(results []models.JobResult) {
+		h.jobs.Progress(job.ID, results...)
+	})
+	err = h.submitStep(job.ID, step, true)
+	if err != nil {
+		h.jobs.Finish(job.ID, err)
+		return models.Job{}, err
+	}
+	return job, nil
+}
+
+// submitStep queues a step of the job; every step is a task of its own,
+// so a long job takes turns with other tasks of the worker pool instead of holding a worker.
+func (h Handlers) submitStep(id string, step jobStep, first bool) error {
+	return h.workerPool.Submit(h.context, func(ctx context.Context) error {
+		if first {
+			h.jobs.Start(id)
+		}
+		more, err := step(ctx)
+		if err != nil || !more {
+			h.jobs.Finish(id, err)
+			return err
+		}
+
+		h.queueStep(id, step)
+		return nil
+	})
+}
+
+// queueStep queues the following step of a running job, retrying while the worker pool is busy.
+func (h Handlers) queueStep(id string, step jobStep) {
+	err := h.submitStep(id, step, false)
+	if errors.Is(err, app.ErrPoolBusy) {
+		time.AfterFunc(stepRetryDelay, func() {
+			h.queueStep(id, step)
+		})
+		return
+	}
+	if err != nil {
+		h.jobs.Finish(id, err)
+	}
+}
+
+// startJobErrorResponse writes an error of a job which couldn't be started.
+func startJobErrorResponse(w http.ResponseWriter, err error) {
+	if errors.Is(err, app.ErrPoolBusy) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// waitJob blocks until the job finishes, ctx is done or wait passes.
+func (h Handlers) waitJob(ctx context.Context, id string, wait time.Duration) {
+	if wait <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+	h.jobs.Wait(ctx, id)
+}
+
+// waitParam returns duration set by "wait" param as a Go duration or a number of seconds, zero if it's missing.
+func waitParam(req *http.Request) (time.Duration, error) {
+	v := req.URL.Query().Get("wait")
+	if v == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		s, convErr := strconv.Atoi(v)
+		if convErr != nil {
+			return 0, app.ErrInvalidWait
+		}
+		d = time.Duration(s) * time.Second
+	}
+	if d < 0 || d > maxWait {
+		return 0, app.ErrInvalidWait
+	}
+	return d, nil
+}
+
+// writeJob writes the accepted job with its status address; a job which has already finished is written with 200.
+func writeJob(w http.ResponseWriter, location string, job models.Job) {
+	jRes, err := json.Marshal(job)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	code := http.StatusAccepted
+	if job.Finished() {
+		code = http.StatusOK
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", location)
+	w.WriteHeader(code)
+
+	_, err = w.Write(jRes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+const (
+	maxTags         = 20
+	maxTagLength    = 50
+	maxFolderLength = 100
+	maxNotesLength  = 2000
+)
+
+// prepareLinkOptions validates link options from a create request and converts relative values into absolute ones.
+func prepareLinkOptions(opts *models.LinkOptions) error {
+	now := time.Now()
+
+	if opts.Alias != "" {
+		err := app.ValidateAlias(opts.Alias)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.TTL < 0 {
+		return app.ErrInvalidExpiry
+	}
+	if opts.TTL > 0 {
+		exp := now.Add(time.Duration(opts.TTL) * time.Second)
+		if opts.ExpiresAt == nil || exp.Before(*opts.ExpiresAt) {
+			opts.ExpiresAt = &exp
+		}
+		opts.TTL = 0
+	}
+	if opts.ExpiresAt != nil && !opts.ExpiresAt.After(now) {
+		return app.ErrInvalidExpiry
+	}
+	if opts.ActiveFrom != nil && opts.ActiveUntil != nil && !opts.ActiveUntil.After(*opts.ActiveFrom) {
+		return app.ErrInvalidSchedule
+	}
+	if opts.MaxClicks < 0 {
+		return app.ErrInvalidMaxClicks
+	}
+	if opts.RedirectCode != 0 {
+		err := validateRedirectCode(opts.RedirectCode)
+		if err != nil {
+			return err
+		}
+	}
+	err := validateCachePolicy(opts.CachePolicy)
+	if err != nil {
+		return err
+	}
+	err = validateQueryConflict(opts.QueryConflict)
+	if err != nil {
+		return err
+	}
+	err = app.ValidateRules(opts.Rules)
+	if err != nil {
+		return err
+	}
+	opts.Tags = normalizeTags(opts.Tags)
+	if len(opts.Tags) > maxTags {
+		return app.ErrInvalidTags
+	}
+	for _, t := range opts.Tags {
+		if utf8.RuneCountInString(t) > maxTagLength {
+			return app.ErrInvalidTags
+		}
+	}
+	opts.Folder = strings.TrimSpace(opts.Folder)
+	if utf8.RuneCountInString(opts.Folder) > maxFolderLength {
+		return app.ErrInvalidFolder
+	}
+	if utf8.RuneCountInString(opts.Notes) > maxNotesLength {
+		return app.ErrInvalidNotes
+	}
+	if opts.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(opts.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		opts.PasswordHash = string(hash)
+		opts.Password = ""
+	}
+
+	return nil
+}
+
+// normalizeTags trims and lower-cases tags, dropping empty and repeated ones.
+func normalizeTags(tags []string) []string {
+	var res []string
+	seen := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		res = append(res, t)
+	}
+	return res
+}
+
+// linkFilter reads filter of user's links from "tag", "folder" and "q" query params.
+func linkFilter(req *http.Request) models.LinkFilter {
+	q := req.URL.Query()
+	return models.LinkFilter{
+		Tags:   normalizeTags(q["tag"]),
+		Folder: strings.TrimSpace(q.Get("folder")),
+		Query:  q.Get("q"),
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"html/template"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/auth"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+const (
+	passwordAttempts       = 5
+	passwordAttemptsWindow = 15 * time.Minute
+	linkAccessTTL          = 10 * time.Minute
+)
+
+var passwordForm = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Password required</title></head>
+<body>
+<form method="post" action="{{.Action}}">
+<p>This link is protected with a password.</p>
+{{if .Error}}<p style="color:red">{{.Error}}</p>{{end}}
+<input type="password" name="password" autofocus required>
+<button type="submit">Open</button>
+</form>
+</body>
+</html>
+`))
+
+// UnlockLinkHandler checks password of a protected link and redirects client to its full url address.
+func (h Handlers) UnlockLinkHandler(w http.ResponseWriter, req *http.Request) {
+	_, err := checkAuthCookie(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s, suffix := linkPath(req)
+
+	l, err := h.store.Get(req.Context(), s)
+	if err != nil {
+		h.linkErrorResponse(w, req, s, err)
+		return
+	}
+	if suffix != "" && !l.ForwardPath {
+		http.NotFound(w, req)
+		return
+	}
+
+	// the continue button of a forced interstitial posts here once the password has been entered
+	if l.PasswordHash == "" || hasLinkAccess(req, s, l) {
+		h.redirect(w, req, s, suffix, l)
+		return
+	}
+
+	key := s + "|" + h.clientIP(req)
+	if !h.attempts.Allow(key) {
+		renderPasswordForm(w, req, app.ErrTooManyAttempts, http.StatusTooManyRequests)
+		return
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(l.PasswordHash), []byte(req.PostFormValue("password")))
+	if err != nil {
+		h.attempts.Fail(key)
+		renderPasswordForm(w, req, app.ErrWrongPassword, http.StatusUnauthorized)
+		return
+	}
+	h.attempts.Reset(key)
+
+	exp := time.Now().Add(linkAccessTTL)
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.LinkAccessCookiePrefix + s,
+		Value:    auth.GetLinkAccess(s, l.PasswordHash, exp),
+		Path:     "/" + s,
+		Expires:  exp,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	h.redirect(w, req, s, suffix, l)
+}
+
+// hasLinkAccess reports whether client has already entered the link password.
+func hasLinkAccess(req *http.Request, short string, l models.LinkInfo) bool {
+	c, err := req.Cookie(auth.LinkAccessCookiePrefix + short)
+	if err != nil {
+		return false
+	}
+	return auth.CheckLinkAccess(short, l.PasswordHash, c.Value)
+}
+
+// renderPasswordForm asks for the link password; the form is posted back to the requested url.
+func renderPasswordForm(w http.ResponseWriter, req *http.Request, formErr error, code int) {
+	data := struct {
+		Action string
+		Error  string
+	}{Action: req.URL.RequestURI()}
+	if formErr != nil {
+		data.Error = formErr.Error()
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+
+	err := passwordForm.Execute(w, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// clientIP returns address of the client: the connected one, or the one in X-Real-IP header
+// if the request comes through a trusted proxy.
+func (h Handlers) clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = strings.TrimSpace(req.RemoteAddr)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	for _, proxy := range h.trustedProxies {
+		if !proxy.Contains(ip) {
+			continue
+		}
+		if forwarded := strings.TrimSpace(req.Header.Get("X-Real-IP")); net.ParseIP(forwarded) != nil {
+			return forwarded
+		}
+		break
+	}
+	return host
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// previewSuffix appended to a short code asks for the preview page instead of a redirect.
+const previewSuffix = "+"
+
+// confirmField is posted by the continue button of a forced interstitial.
+const confirmField = "confirm"
+
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="robots" content="noindex"><title>{{if .Title}}{{.Title}}{{else}}Link preview{{end}}</title></head>
+<body>
+{{if .Title}}<h1>{{.Title}}</h1>{{end}}
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+<p>The short link <code>{{.Short}}</code> leads to:</p>
+{{if .Protected}}<p>a password-protected destination</p>{{else}}<p><code>{{.Destination}}</code></p>{{end}}
+{{if .CreatedAt}}<p>Created {{.CreatedAt}}</p>{{end}}
+{{if .Continue}}<form method="post" action="{{.Continue}}"><input type="hidden" name="{{.ConfirmField}}" value="1"><button type="submit">Continue</button></form>{{end}}
+</body>
+</html>
+`))
+
+type previewData struct {
+	Short        string
+	Title        string
+	Description  string
+	Destination  string
+	CreatedAt    string
+	Continue     string
+	Protected    bool
+	ConfirmField string
+}
+
+// previewRequested reports whether client asked for the preview page: either with a "+" after the short code,
+// or with "preview" query param in a request accepting html.
+func previewRequested(req *http.Request, short string) (string, bool) {
+	if strings.HasSuffix(short, previewSuffix) {
+		return strings.TrimSuffix(short, previewSuffix), true
+	}
+
+	_, flag := req.URL.Query()["preview"]
+	return short, flag && strings.Contains(req.Header.Get("Accept"), "text/html")
+}
+
+// interstitialConfirmed reports whether client has pressed the continue button of a forced interstitial.
+func interstitialConfirmed(req *http.Request) bool {
+	return req.Method == http.MethodPost && req.PostFormValue(confirmField) != ""
+}
+
+// renderPreview writes the preview page of the link; for forced interstitials the continue button
+// posts the confirmation back to next, so the click is only counted once client follows the link.
+func renderPreview(w http.ResponseWriter, short string, l models.LinkInfo, next string) {
+	data := previewData{
+		Short:        app.FullLink(short),
+		Title:        l.Title,
+		Destination:  l.Long,
+		Continue:     next,
+		Protected:    l.PasswordHash != "" && next == "",
+		ConfirmField: confirmField,
+	}
+	if l.Metadata != nil && !data.Protected {
+		if data.Title == "" {
+			data.Title = l.Metadata.Title
+		}
+		data.Description = l.Metadata.Description
+	}
+	if !l.CreatedAt.IsZero() {
+		data.CreatedAt = l.CreatedAt.UTC().Format("2 Jan 2006 15:04 MST")
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.WriteHeader(http.StatusOK)
+
+	err := previewPage.Execute(w, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
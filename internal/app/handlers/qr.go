@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/qr"
+)
+
+// qrCachePolicy makes clients revalidate the code with its ETag, so it stops being served once the link is deleted.
+const qrCachePolicy = "no-cache"
+
+// QRHandler renders QR code of the short link in format, size and error correction level passed via query.
+func (h Handlers) QRHandler(w http.ResponseWriter, req *http.Request) {
+	s := chi.URLParam(req, "id")
+
+	opts, err := qrOptions(req.URL.Query().Get("format"), req.URL.Query().Get("size"), req.URL.Query().Get("ecc"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	l, err := h.store.Lookup(req.Context(), s)
+	if err != nil || l.IsDeleted {
+		http.Error(w, app.ErrLinkNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	full := app.FullLink(s)
+	sum := sha256.Sum256([]byte(full + "|" + opts.Format + "|" + strconv.Itoa(opts.Size) + "|" + opts.ECC))
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+	w.Header().Set("Cache-Control", qrCachePolicy)
+	w.Header().Set("ETag", etag)
+	if req.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	b, err := qr.Render(full, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", opts.ContentType())
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(b)
+	if err != nil {
+		h.logger.Error(err)
+	}
+}
+
+func qrOptions(format, size, ecc string) (qr.Options, error) {
+	opts := qr.Options{Format: format, ECC: ecc}
+	if size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return qr.Options{}, app.ErrInvalidQRSize
+		}
+		opts.Size = n
+	}
+
+	err := opts.Validate()
+	if err != nil {
+		return qr.Options{}, err
+	}
+	return opts, nil
+}
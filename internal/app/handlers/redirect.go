@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/clicks"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// cacheDirective matches Cache-Control response directives which may be used as a link cache policy.
+var cacheDirective = regexp.MustCompile(`^(no-store|no-cache|private|public|must-revalidate|immutable|(max-age|s-maxage|stale-while-revalidate)=\d+)$`)
+
+// redirect counts and records the click and sends client to the full url address of the link,
+// or shows the interstitial page if the owner forced it for the link and client hasn't confirmed it yet.
+// Destinations blocked by screening get a warning page instead.
+// Form submissions are always answered with 303 See Other so the destination is fetched with GET.
+func (h Handlers) redirect(w http.ResponseWriter, req *http.Request, short, suffix string, l models.LinkInfo) {
+	dest, err := destination(ruleTarget(w, req, short, l), l, suffix, req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if h.blockedDestination(w, req, short, dest) {
+		return
+	}
+
+	if l.Preview && !interstitialConfirmed(req) {
+		renderPreview(w, short, l, req.URL.RequestURI())
+		return
+	}
+
+	if l.MaxClicks > 0 && req.Method != http.MethodHead {
+		err := h.store.ConsumeClick(req.Context(), short)
+		if err != nil {
+			h.linkErrorResponse(w, req, short, err)
+			return
+		}
+	}
+
+	code := l.RedirectCode
+	if code == 0 {
+		code = h.redirectCode
+	}
+	if req.Method == http.MethodPost {
+		code = http.StatusSeeOther
+	}
+
+	policy := l.CachePolicy
+	if policy == "" {
+		policy = h.cachePolicy
+	}
+	// a cached redirect would bypass the password prompt, the click limit and the redirect rules
+	if l.PasswordHash != "" || l.MaxClicks > 0 || len(l.Rules) > 0 {
+		policy = "no-store"
+	}
+	if policy != "" {
+		w.Header().Set("Cache-Control", policy)
+	}
+
+	if req.Method != http.MethodHead {
+		h.clicks.Record(clicks.Visit{
+			Short:     short,
+			IP:        h.clientIP(req),
+			Referrer:  req.Referer(),
+			UserAgent: req.UserAgent(),
+			Time:      time.Now(),
+		})
+		h.clicked(req, short, l)
+	}
+
+	w.Header().Add("Location", dest)
+	w.WriteHeader(code)
+
+	_, err = w.Write([]byte{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// destination returns the target address of the link with forwarded path suffix and query params applied.
+func destination(target string, l models.LinkInfo, suffix string, query url.Values) (string, error) {
+	forwardPath := l.ForwardPath && suffix != ""
+	forwardQuery := l.ForwardQuery && len(query) > 0
+	if !forwardPath && !forwardQuery {
+		return target, nil
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+
+	if forwardPath {
+		// cleaning keeps the suffix from escaping the destination path with ".."
+		p := path.Clean(suffix)
+		if strings.HasSuffix(suffix, "/") && p != "/" {
+			p += "/"
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + p
+		u.RawPath = ""
+	}
+
+	if forwardQuery {
+		dst := u.Query()
+		for k, v := range query {
+			_, exist := dst[k]
+			switch {
+			case !exist || l.QueryConflict == models.QueryConflictRequest:
+				dst[k] = v
+			case l.QueryConflict == models.QueryConflictAppend:
+				dst[k] = append(dst[k], v...)
+			}
+		}
+		u.RawQuery = dst.Encode()
+	}
+
+	return u.String(), nil
+}
+
+// linkPath splits request path into the short code and the path suffix after it.
+func linkPath(req *http.Request) (string, string) {
+	p := strings.TrimPrefix(req.URL.Path, "/") // chi.UrlParam not working in tests
+	i := strings.IndexByte(p, '/')
+	if i < 0 {
+		return p, ""
+	}
+	return p[:i], p[i:]
+}
+
+func validateQueryConflict(policy string) error {
+	switch policy {
+	case "", models.QueryConflictDestination, models.QueryConflictRequest, models.QueryConflictAppend:
+		return nil
+	}
+	return app.ErrInvalidConflict
+}
+
+// linkErrorResponse writes an error for a link that can't be followed.
+func (h Handlers) linkErrorResponse(w http.ResponseWriter, req *http.Request, short string, err error) {
+	switch {
+	case errors.Is(err, app.ErrLinkNotActive):
+		h.notActiveResponse(w, req, short)
+	case errors.Is(err, app.ErrDeletedLink), errors.Is(err, app.ErrExpiredLink), errors.Is(err, app.ErrExhaustedLink),
+		errors.Is(err, app.ErrLinkInactive):
+		http.Error(w, err.Error(), http.StatusGone)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// notActiveResponse tells client that the scheduled link goes live later,
+// either by the configured status code or by a redirect to the configured page.
+func (h Handlers) notActiveResponse(w http.ResponseWriter, req *http.Request, short string) {
+	l, err := h.store.Lookup(req.Context(), short)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	from := l.ActiveFrom.UTC().Format(time.RFC3339)
+
+	w.Header().Set("Cache-Control", "no-store")
+	if h.notActiveURL != "" {
+		u, err := url.Parse(h.notActiveURL)
+		if err == nil {
+			q := u.Query()
+			q.Set("starts_at", from)
+			u.RawQuery = q.Encode()
+			http.Redirect(w, req, u.String(), http.StatusFound)
+			return
+		}
+		h.logger.Errorf("invalid not active url %q: %v", h.notActiveURL, err)
+	}
+
+	if wait := int64(time.Until(l.ActiveFrom).Seconds()); wait > 0 {
+		w.Header().Set("Retry-After", strconv.FormatInt(wait+1, 10))
+	}
+	http.Error(w, app.ErrLinkNotActive.Error()+", it goes live at "+from, h.notActiveStatus)
+}
+
+func validateRedirectCode(code int) error {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return nil
+	}
+	return app.ErrInvalidRedirect
+}
+
+func validateCachePolicy(policy string) error {
+	if policy == "" {
+		return nil
+	}
+	for _, d := range strings.Split(policy, ",") {
+		if !cacheDirective.MatchString(strings.ToLower(strings.TrimSpace(d))) {
+			return app.ErrInvalidCache
+		}
+	}
+	return nil
+}
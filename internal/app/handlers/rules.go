@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+const (
+	variantCookiePrefix = "Variant_"
+	variantCookieTTL    = 30 * 24 * time.Hour
+)
+
+// GetRulesHandler returns redirect rules of the user's link.
+func (h Handlers) GetRulesHandler(w http.ResponseWriter, req *http.Request) {
+	uid, err := checkAuthCookie(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	l, err := h.store.Lookup(req.Context(), chi.URLParam(req, "id"))
+	if err != nil || l.UUID != uid {
+		http.Error(w, app.ErrLinkNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	rules := l.Rules
+	if rules == nil {
+		rules = []models.RedirectRule{}
+	}
+
+	jRes, err := json.Marshal(rules)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(jRes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// SetRulesHandler replaces redirect rules of the user's link with rules passed via JSON.
+func (h Handlers) SetRulesHandler(w http.ResponseWriter, req *http.Request) {
+	uid, err := checkAuthCookie(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		http.Error(w, app.ErrEmptyBodyPostReq.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var rules []models.RedirectRule
+	err = json.Unmarshal(b, &rules)
+	if err != nil {
+		http.Error(w, app.ErrEmptyBodyPostReq.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = app.ValidateRules(rules)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.screenLink(req.Context(), "", models.LinkOptions{Rules: rules})
+	if err != nil {
+		screenErrorResponse(w, err)
+		return
+	}
+
+	_, err = h.store.Update(req.Context(), uid, chi.URLParam(req, "id"), func(l *models.LinkInfo) error {
+		l.Rules = rules
+		return nil
+	})
+	if err != nil {
+		editErrorResponse(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ruleTarget evaluates redirect rules of the link and returns the address the client should be sent to.
+// Split assignments are remembered in a cookie, so the client keeps getting the same variant.
+func ruleTarget(w http.ResponseWriter, req *http.Request, short string, l models.LinkInfo) string {
+	if len(l.Rules) == 0 {
+		return l.Long
+	}
+
+	i := app.MatchRule(l.Rules, app.Visit{
+		UserAgent:      req.UserAgent(),
+		AcceptLanguage: req.Header.Get("Accept-Language"),
+		Referrer:       req.Referer(),
+		Time:           time.Now(),
+	})
+	if i < 0 {
+		return l.Long
+	}
+
+	r := l.Rules[i]
+	if len(r.Split) == 0 {
+		return r.Target
+	}
+
+	name := variantCookiePrefix + short
+	if v, ok := stickyVariant(req, name, i, len(r.Split)); ok {
+		return r.Split[v].URL
+	}
+
+	v := app.PickSplit(r.Split)
+	if req.Method != http.MethodHead {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    strconv.Itoa(i) + "." + strconv.Itoa(v),
+			Path:     "/" + short,
+			Expires:  time.Now().Add(variantCookieTTL),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return r.Split[v].URL
+}
+
+// stickyVariant returns split variant previously assigned to the client by the rule.
+func stickyVariant(req *http.Request, name string, rule, variants int) (int, bool) {
+	c, err := req.Cookie(name)
+	if err != nil {
+		return 0, false
+	}
+
+	parts := strings.SplitN(c.Value, ".", 2)
+	if len(parts) != 2 || parts[0] != strconv.Itoa(rule) {
+		return 0, false
+	}
+
+	v, err := strconv.Atoi(parts[1])
+	if err != nil || v < 0 || v >= variants {
+		return 0, false
+	}
+	return v, true
+}
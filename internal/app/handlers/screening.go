@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/config"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+	"github.com/DrGermanius/Shortener/internal/app/screening"
+)
+
+var blockedPage = template.Must(template.New("blocked").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="robots" content="noindex"><title>Warning: unsafe link</title></head>
+<body>
+<h1>Warning: this link has been blocked</h1>
+<p>The destination of the short link <code>{{.Short}}</code> was flagged as unsafe and the redirect was stopped.</p>
+<p>Reason: {{.Reason}}</p>
+</body>
+</html>
+`))
+
+// newScreener creates screener from config; a broken list is logged and replaced with an empty one
+// until the watcher manages to reload it.
+func newScreener(ctx context.Context, logger *zap.SugaredLogger) *screening.Screener {
+	c := config.Config()
+
+	s, err := screening.New(c.BlocklistDomains, c.BlocklistRules)
+	if err != nil {
+		logger.Errorf("error while reading screening lists: %v", err)
+	}
+
+	if c.BlocklistDomains != "" || c.BlocklistRules != "" {
+		interval, err := time.ParseDuration(c.BlocklistReload)
+		if err != nil || interval <= 0 {
+			interval = 30 * time.Second
+			logger.Errorf("error while reading config blocklist reload interval %q, using %v", c.BlocklistReload, interval)
+		}
+		go s.Watch(ctx, interval, logger)
+	}
+	return s
+}
+
+// screenLink checks the destination of a link and every target of its redirect rules; empty long is skipped.
+func (h Handlers) screenLink(ctx context.Context, long string, opts models.LinkOptions) error {
+	urls := []string{long}
+	for _, r := range opts.Rules {
+		urls = append(urls, r.Target)
+		for _, s := range r.Split {
+			urls = append(urls, s.URL)
+		}
+	}
+
+	for _, u := range urls {
+		if u == "" {
+			continue
+		}
+		err := h.screener.Check(ctx, u)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// screenErrorResponse writes an error of link screening.
+func screenErrorResponse(w http.ResponseWriter, err error) {
+	if errors.Is(err, app.ErrBlockedURL) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// blockedDestination reports whether the destination has been blocked since the link was created
+// and writes the warning page in that case. Failures of screening don't stop the redirect.
+func (h Handlers) blockedDestination(w http.ResponseWriter, req *http.Request, short, dest string) bool {
+	err := h.screener.Check(req.Context(), dest)
+	if err == nil {
+		return false
+	}
+
+	var blocked *screening.BlockedError
+	if !errors.As(err, &blocked) {
+		h.logger.Errorf("screening of %s failed: %v", short, err)
+		return false
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+
+	err = blockedPage.Execute(w, struct {
+		Short  string
+		Reason string
+	}{Short: app.FullLink(short), Reason: blocked.Reason})
+	if err != nil {
+		h.logger.Error(err)
+	}
+	return true
+}
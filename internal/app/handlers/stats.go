@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/hll"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+const (
+	// maxBuckets limits the number of buckets of a time series.
+	maxBuckets = 1440
+	// defaultVisitorsSpan is the time range unique visitors are estimated for if "from" param is missing.
+	defaultVisitorsSpan = 30 * 24 * time.Hour
+	// maxVisitorsSpan limits the time range of unique visitors, so a request merges a bounded number of daily sketches;
+	// older sketches aren't kept anyway.
+	maxVisitorsSpan = models.VisitorRetention
+)
+
+// defaultSpans are time ranges of time series of the intervals if "from" param is missing.
+var defaultSpans = map[string]time.Duration{
+	models.IntervalMinute: time.Hour,
+	models.IntervalHour:   24 * time.Hour,
+	models.IntervalDay:    30 * 24 * time.Hour,
+}
+
+// LinkStatsHandler returns click totals of the user's link with its top referrers, countries and devices.
+// Unique visitors are estimated by merging daily sketches within ["from", "to") params, the last 30 days by default
+// and a year at most; see hll.StdError for the error bounds.
+func (h Handlers) LinkStatsHandler(w http.ResponseWriter, req *http.Request) {
+	uid, err := checkAuthCookie(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	short := chi.URLParam(req, "id")
+	l, err := h.store.Lookup(req.Context(), short)
+	if err != nil || l.UUID != uid {
+		http.Error(w, app.ErrLinkNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	from, to, err := visitorsParams(req, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stats, err := h.store.ClickStats(req.Context(), short)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	stats.ShortURL = app.FullLink(short)
+	stats.UniqueVisitorsFrom, stats.UniqueVisitorsTo = from, to
+
+	sketches, err := h.store.VisitorSketches(req.Context(), short, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	stats.UniqueVisitors = mergeSketches(sketches).Estimate()
+	stats.UniqueVisitorsError = hll.StdError
+
+	jRes, err := json.Marshal(stats)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(jRes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// LinkTimeSeriesHandler returns clicks of the user's link split into buckets of "interval" param (minute, hour, day)
+// within ["from", "to") params, with top referrers, browsers, operating systems and devices of every bucket.
+// Daily buckets report estimated unique visitors as well.
+// Minute buckets are kept for models.Retention only, so a series of minutes can't start earlier.
+// The series is built from rollups, so its cost depends on the number of buckets rather than clicks.
+func (h Handlers) LinkTimeSeriesHandler(w http.ResponseWriter, req *http.Request) {
+	uid, err := checkAuthCookie(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	short := chi.URLParam(req, "id")
+	l, err := h.store.Lookup(req.Context(), short)
+	if err != nil || l.UUID != uid {
+		http.Error(w, app.ErrLinkNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	interval, from, to, err := timeSeriesParams(req, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rollups, err := h.store.ClickRollups(req.Context(), short, interval, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	series := timeSeries(app.FullLink(short), interval, from, to, rollups)
+	if interval == models.VisitorInterval {
+		sketches, err := h.store.VisitorSketches(req.Context(), short, from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		addUniqueVisitors(series, sketches)
+	}
+
+	jRes, err := json.Marshal(series)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write(jRes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// timeSeriesParams returns the interval and the time range of a time series; from is aligned to the interval.
+func timeSeriesParams(req *http.Request, now time.Time) (string, time.Time, time.Time, error) {
+	q := req.URL.Query()
+	interval := q.Get("interval")
+	if interval == "" {
+		interval = models.IntervalHour
+	}
+	d, ok := models.Intervals[interval]
+	if !ok {
+		return "", time.Time{}, time.Time{}, app.ErrInvalidInterval
+	}
+
+	to := now.UTC()
+	if v := q.Get("to"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return "", time.Time{}, time.Time{}, app.ErrInvalidTimeRange
+		}
+		to = t
+	}
+	from := to.Add(-defaultSpans[interval])
+	if v := q.Get("from"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return "", time.Time{}, time.Time{}, app.ErrInvalidTimeRange
+		}
+		from = t
+	}
+
+	from = from.Truncate(d)
+	if !from.Before(to) || bucketCount(from, to, d) > maxBuckets {
+		return "", time.Time{}, time.Time{}, app.ErrInvalidTimeRange
+	}
+	// rollups older than their retention are gone, so the series would be empty rather than true
+	if r, ok := models.Retention[interval]; ok && from.Before(now.Add(-r).Truncate(d)) {
+		return "", time.Time{}, time.Time{}, app.ErrInvalidTimeRange
+	}
+	return interval, from, to, nil
+}
+
+// visitorsParams returns the time range unique visitors are estimated for; from is aligned to a day.
+func visitorsParams(req *http.Request, now time.Time) (time.Time, time.Time, error) {
+	q := req.URL.Query()
+	to := now.UTC()
+	if v := q.Get("to"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return time.Time{}, time.Time{}, app.ErrInvalidTimeRange
+		}
+		to = t
+	}
+	from := to.Add(-defaultVisitorsSpan)
+	if v := q.Get("from"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return time.Time{}, time.Time{}, app.ErrInvalidTimeRange
+		}
+		from = t
+	}
+
+	from = from.Truncate(models.Intervals[models.VisitorInterval])
+	if !from.Before(to) || to.Sub(from) > maxVisitorsSpan {
+		return time.Time{}, time.Time{}, app.ErrInvalidTimeRange
+	}
+	return from, to, nil
+}
+
+// parseTime parses RFC 3339 time or a date.
+func parseTime(v string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		t, err = time.Parse("2006-01-02", v)
+	}
+	return t.UTC(), err
+}
+
+// bucketCount returns the number of buckets of length d starting within [from, to).
+func bucketCount(from, to time.Time, d time.Duration) int64 {
+	return int64((to.Sub(from) + d - 1) / d)
+}
+
+// timeSeries puts the rollups into buckets; buckets without clicks are present as well.
+func timeSeries(shortURL, interval string, from, to time.Time, rollups []models.ClickRollup) models.TimeSeries {
+	d := models.Intervals[interval]
+	n := bucketCount(from, to, d)
+
+	buckets := make([]models.TimeBucket, n)
+	breakdowns := make([]map[string]map[string]int64, n)
+	for i := range buckets {
+		buckets[i].Start = from.Add(time.Duration(i) * d)
+		breakdowns[i] = make(map[string]map[string]int64)
+	}
+
+	for _, r := range rollups {
+		i := int64(r.Bucket.Sub(from) / d)
+		if i < 0 || i >= n {
+			continue
+		}
+		if r.Dimension == models.DimensionTotal {
+			buckets[i].Clicks += r.Clicks
+			continue
+		}
+		counts, ok := breakdowns[i][r.Dimension]
+		if !ok {
+			counts = make(map[string]int64)
+			breakdowns[i][r.Dimension] = counts
+		}
+		counts[r.Value] += r.Clicks
+	}
+
+	for i := range buckets {
+		buckets[i].Referrers = models.TopStats(breakdowns[i][models.DimensionReferrer])
+		buckets[i].Browsers = models.TopStats(breakdowns[i][models.DimensionBrowser])
+		buckets[i].OS = models.TopStats(breakdowns[i][models.DimensionOS])
+		buckets[i].Devices = models.TopStats(breakdowns[i][models.DimensionDevice])
+	}
+
+	return models.TimeSeries{
+		ShortURL: shortURL,
+		Interval: interval,
+		From:     from,
+		To:       to,
+		Buckets:  buckets,
+	}
+}
+
+// addUniqueVisitors sets estimates of unique visitors of the series buckets; buckets without a sketch had none.
+func addUniqueVisitors(series models.TimeSeries, sketches []models.VisitorSketch) {
+	estimates := make(map[int64]int64, len(sketches))
+	for _, s := range sketches {
+		estimates[s.Bucket.Unix()] = s.Sketch.Estimate()
+	}
+	for i := range series.Buckets {
+		n := estimates[series.Buckets[i].Start.Unix()]
+		series.Buckets[i].UniqueVisitors = &n
+	}
+}
+
+// mergeSketches returns the sketch of visitors of all the buckets.
+func mergeSketches(sketches []models.VisitorSketch) *hll.Sketch {
+	res := hll.New()
+	for _, s := range sketches {
+		res.Merge(s.Sketch)
+	}
+	return res
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/events"
+)
+
+const (
+	// maxStreams limits the number of concurrent streams of a user.
+	maxStreams = 5
+	// heartbeatInterval keeps idle streams from being closed by proxies.
+	heartbeatInterval = 15 * time.Second
+	// streamRetry is the reconnection delay suggested to clients, in milliseconds.
+	streamRetry = 3000
+)
+
+// StreamHandler pushes clicks and lifecycle events of the user's links as Server-Sent Events.
+// A reconnecting client resumes from its Last-Event-ID header while the missed events are still kept,
+// otherwise it receives a stream.reset event and has to reload the state of its links.
+// A client which can't keep up is disconnected and may resume the same way.
+func (h Handlers) StreamHandler(w http.ResponseWriter, req *http.Request) {
+	uid, err := checkAuthCookie(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, app.ErrStreamingUnsupported.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// a malformed id is treated as a fresh start
+	lastSeq, _ := strconv.ParseUint(req.Header.Get("Last-Event-ID"), 10, 64)
+	sub, missed, err := h.events.Subscribe(uid, lastSeq, maxStreams)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	defer h.events.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+	for _, m := range missed {
+		if err != nil {
+			return
+		}
+		err = writeStreamEvent(w, m)
+	}
+	if err != nil {
+		return
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case m := <-sub.Messages():
+			err = writeStreamEvent(w, m)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case <-sub.Evicted():
+			return
+		case <-req.Context().Done():
+			return
+		case <-h.context.Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeStreamEvent(w http.ResponseWriter, m events.Message) error {
+	b, err := json.Marshal(m.Event)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", m.Seq, m.Event.Type, b)
+	return err
+}
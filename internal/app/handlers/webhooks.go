@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+const (
+	// maxWebhooks limits the number of webhooks of a user.
+	maxWebhooks = 20
+	// maxDeliveries limits the number of deliveries returned at once.
+	maxDeliveries = 100
+)
+
+// CreateWebhookHandler registers a webhook of the user notified about events of the types listed in "events",
+// or of every type if it's empty. The response carries the secret deliveries are signed with,
+// which isn't returned anymore afterwards.
+func (h Handlers) CreateWebhookHandler(w http.ResponseWriter, req *http.Request) {
+	uid, err := checkAuthCookie(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var wReq models.WebhookRequest
+	err = json.NewDecoder(req.Body).Decode(&wReq)
+	defer req.Body.Close()
+	if err != nil {
+		http.Error(w, app.ErrEmptyBodyPostReq.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, err := webhookEvents(wReq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hooks, err := h.store.Webhooks(req.Context(), uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(hooks) >= maxWebhooks {
+		http.Error(w, app.ErrTooManyWebhooks.Error(), http.StatusConflict)
+		return
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	hook := models.Webhook{
+		ID:        uuid.NewString(),
+		UID:       uid,
+		URL:       wReq.URL,
+		Events:    events,
+		Secret:    hex.EncodeToString(secret),
+		CreatedAt: time.Now().UTC(),
+	}
+	err = h.store.CreateWebhook(req.Context(), hook)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.webhooks.Forget(uid)
+
+	writeJSON(w, http.StatusCreated, hook)
+}
+
+// WebhooksHandler returns webhooks of the user without their secrets.
+func (h Handlers) WebhooksHandler(w http.ResponseWriter, req *http.Request) {
+	uid, err := checkAuthCookie(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	hooks, err := h.store.Webhooks(req.Context(), uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	if hooks == nil {
+		hooks = []models.Webhook{}
+	}
+
+	writeJSON(w, http.StatusOK, hooks)
+}
+
+// DeleteWebhookHandler deletes the user's webhook with its pending and logged deliveries.
+func (h Handlers) DeleteWebhookHandler(w http.ResponseWriter, req *http.Request) {
+	uid, err := checkAuthCookie(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = h.store.DeleteWebhook(req.Context(), uid, chi.URLParam(req, "id"))
+	if errors.Is(err, app.ErrWebhookNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.webhooks.Forget(uid)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebhookDeliveriesHandler returns the latest deliveries of the user's webhook, newest first.
+// "status" param filters them by status; status "dead" lists deliveries which ran out of attempts.
+func (h Handlers) WebhookDeliveriesHandler(w http.ResponseWriter, req *http.Request) {
+	uid, err := checkAuthCookie(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status := req.URL.Query().Get("status")
+	switch status {
+	case "", models.DeliveryPending, models.DeliveryDelivered, models.DeliveryDead:
+	default:
+		http.Error(w, app.ErrInvalidDeliveryStatus.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := chi.URLParam(req, "id")
+	hook, err := h.store.Webhook(req.Context(), id)
+	if err != nil || hook.UID != uid {
+		http.Error(w, app.ErrWebhookNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	deliveries, err := h.store.Deliveries(req.Context(), uid, id, status, maxDeliveries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []models.Delivery{}
+	}
+
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// RetryDeliveryHandler queues a dead delivery of the user's webhook again with a fresh number of attempts.
+func (h Handlers) RetryDeliveryHandler(w http.ResponseWriter, req *http.Request) {
+	uid, err := checkAuthCookie(w, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	d, err := h.store.RetryDelivery(req.Context(), uid, chi.URLParam(req, "delivery"))
+	if err == nil && d.WebhookID != chi.URLParam(req, "id") {
+		err = app.ErrDeliveryNotFound
+	}
+	switch {
+	case errors.Is(err, app.ErrDeliveryNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, app.ErrDeliveryNotDead):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.webhooks.Wake()
+
+	writeJSON(w, http.StatusAccepted, d)
+}
+
+// webhookEvents validates the webhook request and returns its distinct event types.
+func webhookEvents(wReq models.WebhookRequest) ([]string, error) {
+	u, err := url.Parse(wReq.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, app.ErrInvalidWebhook
+	}
+	if len(wReq.Events) == 0 {
+		return models.EventTypes, nil
+	}
+
+	seen := make(map[string]bool)
+	var events []string
+	for _, e := range wReq.Events {
+		known := false
+		for _, t := range models.EventTypes {
+			known = known || e == t
+		}
+		if !known {
+			return nil, app.ErrInvalidWebhook
+		}
+		if !seen[e] {
+			seen[e] = true
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	jRes, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	_, err = w.Write(jRes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
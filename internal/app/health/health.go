@@ -0,0 +1,211 @@
+// Package health periodically checks whether destinations of links still respond.
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/config"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// batchSize is the number of links fetched from the store per query.
+const batchSize = 500
+
+// Store is the part of the links storage the checker works with.
+type Store interface {
+	HealthTargets(ctx context.Context, checkedBefore time.Time, limit int) ([]models.HealthTarget, error)
+	SetHealth(ctx context.Context, short string, health models.LinkHealth) error
+}
+
+// Checker sends HEAD (or GET if HEAD isn't supported) requests to link destinations
+// with bounded concurrency, never querying the same host more often than once per host delay.
+type Checker struct {
+	store       Store
+	client      *http.Client
+	logger      *zap.SugaredLogger
+	interval    time.Duration
+	concurrency int
+	hostDelay   time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*host
+}
+
+type host struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// NewChecker creates checker configured by HEALTH_CHECK_* options.
+func NewChecker(store Store, logger *zap.SugaredLogger) *Checker {
+	interval, err := time.ParseDuration(config.Config().HealthInterval)
+	if err != nil || interval <= 0 {
+		interval = time.Hour
+		logger.Errorf("error while reading config health check interval %q, using %v", config.Config().HealthInterval, interval)
+	}
+	concurrency, err := strconv.Atoi(config.Config().HealthConcurrency)
+	if err != nil || concurrency <= 0 {
+		concurrency = 5
+		logger.Errorf("error while reading config health check concurrency %q, using %d", config.Config().HealthConcurrency, concurrency)
+	}
+	hostDelay, err := time.ParseDuration(config.Config().HealthHostDelay)
+	if err != nil || hostDelay < 0 {
+		hostDelay = time.Second
+		logger.Errorf("error while reading config health check host delay %q, using %v", config.Config().HealthHostDelay, hostDelay)
+	}
+	timeout, err := time.ParseDuration(config.Config().HealthTimeout)
+	if err != nil || timeout <= 0 {
+		timeout = 10 * time.Second
+		logger.Errorf("error while reading config health check timeout %q, using %v", config.Config().HealthTimeout, timeout)
+	}
+
+	return &Checker{
+		store:       store,
+		client:      app.NewOutboundClient(timeout),
+		logger:      logger,
+		interval:    interval,
+		concurrency: concurrency,
+		hostDelay:   hostDelay,
+		hosts:       make(map[string]*host),
+	}
+}
+
+// Run checks links which are due every tenth of the check interval until ctx is done.
+func (c *Checker) Run(ctx context.Context) {
+	t := time.NewTicker(c.interval / 10)
+	defer t.Stop()
+
+	for {
+		n, err := c.CheckDue(ctx, time.Now())
+		if err != nil {
+			c.logger.Errorf("health check failed: %v", err)
+		} else if n > 0 {
+			c.logger.Infof("health check visited %d links", n)
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// CheckDue checks links not checked within the check interval before now and returns their number.
+// It fetches further batches only while every result of the previous one has been saved,
+// otherwise the links which failed would be fetched again and again; they wait for the next call.
+func (c *Checker) CheckDue(ctx context.Context, now time.Time) (int, error) {
+	total := 0
+	for {
+		targets, err := c.store.HealthTargets(ctx, now.Add(-c.interval), batchSize)
+		if err != nil {
+			return total, err
+		}
+
+		jobs := make(chan models.HealthTarget)
+		var wg sync.WaitGroup
+		var failed int32
+		for i := 0; i < c.concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for t := range jobs {
+					err := c.store.SetHealth(ctx, t.Short, c.Check(ctx, t.Long))
+					if err != nil {
+						atomic.AddInt32(&failed, 1)
+						c.logger.Errorf("health of %s wasn't saved: %v", t.Short, err)
+					}
+				}
+			}()
+		}
+	send:
+		for _, t := range targets {
+			select {
+			case jobs <- t:
+			case <-ctx.Done():
+				break send
+			}
+		}
+		close(jobs)
+		wg.Wait()
+
+		total += len(targets)
+		if len(targets) < batchSize || failed > 0 || ctx.Err() != nil {
+			c.mu.Lock()
+			c.hosts = make(map[string]*host)
+			c.mu.Unlock()
+			return total, ctx.Err()
+		}
+	}
+}
+
+// Check requests the destination and reports its health.
+func (c *Checker) Check(ctx context.Context, long string) models.LinkHealth {
+	u, err := url.Parse(long)
+	if err != nil {
+		return models.LinkHealth{CheckedAt: time.Now(), Error: err.Error()}
+	}
+
+	h := c.host(u.Host)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if wait := time.Until(h.last.Add(c.hostDelay)); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return models.LinkHealth{CheckedAt: time.Now(), Error: ctx.Err().Error()}
+		}
+	}
+	defer func() { h.last = time.Now() }()
+
+	start := time.Now()
+	status, err := c.request(ctx, http.MethodHead, long)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = c.request(ctx, http.MethodGet, long)
+	}
+
+	res := models.LinkHealth{
+		Status:    status,
+		LatencyMS: time.Since(start).Milliseconds(),
+		CheckedAt: time.Now(),
+		Healthy:   err == nil && status < http.StatusBadRequest,
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
+
+func (c *Checker) request(ctx context.Context, method, long string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, long, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func (c *Checker) host(name string) *host {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, ok := c.hosts[name]
+	if !ok {
+		h = new(host)
+		c.hosts[name] = h
+	}
+	return h
+}
@@ -0,0 +1,48 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// failingStore always has a full batch of due links and never saves their health.
+type failingStore struct {
+	queries int
+}
+
+func (s *failingStore) HealthTargets(_ context.Context, _ time.Time, limit int) ([]models.HealthTarget, error) {
+	s.queries++
+	targets := make([]models.HealthTarget, limit)
+	for i := range targets {
+		targets[i] = models.HealthTarget{Short: strconv.Itoa(i), Long: "://malformed"}
+	}
+	return targets, nil
+}
+
+func (s *failingStore) SetHealth(context.Context, string, models.LinkHealth) error {
+	return errors.New("storage is read-only")
+}
+
+func TestCheckDueStopsWhenSavingFails(t *testing.T) {
+	store := &failingStore{}
+	c := &Checker{
+		store:       store,
+		logger:      zap.NewNop().Sugar(),
+		interval:    time.Hour,
+		concurrency: 5,
+		hosts:       make(map[string]*host),
+	}
+
+	n, err := c.CheckDue(context.Background(), time.Now())
+	require.NoError(t, err)
+	require.Equal(t, batchSize, n)
+	require.Equal(t, 1, store.queries)
+}
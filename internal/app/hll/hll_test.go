@@ -0,0 +1,66 @@
+package hll
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func add(s *Sketch, from, to int) {
+	for i := from; i < to; i++ {
+		s.Add(Hash([]byte(strconv.Itoa(i))))
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	require.Zero(t, New().Estimate())
+
+	for _, n := range []int{1, 10, 100, 1000, 10000, 100000} {
+		s := New()
+		add(s, 0, n)
+		// the same items don't change the estimate
+		add(s, 0, n)
+
+		e := s.Estimate()
+		// the hash is deterministic, so estimates of these sets are the same on every run
+		require.InDelta(t, n, e, math.Max(1, 3*StdError*float64(n)), "%d items", n)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, b, union := New(), New(), New()
+	add(a, 0, 6000)
+	add(b, 4000, 10000)
+	add(union, 0, 10000)
+
+	merged := New()
+	merged.Merge(a)
+	merged.Merge(b)
+	require.Equal(t, union, merged)
+	require.InDelta(t, 10000, merged.Estimate(), 3*StdError*10000)
+
+	// merging is idempotent and keeps the other sketch
+	before := *b
+	merged.Merge(b)
+	require.Equal(t, union, merged)
+	require.Equal(t, before, *b)
+}
+
+func TestMarshal(t *testing.T) {
+	s := New()
+	add(s, 0, 500)
+
+	b, err := s.MarshalBinary()
+	require.NoError(t, err)
+	require.Len(t, b, 1+registers)
+
+	got := New()
+	require.NoError(t, got.UnmarshalBinary(b))
+	require.Equal(t, s, got)
+
+	require.ErrorIs(t, got.UnmarshalBinary(b[:10]), errMalformed)
+	b[0] = version + 1
+	require.ErrorIs(t, got.UnmarshalBinary(b), errMalformed)
+}
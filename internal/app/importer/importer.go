@@ -0,0 +1,271 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// Policies of importing a link whose alias is taken or can't be used.
+const (
+	OnConflictRename = "rename" // give the link the generated short code
+	OnConflictSkip   = "skip"   // report the row as failed
+)
+
+// Statuses of imported rows.
+const (
+	StatusCreated = "created"
+	StatusRenamed = "renamed"
+	StatusExists  = "exists"
+	StatusFailed  = "failed"
+)
+
+// chunkSize is the number of rows written with a single BatchWrite call.
+const chunkSize = 100
+
+var errInvalidURL = errors.New("original url must be an absolute http or https address")
+
+// Store is the part of the links storage the importer works with.
+type Store interface {
+	Lookup(ctx context.Context, short string) (models.LinkInfo, error)
+	FindLink(ctx context.Context, long string) (string, error)
+	Write(ctx context.Context, uid, long string, opts models.LinkOptions) (string, error)
+	BatchWrite(ctx context.Context, uid string, originals []models.BatchOriginal) ([]string, error)
+}
+
+// Item is a link to be written, or the error which makes it invalid.
+type Item struct {
+	ID   string // line number or correlation id results of the item refer to
+	Link models.BatchOriginal
+	Err  error
+}
+
+// Importer writes links in bulk on behalf of a user.
+type Importer struct {
+	Store      Store
+	OnConflict string
+
+	// Validate prepares options of the link the same way link creation does.
+	Validate func(ctx context.Context, o *models.BatchOriginal) error
+	// Progress receives results of every written chunk.
+	Progress func(results []models.JobResult)
+	// Created is called for every new link of the user.
+	Created func(ctx context.Context, uid, short, long string)
+}
+
+// Run imports the rows read from an import file.
+func (im Importer) Run(ctx context.Context, uid string, rows []Row) error {
+	return im.Write(ctx, uid, Items(rows))
+}
+
+// Items converts the rows read from an import file to items.
+func Items(rows []Row) []Item {
+	items := make([]Item, len(rows))
+	for i, row := range rows {
+		items[i] = Item{
+			ID: strconv.Itoa(row.Line),
+			Link: models.BatchOriginal{
+				CorrelationID: strconv.Itoa(row.Line),
+				OriginalURL:   row.URL,
+				LinkOptions: models.LinkOptions{
+					Alias:     row.Alias,
+					Title:     row.Title,
+					Tags:      row.Tags,
+					ExpiresAt: row.ExpiresAt,
+				},
+			},
+			Err: row.Err,
+		}
+	}
+	return items
+}
+
+// Write writes the items in chunks; it stops early only if ctx is done.
+func (im Importer) Write(ctx context.Context, uid string, items []Item) error {
+	next := im.Chunks(uid, items)
+	for {
+		more, err := next(ctx)
+		if err != nil || !more {
+			return err
+		}
+	}
+}
+
+// Chunks returns function which writes the next chunk of the items on every call and reports whether items remain.
+// Calls must not overlap; it fails only if ctx is done.
+func (im Importer) Chunks(uid string, items []Item) func(ctx context.Context) (bool, error) {
+	aliases := make(map[string]string)
+	start := 0
+	return func(ctx context.Context) (bool, error) {
+		if start >= len(items) {
+			return false, nil
+		}
+		err := ctx.Err()
+		if err != nil {
+			return false, err
+		}
+
+		end := start + chunkSize
+		if end > len(items) {
+			end = len(items)
+		}
+		im.writeChunk(ctx, uid, items[start:end], aliases)
+		start = end
+		return start < len(items), nil
+	}
+}
+
+// writeChunk writes the items; aliases holds destinations of aliases given by earlier items.
+func (im Importer) writeChunk(ctx context.Context, uid string, items []Item, aliases map[string]string) {
+	results := make([]models.JobResult, len(items))
+	var batch []models.BatchOriginal
+	var batchResults []*models.JobResult
+
+	for i, item := range items {
+		res := &results[i]
+		res.Item = item.ID
+		res.OriginalURL = item.Link.OriginalURL
+
+		o, status, err := im.prepare(ctx, uid, item, aliases)
+		if err != nil {
+			res.Status, res.Error = StatusFailed, err.Error()
+			continue
+		}
+		res.Status = status
+		if status == StatusExists {
+			res.ShortURL = app.FullLink(o.Alias)
+			continue
+		}
+		batch = append(batch, o)
+		batchResults = append(batchResults, res)
+	}
+
+	if len(batch) > 0 {
+		shorts, err := im.Store.BatchWrite(ctx, uid, batch)
+		if err != nil {
+			im.writeEach(ctx, uid, batch, batchResults)
+		} else {
+			for i, s := range shorts {
+				batchResults[i].ShortURL = app.FullLink(s)
+				im.created(ctx, uid, s, batch[i].OriginalURL)
+			}
+		}
+	}
+
+	if im.Progress != nil {
+		im.Progress(results)
+	}
+}
+
+// prepare returns the link of the item to be written, resolving alias conflicts by the policy.
+// The alias of a link which already exists is set to its short code.
+func (im Importer) prepare(ctx context.Context, uid string, item Item, aliases map[string]string) (models.BatchOriginal, string, error) {
+	if item.Err != nil {
+		return models.BatchOriginal{}, "", item.Err
+	}
+	o := item.Link
+	u, err := url.Parse(o.OriginalURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return models.BatchOriginal{}, "", errInvalidURL
+	}
+
+	status := StatusCreated
+	if o.Alias != "" {
+		conflict := app.ValidateAlias(o.Alias)
+		if long, ok := aliases[o.Alias]; conflict == nil && ok {
+			if long == o.OriginalURL {
+				return o, StatusExists, nil
+			}
+			conflict = app.ErrAliasTaken
+		}
+		if conflict == nil {
+			l, err := im.Store.Lookup(ctx, o.Alias)
+			switch {
+			case err == nil && l.UUID == uid && l.Long == o.OriginalURL:
+				return o, StatusExists, nil
+			case err == nil:
+				conflict = app.ErrAliasTaken
+			case !errors.Is(err, app.ErrLinkNotFound):
+				return models.BatchOriginal{}, "", err
+			}
+		}
+		if conflict != nil {
+			if im.OnConflict != OnConflictRename {
+				return models.BatchOriginal{}, "", conflict
+			}
+			o.Alias, status = "", StatusRenamed
+		}
+	}
+	if o.Alias == "" {
+		s, err := im.Store.FindLink(ctx, o.OriginalURL)
+		if err == nil {
+			o.Alias = s
+			return o, StatusExists, nil
+		}
+		if !errors.Is(err, app.ErrLinkNotFound) {
+			return models.BatchOriginal{}, "", err
+		}
+	}
+
+	err = im.Validate(ctx, &o)
+	if err != nil {
+		return models.BatchOriginal{}, "", err
+	}
+	if o.Alias != "" {
+		aliases[o.Alias] = o.OriginalURL
+	}
+	return o, status, nil
+}
+
+// writeEach writes links one by one after a failed batch to find out outcomes of single rows.
+// The failed batch may have written some of the links already: prepare found no links of their addresses
+// or aliases, so a link of the user with the same destination is taken as created by the batch.
+func (im Importer) writeEach(ctx context.Context, uid string, batch []models.BatchOriginal, results []*models.JobResult) {
+	created := make(map[string]bool)
+	for i, o := range batch {
+		res := results[i]
+
+		s, err := im.Store.Write(ctx, uid, o.OriginalURL, o.LinkOptions)
+		switch {
+		case errors.Is(err, app.ErrAliasTaken):
+			if im.writtenByBatch(ctx, uid, o.Alias, o.OriginalURL) {
+				s, err = o.Alias, nil
+			} else if im.OnConflict == OnConflictRename {
+				o.Alias, res.Status = "", StatusRenamed
+				s, err = im.Store.Write(ctx, uid, o.OriginalURL, o.LinkOptions)
+			}
+		case errors.Is(err, app.ErrLinkAlreadyExists) && !created[s]:
+			// unless an earlier row has the same destination
+			if im.writtenByBatch(ctx, uid, s, o.OriginalURL) {
+				err = nil
+			}
+		}
+
+		switch {
+		case errors.Is(err, app.ErrLinkAlreadyExists):
+			res.Status, res.ShortURL = StatusExists, app.FullLink(s)
+		case err != nil:
+			res.Status, res.Error = StatusFailed, err.Error()
+		default:
+			created[s] = true
+			res.ShortURL = app.FullLink(s)
+			im.created(ctx, uid, s, o.OriginalURL)
+		}
+	}
+}
+
+// writtenByBatch reports whether the link of the short code is the user's link of the address.
+func (im Importer) writtenByBatch(ctx context.Context, uid, short, long string) bool {
+	l, err := im.Store.Lookup(ctx, short)
+	return err == nil && l.UUID == uid && l.Long == long
+}
+
+func (im Importer) created(ctx context.Context, uid, short, long string) {
+	if im.Created != nil {
+		im.Created(ctx, uid, short, long)
+	}
+}
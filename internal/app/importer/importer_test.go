@@ -0,0 +1,255 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/config"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+	"github.com/DrGermanius/Shortener/internal/store/memory"
+)
+
+func TestParseCSV(t *testing.T) {
+	rows, err := Parse(strings.NewReader("\ufeffLong URL,Back-Half,Name,Labels,Expires At\n"+
+		"https://go.dev,https://bit.ly/go-dev,Go,go;lang,2030-01-02\n"+
+		"https://golang.org,,,,tomorrow\n"), FormatCSV)
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+
+	expires := time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, Row{Line: 2, URL: "https://go.dev", Alias: "go-dev", Title: "Go", Tags: []string{"go", "lang"},
+		ExpiresAt: &expires}, rows[0])
+	require.Equal(t, 3, rows[1].Line)
+	require.EqualError(t, rows[1].Err, `invalid expiry "tomorrow"`)
+
+	// a file without a header lists the original, the alias, tags and the expiry
+	rows, err = Parse(strings.NewReader("https://go.dev, go-dev, a|b, 1893456000\n"), FormatCSV)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, "go-dev", rows[0].Alias)
+	require.Equal(t, []string{"a", "b"}, rows[0].Tags)
+	require.Equal(t, int64(1893456000), rows[0].ExpiresAt.Unix())
+}
+
+func TestParseJSON(t *testing.T) {
+	rows, err := Parse(strings.NewReader(`[{"destination": "https://go.dev", "slug": "go", "tags": ["a", 1, "b"]},
+		{"url": "https://golang.org", "expires_at": "2030-01-02T03:04:05Z", "unknown": true}]`), FormatJSON)
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	require.Equal(t, "go", rows[0].Alias)
+	require.Equal(t, []string{"a", "b"}, rows[0].Tags)
+	require.Equal(t, 2, rows[1].Line)
+	require.Equal(t, "https://golang.org", rows[1].URL)
+	require.NotNil(t, rows[1].ExpiresAt)
+
+	rows, err = Parse(strings.NewReader("{\"url\": \"https://go.dev\"}\n\n{broken\n{\"long\": \"https://golang.org\"}\n"), FormatNDJSON)
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	require.Equal(t, 3, rows[1].Line)
+	require.Error(t, rows[1].Err)
+	require.Equal(t, 4, rows[2].Line)
+	require.Equal(t, "https://golang.org", rows[2].URL)
+
+	_, err = Parse(strings.NewReader("{}"), FormatJSON)
+	require.Error(t, err)
+	_, err = Parse(strings.NewReader(""), "xml")
+	require.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestParseTooManyRows(t *testing.T) {
+	csv := strings.Repeat("https://go.dev\n", MaxRows+1)
+	_, err := Parse(strings.NewReader(csv), FormatCSV)
+	require.ErrorIs(t, err, ErrTooManyRows)
+
+	_, err = Parse(strings.NewReader(strings.Repeat("{\"url\": \"https://go.dev\"}\n", MaxRows+1)), FormatNDJSON)
+	require.ErrorIs(t, err, ErrTooManyRows)
+
+	rows, err := Parse(strings.NewReader(strings.Repeat("https://go.dev\n", MaxRows)), FormatCSV)
+	require.NoError(t, err)
+	require.Len(t, rows, MaxRows)
+}
+
+// memStore keeps links by their short codes.
+type memStore struct {
+	links   map[string]models.LinkInfo
+	next    int
+	batches int
+}
+
+func newMemStore() *memStore {
+	return &memStore{links: make(map[string]models.LinkInfo)}
+}
+
+func (s *memStore) Lookup(_ context.Context, short string) (models.LinkInfo, error) {
+	l, ok := s.links[short]
+	if !ok {
+		return models.LinkInfo{}, app.ErrLinkNotFound
+	}
+	return l, nil
+}
+
+func (s *memStore) FindLink(_ context.Context, long string) (string, error) {
+	for short, l := range s.links {
+		if l.Long == long {
+			return short, nil
+		}
+	}
+	return "", app.ErrLinkNotFound
+}
+
+func (s *memStore) Write(_ context.Context, uid, long string, opts models.LinkOptions) (string, error) {
+	short := opts.Alias
+	if short == "" {
+		s.next++
+		short = fmt.Sprintf("gen%05d", s.next)
+	}
+	if _, ok := s.links[short]; ok {
+		return "", app.ErrAliasTaken
+	}
+	s.links[short] = models.LinkInfo{UUID: uid, Long: long}
+	return short, nil
+}
+
+func (s *memStore) BatchWrite(ctx context.Context, uid string, originals []models.BatchOriginal) ([]string, error) {
+	s.batches++
+	shorts := make([]string, len(originals))
+	for i, o := range originals {
+		short, err := s.Write(ctx, uid, o.OriginalURL, o.LinkOptions)
+		if err != nil {
+			return nil, err
+		}
+		shorts[i] = short
+	}
+	return shorts, nil
+}
+
+func newTestImporter(store Store, onConflict string) (*Importer, *[]models.JobResult, *[]string) {
+	var results []models.JobResult
+	var created []string
+	return &Importer{
+		Store:      store,
+		OnConflict: onConflict,
+		Validate: func(_ context.Context, o *models.BatchOriginal) error {
+			if o.Alias != "" {
+				return app.ValidateAlias(o.Alias)
+			}
+			return nil
+		},
+		Progress: func(r []models.JobResult) {
+			results = append(results, r...)
+		},
+		Created: func(_ context.Context, _, short, _ string) {
+			created = append(created, short)
+		},
+	}, &results, &created
+}
+
+func statuses(results []models.JobResult) []string {
+	var res []string
+	for _, r := range results {
+		res = append(res, r.Status)
+	}
+	return res
+}
+
+func TestRun(t *testing.T) {
+	config.SetTestConfig()
+	store := newMemStore()
+	store.links["taken"] = models.LinkInfo{UUID: "other", Long: "https://other.example"}
+	store.links["mine"] = models.LinkInfo{UUID: "user", Long: "https://mine.example"}
+	store.links["existing"] = models.LinkInfo{UUID: "user", Long: "https://existing.example"}
+
+	rows := []Row{
+		{Line: 1, URL: "https://go.dev", Alias: "go-dev"},
+		{Line: 2, URL: "https://taken.example", Alias: "taken"},
+		{Line: 3, URL: "https://mine.example", Alias: "mine"},
+		{Line: 4, URL: "https://existing.example"},
+		{Line: 5, URL: "ftp://files.example"},
+		{Line: 6, URL: "https://go.dev/again", Alias: "go-dev"},
+		{Line: 7, URL: "https://go.dev", Alias: "go-dev"},
+		{Line: 8, Err: errors.New("broken row")},
+		{Line: 9, URL: "https://invalid.example", Alias: "api"},
+	}
+
+	im, results, created := newTestImporter(store, OnConflictSkip)
+	require.NoError(t, im.Run(context.Background(), "user", rows))
+	require.Equal(t, []string{StatusCreated, StatusFailed, StatusExists, StatusExists, StatusFailed, StatusFailed,
+		StatusExists, StatusFailed, StatusFailed}, statuses(*results))
+	require.Equal(t, app.ErrAliasTaken.Error(), (*results)[1].Error)
+	require.Equal(t, app.FullLink("mine"), (*results)[2].ShortURL)
+	require.Equal(t, app.FullLink("existing"), (*results)[3].ShortURL)
+	require.Equal(t, errInvalidURL.Error(), (*results)[4].Error)
+	require.Equal(t, app.ErrAliasTaken.Error(), (*results)[5].Error)
+	require.Equal(t, app.FullLink("go-dev"), (*results)[6].ShortURL)
+	require.Equal(t, "broken row", (*results)[7].Error)
+	require.Equal(t, app.ErrInvalidAlias.Error(), (*results)[8].Error)
+	require.Equal(t, []string{"go-dev"}, *created)
+
+	// the conflicting aliases are replaced with generated short codes
+	store = newMemStore()
+	store.links["taken"] = models.LinkInfo{UUID: "other", Long: "https://other.example"}
+	im, results, created = newTestImporter(store, OnConflictRename)
+	require.NoError(t, im.Run(context.Background(), "user", rows[:2]))
+	require.Equal(t, []string{StatusCreated, StatusRenamed}, statuses(*results))
+	require.Equal(t, app.FullLink("gen00001"), (*results)[1].ShortURL)
+	require.Equal(t, []string{"go-dev", "gen00001"}, *created)
+}
+
+func TestWriteInChunks(t *testing.T) {
+	config.SetTestConfig()
+	store := newMemStore()
+	im, results, created := newTestImporter(store, OnConflictSkip)
+
+	items := make([]Item, 2*chunkSize+1)
+	for i := range items {
+		items[i] = Item{ID: fmt.Sprint(i), Link: models.BatchOriginal{OriginalURL: fmt.Sprintf("https://go.dev/%d", i)}}
+	}
+	next := im.Chunks("user", items)
+	for i := 1; i <= 3; i++ {
+		more, err := next(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, i < 3, more)
+		require.Equal(t, i, store.batches)
+	}
+	more, err := next(context.Background())
+	require.NoError(t, err)
+	require.False(t, more)
+	require.Len(t, *results, len(items))
+	require.Len(t, *created, len(items))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = im.Write(ctx, "user", items)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 3, store.batches)
+}
+
+func TestWriteEachAfterFailedBatch(t *testing.T) {
+	t.Setenv("FILE_STORAGE_PATH", filepath.Join(t.TempDir(), "links"))
+	config.SetTestConfig()
+	store, err := memory.NewLinkMemoryStore()
+	require.NoError(t, err)
+	im, results, created := newTestImporter(store, OnConflictSkip)
+
+	// the batch writes the first two links and fails on the third one, whose destination is already written
+	require.NoError(t, im.Write(context.Background(), "user", []Item{
+		{ID: "a", Link: models.BatchOriginal{OriginalURL: "https://go.dev", LinkOptions: models.LinkOptions{Alias: "go-dev"}}},
+		{ID: "b", Link: models.BatchOriginal{OriginalURL: "https://golang.org"}},
+		{ID: "c", Link: models.BatchOriginal{OriginalURL: "https://go.dev"}},
+	}))
+	golang := app.ShortLink([]byte("https://golang.org"))
+	require.Equal(t, []string{StatusCreated, StatusCreated, StatusExists}, statuses(*results))
+	require.Equal(t, app.FullLink("go-dev"), (*results)[0].ShortURL)
+	require.Equal(t, app.FullLink(golang), (*results)[1].ShortURL)
+	require.Equal(t, app.FullLink("go-dev"), (*results)[2].ShortURL)
+	require.Equal(t, []string{"go-dev", golang}, *created)
+
+}
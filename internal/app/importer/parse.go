@@ -0,0 +1,255 @@
+// Package importer moves links exported from other shorteners into the service.
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of import files.
+const (
+	FormatCSV    = "csv"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+)
+
+// MaxRows limits the number of links in an import file.
+const MaxRows = 50000
+
+var (
+	ErrUnknownFormat = errors.New("import format must be one of csv, json, ndjson")
+	ErrTooManyRows   = fmt.Errorf("import file can't contain more than %d links", MaxRows)
+)
+
+// Row is a link read from an import file.
+type Row struct {
+	Line      int
+	URL       string
+	Alias     string
+	Title     string
+	Tags      []string
+	ExpiresAt *time.Time
+	Err       error // the row couldn't be read
+}
+
+// Fields of a row.
+const (
+	fieldURL    = "url"
+	fieldAlias  = "alias"
+	fieldTitle  = "title"
+	fieldTags   = "tags"
+	fieldExpiry = "expiry"
+)
+
+// columns maps column names used by the service and other shorteners to row fields.
+var columns = map[string]string{
+	"original_url": fieldURL, "original": fieldURL, "long_url": fieldURL, "url": fieldURL,
+	"destination": fieldURL, "destination_url": fieldURL, "target": fieldURL, "long": fieldURL,
+
+	"alias": fieldAlias, "short_code": fieldAlias, "code": fieldAlias, "slug": fieldAlias, "keyword": fieldAlias,
+	"custom_alias": fieldAlias, "back_half": fieldAlias, "short_url": fieldAlias, "short_link": fieldAlias,
+	"shorturl": fieldAlias, "link": fieldAlias,
+
+	"title": fieldTitle, "name": fieldTitle,
+
+	"tags": fieldTags, "tag": fieldTags, "labels": fieldTags,
+
+	"expires_at": fieldExpiry, "expiry": fieldExpiry, "expires": fieldExpiry, "expiration": fieldExpiry,
+	"expire_at": fieldExpiry, "valid_until": fieldExpiry,
+}
+
+// positional are fields of a csv file without a header.
+var positional = []string{fieldURL, fieldAlias, fieldTags, fieldExpiry}
+
+// Parse reads links of the import file.
+// CSV files either have a header with known column names or the columns original, alias, tags, expiry.
+// JSON files hold an array of objects, NDJSON files an object per line; objects use the same names as CSV headers.
+func Parse(r io.Reader, format string) ([]Row, error) {
+	switch format {
+	case FormatCSV:
+		return parseCSV(r)
+	case FormatJSON:
+		return parseJSON(r)
+	case FormatNDJSON:
+		return parseNDJSON(r)
+	}
+	return nil, ErrUnknownFormat
+}
+
+func parseCSV(r io.Reader) ([]Row, error) {
+	cr := csv.NewReader(bufio.NewReader(r))
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var rows []Row
+	var header []string
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if line == 1 {
+			header = csvHeader(rec)
+			if header != nil {
+				continue
+			}
+			header = positional
+		}
+		if len(rows) == MaxRows {
+			return nil, ErrTooManyRows
+		}
+
+		values := make(map[string]string, len(header))
+		for i, v := range rec {
+			if i < len(header) && header[i] != "" && values[header[i]] == "" {
+				values[header[i]] = v
+			}
+		}
+		rows = append(rows, newRow(line, values))
+	}
+}
+
+// csvHeader returns fields of the header columns, or nil if the record isn't a header.
+func csvHeader(rec []string) []string {
+	header := make([]string, len(rec))
+	found := false
+	for i, c := range rec {
+		header[i] = columns[columnName(c)]
+		found = found || header[i] == fieldURL
+	}
+	if !found {
+		return nil
+	}
+	return header
+}
+
+func parseJSON(r io.Reader) ([]Row, error) {
+	var objects []map[string]interface{}
+	err := json.NewDecoder(r).Decode(&objects)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) > MaxRows {
+		return nil, ErrTooManyRows
+	}
+
+	rows := make([]Row, 0, len(objects))
+	for i, o := range objects {
+		rows = append(rows, objectRow(i+1, o))
+	}
+	return rows, nil
+}
+
+func parseNDJSON(r io.Reader) ([]Row, error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []Row
+	for line := 1; s.Scan(); line++ {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+		if len(rows) == MaxRows {
+			return nil, ErrTooManyRows
+		}
+
+		var o map[string]interface{}
+		err := json.Unmarshal(s.Bytes(), &o)
+		if err != nil {
+			rows = append(rows, Row{Line: line, Err: err})
+			continue
+		}
+		rows = append(rows, objectRow(line, o))
+	}
+	return rows, s.Err()
+}
+
+func objectRow(line int, o map[string]interface{}) Row {
+	values := make(map[string]string)
+	for k, v := range o {
+		f := columns[columnName(k)]
+		if f == "" || values[f] != "" {
+			continue
+		}
+		switch v := v.(type) {
+		case string:
+			values[f] = v
+		case float64:
+			values[f] = strconv.FormatFloat(v, 'f', -1, 64)
+		case []interface{}:
+			tags := make([]string, 0, len(v))
+			for _, t := range v {
+				if s, ok := t.(string); ok {
+					tags = append(tags, s)
+				}
+			}
+			values[f] = strings.Join(tags, ";")
+		}
+	}
+	return newRow(line, values)
+}
+
+func newRow(line int, values map[string]string) Row {
+	row := Row{
+		Line:  line,
+		URL:   strings.TrimSpace(values[fieldURL]),
+		Alias: alias(strings.TrimSpace(values[fieldAlias])),
+		Title: strings.TrimSpace(values[fieldTitle]),
+		Tags: strings.FieldsFunc(values[fieldTags], func(r rune) bool {
+			return r == ';' || r == ',' || r == '|'
+		}),
+	}
+
+	if e := strings.TrimSpace(values[fieldExpiry]); e != "" {
+		t, err := parseTime(e)
+		if err != nil {
+			row.Err = fmt.Errorf("invalid expiry %q", e)
+		}
+		row.ExpiresAt = t
+	}
+	return row
+}
+
+// alias returns the short code of a short link exported as a full address.
+func alias(v string) string {
+	u, err := url.Parse(v)
+	if err != nil || u.Host == "" {
+		return v
+	}
+	p := strings.Trim(u.Path, "/")
+	return p[strings.LastIndex(p, "/")+1:]
+}
+
+var timeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+
+func parseTime(v string) (*time.Time, error) {
+	for _, l := range timeLayouts {
+		t, err := time.Parse(l, v)
+		if err == nil {
+			return &t, nil
+		}
+	}
+	sec, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	t := time.Unix(sec, 0)
+	return &t, nil
+}
+
+func columnName(c string) string {
+	c = strings.TrimPrefix(c, "\ufeff")
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(strings.ToLower(strings.TrimSpace(c)))
+}
@@ -0,0 +1,216 @@
+// Package jobs keeps track of long running operations started by user requests.
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+const (
+	// retention is how long finished jobs stay available.
+	retention = 24 * time.Hour
+	// saveInterval limits how often progress of a running job is saved.
+	saveInterval = time.Second
+	// pruneInterval is how often finished jobs older than retention are deleted from the store.
+	pruneInterval = time.Hour
+)
+
+// Store keeps jobs across restarts of the service.
+type Store interface {
+	SaveJob(ctx context.Context, job models.Job) error
+	GetJob(ctx context.Context, id string) (models.Job, error)
+	DeleteJobs(ctx context.Context, finishedBefore time.Time) (int64, error)
+}
+
+// Registry holds unfinished jobs in memory and saves their state to the store.
+type Registry struct {
+	mu     sync.Mutex
+	jobs   map[string]*entry
+	store  Store
+	logger *zap.SugaredLogger
+}
+
+type entry struct {
+	job     models.Job
+	savedAt time.Time
+	done    chan struct{}
+}
+
+// NewRegistry creates registry which deletes finished jobs from the store after retention until ctx is done.
+func NewRegistry(ctx context.Context, store Store, logger *zap.SugaredLogger) *Registry {
+	r := &Registry{
+		jobs:   make(map[string]*entry),
+		store:  store,
+		logger: logger,
+	}
+	go r.prune(ctx)
+	return r
+}
+
+// Create registers a queued job of the user and returns its copy.
+func (r *Registry) Create(ctx context.Context, uid, kind string, total int) (models.Job, error) {
+	j := models.Job{
+		ID:        uuid.NewString(),
+		UID:       uid,
+		Kind:      kind,
+		Status:    models.JobQueued,
+		Total:     total,
+		CreatedAt: time.Now(),
+	}
+	err := r.store.SaveJob(ctx, j)
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.jobs[j.ID] = &entry{job: j, savedAt: time.Now(), done: make(chan struct{})}
+	return j, nil
+}
+
+// Get returns the job of the user or ErrJobNotFound.
+func (r *Registry) Get(ctx context.Context, uid, id string) (models.Job, error) {
+	r.mu.Lock()
+	e, ok := r.jobs[id]
+	var j models.Job
+	if ok {
+		j = copyJob(e.job)
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		var err error
+		j, err = r.store.GetJob(ctx, id)
+		if err != nil {
+			return models.Job{}, err
+		}
+	}
+
+	if j.UID != uid || (j.FinishedAt != nil && time.Since(*j.FinishedAt) > retention) {
+		return models.Job{}, app.ErrJobNotFound
+	}
+	return j, nil
+}
+
+// Wait blocks until the job finishes or ctx is done; it returns at once if the job isn't running here.
+func (r *Registry) Wait(ctx context.Context, id string) {
+	r.mu.Lock()
+	e, ok := r.jobs[id]
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+	}
+}
+
+// Start marks the job as running.
+func (r *Registry) Start(id string) {
+	r.update(id, true, func(j *models.Job) {
+		j.Status = models.JobRunning
+	})
+}
+
+// Progress records results of processed items.
+func (r *Registry) Progress(id string, results ...models.JobResult) {
+	r.update(id, false, func(j *models.Job) {
+		j.Processed += len(results)
+		for _, res := range results {
+			if res.Error != "" {
+				j.Failed++
+			}
+		}
+		j.Results = append(j.Results, results...)
+	})
+}
+
+// Finish marks the job as done, or as failed if err isn't nil.
+func (r *Registry) Finish(id string, err error) {
+	r.update(id, true, func(j *models.Job) {
+		now := time.Now()
+		j.FinishedAt = &now
+		j.Status = models.JobDone
+		if err != nil {
+			j.Status = models.JobFailed
+			j.Error = err.Error()
+		}
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.jobs[id]; ok {
+		close(e.done)
+		delete(r.jobs, id)
+	}
+}
+
+// prune deletes expired jobs from the store at start and then every pruneInterval.
+func (r *Registry) prune(ctx context.Context) {
+	t := time.NewTicker(pruneInterval)
+	defer t.Stop()
+
+	for {
+		n, err := r.store.DeleteJobs(ctx, time.Now().Add(-retention))
+		if err != nil {
+			r.logger.Errorf("can't delete expired jobs: %v", err)
+		} else if n > 0 {
+			r.logger.Infof("%d expired jobs were deleted", n)
+		}
+
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// update changes the job and saves it if force is set or the last save is old enough.
+// Results are saved once the job finishes, progress of a running job saves its counters only.
+// Changes of a job come from a single goroutine, so saves of the job never reorder.
+func (r *Registry) update(id string, force bool, f func(*models.Job)) {
+	r.mu.Lock()
+	e, ok := r.jobs[id]
+	if !ok {
+		r.mu.Unlock()
+		return
+	}
+	f(&e.job)
+	save := force || time.Since(e.savedAt) >= saveInterval
+	var j models.Job
+	if save {
+		e.savedAt = time.Now()
+		j = e.job
+		j.Results = nil
+		if j.Finished() {
+			j = copyJob(e.job)
+		}
+	}
+	r.mu.Unlock()
+
+	if !save {
+		return
+	}
+	// the state is saved even if the job was stopped by shutdown
+	err := r.store.SaveJob(context.Background(), j)
+	if err != nil {
+		r.logger.Errorf("can't save job %s: %v", id, err)
+	}
+}
+
+func copyJob(j models.Job) models.Job {
+	j.Results = append([]models.JobResult(nil), j.Results...)
+	return j
+}
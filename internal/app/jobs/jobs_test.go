@@ -0,0 +1,142 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// memStore keeps every saved state of the jobs.
+type memStore struct {
+	mu    sync.Mutex
+	saves []models.Job
+	jobs  map[string]models.Job
+}
+
+func newMemStore() *memStore {
+	return &memStore{jobs: make(map[string]models.Job)}
+}
+
+func (s *memStore) SaveJob(_ context.Context, job models.Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.saves = append(s.saves, job)
+	s.jobs[job.ID] = job
+	return nil
+}
+
+func (s *memStore) GetJob(_ context.Context, id string) (models.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return models.Job{}, app.ErrJobNotFound
+	}
+	return j, nil
+}
+
+func (s *memStore) DeleteJobs(_ context.Context, finishedBefore time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int64
+	for id, j := range s.jobs {
+		if j.FinishedAt != nil && j.FinishedAt.Before(finishedBefore) {
+			delete(s.jobs, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func newTestRegistry(t *testing.T, store Store) *Registry {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return NewRegistry(ctx, store, zap.NewNop().Sugar())
+}
+
+func TestRegistrySavesResultsWhenFinished(t *testing.T) {
+	store := newMemStore()
+	r := newTestRegistry(t, store)
+
+	j, err := r.Create(context.Background(), "user", "batch", 2)
+	require.NoError(t, err)
+	r.Start(j.ID)
+	r.Progress(j.ID, models.JobResult{Item: "a", Status: "created"})
+	r.Progress(j.ID, models.JobResult{Item: "b", Status: "failed", Error: "invalid"})
+
+	running, err := r.Get(context.Background(), "user", j.ID)
+	require.NoError(t, err)
+	require.Len(t, running.Results, 2)
+	require.Equal(t, 1, running.Failed)
+
+	r.Finish(j.ID, nil)
+
+	store.mu.Lock()
+	saves := append([]models.Job(nil), store.saves...)
+	store.mu.Unlock()
+	for _, s := range saves[:len(saves)-1] {
+		require.False(t, s.Finished())
+		require.Empty(t, s.Results)
+	}
+	last := saves[len(saves)-1]
+	require.Equal(t, models.JobDone, last.Status)
+	require.Equal(t, 2, last.Processed)
+	require.Len(t, last.Results, 2)
+
+	done, err := r.Get(context.Background(), "user", j.ID)
+	require.NoError(t, err)
+	require.Equal(t, last, done)
+
+	_, err = r.Get(context.Background(), "other", j.ID)
+	require.ErrorIs(t, err, app.ErrJobNotFound)
+}
+
+func TestRegistryWait(t *testing.T) {
+	r := newTestRegistry(t, newMemStore())
+
+	j, err := r.Create(context.Background(), "user", "delete", 1)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	r.Wait(ctx, j.ID)
+	require.Error(t, ctx.Err())
+
+	go r.Finish(j.ID, context.Canceled)
+	r.Wait(context.Background(), j.ID)
+
+	failed, err := r.Get(context.Background(), "user", j.ID)
+	require.NoError(t, err)
+	require.Equal(t, models.JobFailed, failed.Status)
+	require.Equal(t, context.Canceled.Error(), failed.Error)
+}
+
+func TestRegistryPrunesExpiredJobs(t *testing.T) {
+	store := newMemStore()
+	expired := time.Now().Add(-retention - time.Minute)
+	recent := time.Now()
+	store.jobs["expired"] = models.Job{ID: "expired", UID: "user", Status: models.JobDone, FinishedAt: &expired}
+	store.jobs["recent"] = models.Job{ID: "recent", UID: "user", Status: models.JobDone, FinishedAt: &recent}
+	store.jobs["running"] = models.Job{ID: "running", UID: "user", Status: models.JobRunning}
+
+	r := newTestRegistry(t, store)
+
+	require.Eventually(t, func() bool {
+		_, err := store.GetJob(context.Background(), "expired")
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+	for _, id := range []string{"recent", "running"} {
+		_, err := r.Get(context.Background(), "user", id)
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"sync"
+	"time"
+)
+
+const limiterPruneSize = 1024
+
+// AttemptLimiter counts failed attempts per key within a fixed time window.
+type AttemptLimiter struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	attempts map[string]attempt
+}
+
+type attempt struct {
+	count int
+	start time.Time
+}
+
+func NewAttemptLimiter(max int, window time.Duration) *AttemptLimiter {
+	return &AttemptLimiter{
+		max:      max,
+		window:   window,
+		attempts: make(map[string]attempt),
+	}
+}
+
+// Allow reports whether one more attempt is allowed for the key.
+func (l *AttemptLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.attempts[key]
+	if !ok || time.Since(a.start) >= l.window {
+		return true
+	}
+	return a.count < l.max
+}
+
+// Fail registers a failed attempt for the key.
+func (l *AttemptLimiter) Fail(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if len(l.attempts) > limiterPruneSize {
+		for k, v := range l.attempts {
+			if now.Sub(v.start) >= l.window {
+				delete(l.attempts, k)
+			}
+		}
+	}
+
+	a, ok := l.attempts[key]
+	if !ok || now.Sub(a.start) >= l.window {
+		a = attempt{start: now}
+	}
+	a.count++
+	l.attempts[key] = a
+}
+
+// Reset forgets failed attempts for the key.
+func (l *AttemptLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attempts, key)
+}
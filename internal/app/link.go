@@ -3,8 +3,13 @@ package app
 import (
 	"crypto/sha256"
 	"encoding/base64"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/DrGermanius/Shortener/internal/app/config"
+	"github.com/DrGermanius/Shortener/internal/app/models"
 )
 
 func ShortLink(l []byte) string {
@@ -15,6 +20,68 @@ func ShortLink(l []byte) string {
 	return s
 }
 
+// shortLinkLength is the length of short representations of addresses: 6 bytes of a hash in base64.
+const shortLinkLength = 8
+
+// MaxShortLinkProbes bounds the number of short representations tried for an address.
+const MaxShortLinkProbes = 8
+
+// AltShortLink returns the n-th short representation of the address, the 0-th is ShortLink.
+// Further ones are used when the short representation belongs to a link whose destination has been edited.
+func AltShortLink(long string, n int) string {
+	if n == 0 {
+		return ShortLink([]byte(long))
+	}
+	return ShortLink([]byte(long + "#" + strconv.Itoa(n)))
+}
+
+// ShortCode returns the custom alias of the link if set, otherwise the short representation of the address.
+func ShortCode(long string, opts models.LinkOptions) string {
+	if opts.Alias != "" {
+		return opts.Alias
+	}
+	return ShortLink([]byte(long))
+}
+
+// ValidateAlias checks that the alias can be used as a short code.
+// Aliases never have the length of short representations, so they can't take the code of another address.
+func ValidateAlias(alias string) error {
+	if !aliasRe.MatchString(alias) || len(alias) == shortLinkLength || reservedAliases[strings.ToLower(alias)] {
+		return ErrInvalidAlias
+	}
+	return nil
+}
+
+var aliasRe = regexp.MustCompile(`^[A-Za-z0-9_-]{3,64}$`)
+
+// reservedAliases are first path segments of service routes.
+var reservedAliases = map[string]bool{"api": true, "ping": true, "debug": true}
+
 func FullLink(s string) string {
 	return config.Config().BaseURL + "/" + s
 }
+
+// CheckLink reports why the link can't be followed at the moment, or nil if it can.
+func CheckLink(l models.LinkInfo, now time.Time) error {
+	if l.IsDeleted {
+		return ErrDeletedLink
+	}
+	if IsExpired(l, now) {
+		return ErrExpiredLink
+	}
+	if l.MaxClicks > 0 && l.Clicks >= l.MaxClicks {
+		return ErrExhaustedLink
+	}
+	if !l.ActiveFrom.IsZero() && now.Before(l.ActiveFrom) {
+		return ErrLinkNotActive
+	}
+	if !l.ActiveUntil.IsZero() && !now.Before(l.ActiveUntil) {
+		return ErrLinkInactive
+	}
+	return nil
+}
+
+// IsExpired reports whether the link has an expiry that has already passed.
+func IsExpired(l models.LinkInfo, now time.Time) bool {
+	return !l.ExpiresAt.IsZero() && !now.Before(l.ExpiresAt)
+}
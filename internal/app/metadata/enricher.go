@@ -0,0 +1,88 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/config"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+const (
+	attempts    = 4
+	baseBackoff = 30 * time.Second
+)
+
+// Store is the part of the links storage the enricher works with.
+type Store interface {
+	SetMetadata(ctx context.Context, short, long string, m models.LinkMetadata) error
+}
+
+// Enricher fetches metadata of new destinations in the worker pool and saves it on the links.
+type Enricher struct {
+	context context.Context
+	fetcher *Fetcher
+	store   Store
+	pool    app.WorkerPool
+	logger  *zap.SugaredLogger
+	backoff time.Duration
+}
+
+// NewEnricher creates enricher configured by METADATA_* options; ctx stops pending retries.
+func NewEnricher(ctx context.Context, store Store, pool app.WorkerPool, logger *zap.SugaredLogger) *Enricher {
+	timeout, err := time.ParseDuration(config.Config().MetadataTimeout)
+	if err != nil || timeout <= 0 {
+		timeout = 10 * time.Second
+		logger.Errorf("error while reading config metadata fetch timeout %q, using %v", config.Config().MetadataTimeout, timeout)
+	}
+	maxBytes, err := strconv.ParseInt(config.Config().MetadataMaxBytes, 10, 64)
+	if err != nil || maxBytes <= 0 {
+		maxBytes = 1 << 20
+		logger.Errorf("error while reading config metadata max bytes %q, using %d", config.Config().MetadataMaxBytes, maxBytes)
+	}
+
+	return &Enricher{
+		context: ctx,
+		fetcher: NewFetcher(timeout, maxBytes),
+		store:   store,
+		pool:    pool,
+		logger:  logger,
+		backoff: baseBackoff,
+	}
+}
+
+// Enrich schedules fetching metadata of the link destination; it never blocks the caller.
+// Temporary failures are retried with exponential backoff.
+func (e *Enricher) Enrich(short, long string) {
+	e.schedule(short, long, 1)
+}
+
+func (e *Enricher) schedule(short, long string, attempt int) {
+	err := e.pool.Submit(e.context, func(ctx context.Context) error {
+		m, err := e.fetcher.Fetch(ctx, long)
+		if err != nil {
+			var tmp *TemporaryError
+			if !errors.As(err, &tmp) || attempt >= attempts {
+				e.logger.Infof("metadata of %s wasn't fetched: %v", short, err)
+				return nil
+			}
+
+			time.AfterFunc(e.backoff<<(attempt-1), func() {
+				if e.context.Err() == nil {
+					e.schedule(short, long, attempt+1)
+				}
+			})
+			return nil
+		}
+
+		return e.store.SetMetadata(ctx, short, long, m)
+	})
+	if err != nil && e.context.Err() == nil {
+		e.logger.Infof("metadata of %s won't be fetched: %v", short, err)
+	}
+}
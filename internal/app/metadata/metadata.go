@@ -0,0 +1,184 @@
+// Package metadata extracts titles, descriptions and Open Graph tags of destination pages.
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// maxFieldLength limits length of every extracted value.
+const maxFieldLength = 500
+
+// ErrNotHTML means the destination isn't an html page, so there's nothing to extract.
+var ErrNotHTML = errors.New("destination is not an html page")
+
+// TemporaryError is a fetch failure which may go away on retry.
+type TemporaryError struct {
+	Err error
+}
+
+func (e *TemporaryError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TemporaryError) Unwrap() error {
+	return e.Err
+}
+
+// Fetcher downloads destination pages.
+type Fetcher struct {
+	client   *http.Client
+	maxBytes int64
+}
+
+// NewFetcher creates fetcher reading at most maxBytes of a page within timeout.
+func NewFetcher(timeout time.Duration, maxBytes int64) *Fetcher {
+	return &Fetcher{
+		client:   app.NewOutboundClient(timeout),
+		maxBytes: maxBytes,
+	}
+}
+
+// Fetch downloads the page and extracts its metadata.
+// Network failures, 429 and 5xx responses are reported as *TemporaryError.
+func (f *Fetcher) Fetch(ctx context.Context, long string) (models.LinkMetadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, long, nil)
+	if err != nil {
+		return models.LinkMetadata{}, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		if errors.Is(err, app.ErrPrivateAddress) {
+			return models.LinkMetadata{}, err
+		}
+		return models.LinkMetadata{}, &TemporaryError{Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		return models.LinkMetadata{}, &TemporaryError{Err: fmt.Errorf("destination responded %s", resp.Status)}
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return models.LinkMetadata{}, fmt.Errorf("destination responded %s", resp.Status)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || (mediaType != "text/html" && mediaType != "application/xhtml+xml") {
+		return models.LinkMetadata{}, ErrNotHTML
+	}
+
+	body, err := charset.NewReader(io.LimitReader(resp.Body, f.maxBytes), contentType)
+	if err != nil {
+		return models.LinkMetadata{}, err
+	}
+
+	m := Parse(body, resp.Request.URL)
+	m.FetchedAt = time.Now()
+	return m, nil
+}
+
+// Parse extracts metadata from the html head; relative image addresses are resolved against base.
+// Open Graph values take precedence over the <title> and description meta tags.
+func Parse(r io.Reader, base *url.URL) models.LinkMetadata {
+	var m models.LinkMetadata
+	var title, description string
+
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return finish(m, title, description, base)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			switch t.Data {
+			case "title":
+				if title == "" && z.Next() == html.TextToken {
+					title = string(z.Text())
+				}
+			case "meta":
+				name, content := metaTag(t)
+				switch name {
+				case "og:title":
+					m.Title = content
+				case "og:description":
+					m.Description = content
+				case "og:image":
+					m.Image = content
+				case "og:site_name":
+					m.SiteName = content
+				case "og:type":
+					m.Type = content
+				case "description":
+					description = content
+				}
+			case "body":
+				return finish(m, title, description, base)
+			}
+		}
+	}
+}
+
+// metaTag returns lower-cased property or name of the meta tag and its content.
+func metaTag(t html.Token) (string, string) {
+	var name, content string
+	for _, a := range t.Attr {
+		switch a.Key {
+		case "property", "name":
+			if name == "" {
+				name = strings.ToLower(a.Val)
+			}
+		case "content":
+			content = a.Val
+		}
+	}
+	return name, content
+}
+
+func finish(m models.LinkMetadata, title, description string, base *url.URL) models.LinkMetadata {
+	if m.Title == "" {
+		m.Title = title
+	}
+	if m.Description == "" {
+		m.Description = description
+	}
+	if m.Image != "" && base != nil {
+		img, err := base.Parse(m.Image)
+		if err == nil && (img.Scheme == "http" || img.Scheme == "https") {
+			m.Image = img.String()
+		} else {
+			m.Image = ""
+		}
+	}
+
+	m.Title = clean(m.Title)
+	m.Description = clean(m.Description)
+	m.Image = clean(m.Image)
+	m.SiteName = clean(m.SiteName)
+	m.Type = clean(m.Type)
+	return m
+}
+
+// clean collapses whitespace and truncates the value to maxFieldLength runes.
+func clean(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > maxFieldLength {
+		s = string(r[:maxFieldLength])
+	}
+	return s
+}
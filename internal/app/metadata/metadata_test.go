@@ -0,0 +1,198 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/config"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+const page = `<!doctype html>
+<html><head>
+<title>  Go
+  Programming </title>
+<meta name="Description" content="Build simple, secure, scalable systems">
+<meta property="og:image" content="/images/go-logo.png">
+<meta property="og:site_name" content="go.dev">
+</head><body>
+<meta property="og:title" content="not in the head">
+</body></html>`
+
+func TestParse(t *testing.T) {
+	base, err := url.Parse("https://go.dev/learn/")
+	require.NoError(t, err)
+
+	m := Parse(strings.NewReader(page), base)
+	require.Equal(t, models.LinkMetadata{
+		Title:       "Go Programming",
+		Description: "Build simple, secure, scalable systems",
+		Image:       "https://go.dev/images/go-logo.png",
+		SiteName:    "go.dev",
+	}, m)
+
+	// Open Graph values take precedence over the title and the description
+	m = Parse(strings.NewReader(`<title>Title</title><meta name="description" content="description">`+
+		`<meta property="og:title" content="OG title"><meta property="og:description" content="OG description">`+
+		`<meta property="og:type" content="website"><meta property="og:image" content="javascript:alert(1)">`), base)
+	require.Equal(t, models.LinkMetadata{Title: "OG title", Description: "OG description", Type: "website"}, m)
+
+	m = Parse(strings.NewReader("<title>"+strings.Repeat("я", 2*maxFieldLength)+"</title>"), base)
+	require.Equal(t, maxFieldLength, len([]rune(m.Title)))
+
+	require.Equal(t, models.LinkMetadata{}, Parse(strings.NewReader("not html at all"), base))
+}
+
+func newTestFetcher(maxBytes int64) *Fetcher {
+	return &Fetcher{client: &http.Client{Timeout: time.Second}, maxBytes: maxBytes}
+}
+
+func TestFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=windows-1251")
+			// "Привет" in windows-1251
+			fmt.Fprint(w, "<title>\xcf\xf0\xe8\xe2\xe5\xf2</title>")
+		case "/long":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<title>"+strings.Repeat("a", 100)+"</title>")
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, "{}")
+		case "/busy":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/down":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	f := newTestFetcher(1 << 20)
+	ctx := context.Background()
+
+	m, err := f.Fetch(ctx, srv.URL+"/page")
+	require.NoError(t, err)
+	require.Equal(t, "Привет", m.Title)
+	require.WithinDuration(t, time.Now(), m.FetchedAt, time.Second)
+
+	// the page is read up to the limit only
+	m, err = newTestFetcher(20).Fetch(ctx, srv.URL+"/long")
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat("a", 13), m.Title)
+
+	_, err = f.Fetch(ctx, srv.URL+"/json")
+	require.ErrorIs(t, err, ErrNotHTML)
+
+	var tmp *TemporaryError
+	for _, p := range []string{"/busy", "/down"} {
+		_, err = f.Fetch(ctx, srv.URL+p)
+		require.True(t, errors.As(err, &tmp), p)
+	}
+	_, err = f.Fetch(ctx, srv.URL+"/missing")
+	require.Error(t, err)
+	require.False(t, errors.As(err, &tmp))
+
+	srv.Close()
+	_, err = f.Fetch(ctx, srv.URL+"/page")
+	require.True(t, errors.As(err, &tmp))
+}
+
+func TestFetchPrivateAddress(t *testing.T) {
+	t.Setenv("OUTBOUND_ALLOW_PRIVATE", "false")
+	config.SetTestConfig()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+	}))
+	defer srv.Close()
+
+	_, err := NewFetcher(time.Second, 1<<20).Fetch(context.Background(), srv.URL)
+	require.ErrorIs(t, err, app.ErrPrivateAddress)
+	var tmp *TemporaryError
+	require.False(t, errors.As(err, &tmp))
+}
+
+// memStore keeps the saved metadata by short codes.
+type memStore struct {
+	mu       sync.Mutex
+	metadata map[string]models.LinkMetadata
+}
+
+func (s *memStore) SetMetadata(_ context.Context, short, _ string, m models.LinkMetadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.metadata[short] = m
+	return nil
+}
+
+func (s *memStore) get(short string) (models.LinkMetadata, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.metadata[short]
+	return m, ok
+}
+
+func TestEnrich(t *testing.T) {
+	t.Setenv("OUTBOUND_ALLOW_PRIVATE", "true")
+	config.SetTestConfig()
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		if req.URL.Path == "/flaky" && n < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if req.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<title>Go</title>")
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := zap.NewNop().Sugar()
+	store := &memStore{metadata: make(map[string]models.LinkMetadata)}
+	e := NewEnricher(ctx, store, app.NewWorkerPool(ctx, logger), logger)
+	e.backoff = time.Millisecond
+
+	// temporary failures are retried
+	e.Enrich("flaky", srv.URL+"/flaky")
+	require.Eventually(t, func() bool {
+		m, ok := store.get("flaky")
+		return ok && m.Title == "Go"
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	// until the attempts run out
+	atomic.StoreInt32(&requests, 0)
+	e.Enrich("down", srv.URL+"/down")
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&requests) == attempts
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, int32(attempts), atomic.LoadInt32(&requests))
+	_, ok := store.get("down")
+	require.False(t, ok)
+}
@@ -3,9 +3,11 @@ package models
 type BatchOriginal struct {
 	CorrelationID string `json:"correlation_id"`
 	OriginalURL   string `json:"original_url"`
+	LinkOptions
 }
 
 type BatchShort struct {
 	CorrelationID string `json:"correlation_id"`
 	ShortURL      string `json:"short_url"`
+	QR            string `json:"qr,omitempty"` // data URI, only if requested
 }
@@ -0,0 +1,99 @@
+package models
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/DrGermanius/Shortener/internal/app/hll"
+	"github.com/DrGermanius/Shortener/internal/app/useragent"
+)
+
+// TopStatsSize is the number of the most frequent values reported per click attribute.
+const TopStatsSize = 10
+
+// Click is a redirect made by a short link.
+type Click struct {
+	Short     string    `json:"short_url"`
+	Time      time.Time `json:"time"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IPHash    string    `json:"ip_hash,omitempty"`
+	Country   string    `json:"country,omitempty"`
+}
+
+// ReferrerHost returns host of the referrer, empty for direct visits.
+func (c Click) ReferrerHost() string {
+	u, err := url.Parse(c.Referrer)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// Device returns device class of the client, see useragent.Device.
+func (c Click) Device() string {
+	return useragent.Device(c.UserAgent)
+}
+
+// VisitorHash returns hash of the visitor fingerprint made of the client address hash and user agent,
+// zero if the client address is unknown. The address hash is keyed, so fingerprints are salted as well.
+func (c Click) VisitorHash() uint64 {
+	if c.IPHash == "" {
+		return 0
+	}
+	return hll.Hash([]byte(c.IPHash + "\n" + c.UserAgent))
+}
+
+// Browser returns browser family of the client, see useragent.Browser.
+func (c Click) Browser() string {
+	return useragent.Browser(c.UserAgent)
+}
+
+// OS returns operating system of the client, see useragent.OS.
+func (c Click) OS() string {
+	return useragent.OS(c.UserAgent)
+}
+
+// LinkStats is a summary of clicks of a link.
+type LinkStats struct {
+	ShortURL string `json:"short_url"`
+	Clicks   int64  `json:"clicks"`
+	// UniqueVisitors is an estimate with relative standard error UniqueVisitorsError
+	// of visitors within [UniqueVisitorsFrom, UniqueVisitorsTo).
+	UniqueVisitors      int64        `json:"unique_visitors"`
+	UniqueVisitorsError float64      `json:"unique_visitors_error"`
+	UniqueVisitorsFrom  time.Time    `json:"unique_visitors_from"`
+	UniqueVisitorsTo    time.Time    `json:"unique_visitors_to"`
+	FirstClick          *time.Time   `json:"first_click,omitempty"`
+	LastClick           *time.Time   `json:"last_click,omitempty"`
+	Referrers           []StatsEntry `json:"referrers"`
+	Countries           []StatsEntry `json:"countries"`
+	Devices             []StatsEntry `json:"devices"`
+}
+
+// StatsEntry is the number of clicks with a value of a click attribute.
+// An empty value stands for a missing one, e.g. a direct visit or an unknown country.
+type StatsEntry struct {
+	Value  string `json:"value"`
+	Clicks int64  `json:"clicks"`
+}
+
+// TopStats returns up to TopStatsSize the most frequent values, the most frequent first.
+func TopStats(counts map[string]int64) []StatsEntry {
+	res := make([]StatsEntry, 0, len(counts))
+	for v, n := range counts {
+		res = append(res, StatsEntry{Value: v, Clicks: n})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Clicks != res[j].Clicks {
+			return res[i].Clicks > res[j].Clicks
+		}
+		return res[i].Value < res[j].Value
+	})
+	if len(res) > TopStatsSize {
+		res = res[:TopStatsSize]
+	}
+	return res
+}
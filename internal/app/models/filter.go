@@ -0,0 +1,44 @@
+package models
+
+import "strings"
+
+// LinkFilter selects user's links; the zero value selects all of them.
+type LinkFilter struct {
+	Tags   []string // every tag must be set on the link
+	Folder string
+	Query  string // every word must occur in title, notes or destination
+}
+
+// Words returns lower-cased search words of the query.
+func (f LinkFilter) Words() []string {
+	return strings.Fields(strings.ToLower(f.Query))
+}
+
+// Match reports whether the link satisfies the filter.
+func (f LinkFilter) Match(l LinkInfo) bool {
+	if f.Folder != "" && l.Folder != f.Folder {
+		return false
+	}
+	for _, t := range f.Tags {
+		if !hasTag(l.Tags, t) {
+			return false
+		}
+	}
+
+	text := strings.ToLower(l.Title + "\n" + l.Notes + "\n" + l.Long)
+	for _, w := range f.Words() {
+		if !strings.Contains(text, w) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// LinkHealth is the result of the last destination check of a link.
+type LinkHealth struct {
+	Healthy   bool      `json:"healthy"`
+	Status    int       `json:"status,omitempty"`
+	LatencyMS int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// HealthTarget is a link destination due for a check.
+type HealthTarget struct {
+	Short string
+	Long  string
+}
@@ -0,0 +1,9 @@
+package models
+
+// InternalStats are totals of the service for its operators.
+type InternalStats struct {
+	URLs           int64 `json:"urls"`
+	Users          int64 `json:"users"`
+	DeletedURLs    int64 `json:"deleted_urls"`
+	CreatedLast24h int64 `json:"created_last_24h"`
+}
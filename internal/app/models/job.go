@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// Statuses of a job.
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is a long running operation started by a user request.
+type Job struct {
+	ID         string      `json:"id"`
+	UID        string      `json:"-"`
+	Kind       string      `json:"kind"`
+	Status     string      `json:"status"`
+	Total      int         `json:"total"`
+	Processed  int         `json:"processed"`
+	Failed     int         `json:"failed"`
+	Error      string      `json:"error,omitempty"`
+	Results    []JobResult `json:"results,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+}
+
+// JobResult is the outcome of a single item of a job.
+type JobResult struct {
+	Item        string `json:"item"` // line number, correlation id or short code the result is about
+	OriginalURL string `json:"original_url,omitempty"`
+	ShortURL    string `json:"short_url,omitempty"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Finished reports whether the job won't change anymore.
+func (j Job) Finished() bool {
+	return j.Status == JobDone || j.Status == JobFailed
+}
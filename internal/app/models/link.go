@@ -1,14 +1,160 @@
 package models
 
+import "time"
+
 type LinkInfo struct {
 	Long      string
 	UUID      string
 	IsDeleted bool
+	ExpiresAt time.Time
+	MaxClicks int64
+	Clicks    int64
+
+	PasswordHash string
+	RedirectCode int
+	CachePolicy  string
+
+	ForwardQuery  bool
+	QueryConflict string
+	ForwardPath   bool
+
+	Rules []RedirectRule
+
+	ActiveFrom  time.Time
+	ActiveUntil time.Time
+
+	Title     string
+	Preview   bool
+	CreatedAt time.Time
+
+	Tags   []string
+	Folder string
+	Notes  string
+
+	Health   *LinkHealth
+	Metadata *LinkMetadata
 }
 
 type LinkJSON struct {
-	UUID      string `json:"uuid,omitempty"`
-	Short     string `json:"short_url"`
-	Long      string `json:"original_url"`
-	IsDeleted bool   `json:"is_deleted"`
+	UUID      string     `json:"uuid,omitempty"`
+	Short     string     `json:"short_url"`
+	Long      string     `json:"original_url"`
+	IsDeleted bool       `json:"is_deleted"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxClicks int64      `json:"max_clicks,omitempty"`
+	Clicks    int64      `json:"clicks,omitempty"`
+
+	HasPassword  bool   `json:"has_password,omitempty"`
+	RedirectCode int    `json:"redirect_code,omitempty"`
+	CachePolicy  string `json:"cache_policy,omitempty"`
+
+	ForwardQuery  bool   `json:"forward_query,omitempty"`
+	QueryConflict string `json:"query_conflict,omitempty"`
+	ForwardPath   bool   `json:"forward_path,omitempty"`
+
+	Rules []RedirectRule `json:"rules,omitempty"`
+
+	ActiveFrom  *time.Time `json:"active_from,omitempty"`
+	ActiveUntil *time.Time `json:"active_until,omitempty"`
+
+	Title     string     `json:"title,omitempty"`
+	Preview   bool       `json:"preview,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+
+	Tags   []string `json:"tags,omitempty"`
+	Folder string   `json:"folder,omitempty"`
+	Notes  string   `json:"notes,omitempty"`
+
+	Health   *LinkHealth   `json:"health,omitempty"`
+	Metadata *LinkMetadata `json:"metadata,omitempty"`
+}
+
+// JSON converts link info stored under the short key into its JSON representation.
+func (l LinkInfo) JSON(short string) LinkJSON {
+	j := LinkJSON{
+		UUID:      l.UUID,
+		Short:     short,
+		Long:      l.Long,
+		IsDeleted: l.IsDeleted,
+		MaxClicks: l.MaxClicks,
+		Clicks:    l.Clicks,
+
+		HasPassword:  l.PasswordHash != "",
+		RedirectCode: l.RedirectCode,
+		CachePolicy:  l.CachePolicy,
+
+		ForwardQuery:  l.ForwardQuery,
+		QueryConflict: l.QueryConflict,
+		ForwardPath:   l.ForwardPath,
+
+		Rules: l.Rules,
+
+		ExpiresAt:   TimePtr(l.ExpiresAt),
+		ActiveFrom:  TimePtr(l.ActiveFrom),
+		ActiveUntil: TimePtr(l.ActiveUntil),
+
+		Title:     l.Title,
+		Preview:   l.Preview,
+		CreatedAt: TimePtr(l.CreatedAt),
+
+		Tags:   l.Tags,
+		Folder: l.Folder,
+		Notes:  l.Notes,
+
+		Health:   l.Health,
+		Metadata: l.Metadata,
+	}
+	return j
+}
+
+// Info converts JSON representation of a link back into link info.
+func (j LinkJSON) Info() LinkInfo {
+	l := LinkInfo{
+		Long:      j.Long,
+		UUID:      j.UUID,
+		IsDeleted: j.IsDeleted,
+		MaxClicks: j.MaxClicks,
+		Clicks:    j.Clicks,
+
+		RedirectCode: j.RedirectCode,
+		CachePolicy:  j.CachePolicy,
+
+		ForwardQuery:  j.ForwardQuery,
+		QueryConflict: j.QueryConflict,
+		ForwardPath:   j.ForwardPath,
+
+		Rules: j.Rules,
+
+		ExpiresAt:   TimeValue(j.ExpiresAt),
+		ActiveFrom:  TimeValue(j.ActiveFrom),
+		ActiveUntil: TimeValue(j.ActiveUntil),
+
+		Title:     j.Title,
+		Preview:   j.Preview,
+		CreatedAt: TimeValue(j.CreatedAt),
+
+		Tags:   j.Tags,
+		Folder: j.Folder,
+		Notes:  j.Notes,
+
+		Health:   j.Health,
+		Metadata: j.Metadata,
+	}
+	return l
+}
+
+// TimePtr returns pointer to t, or nil for the zero time.
+func TimePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// TimeValue returns time pointed by t, or the zero time for nil.
+func TimeValue(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
 }
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// LinkMetadata is the description of the destination page extracted from its html.
+type LinkMetadata struct {
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Image       string    `json:"image,omitempty"`
+	SiteName    string    `json:"site_name,omitempty"`
+	Type        string    `json:"type,omitempty"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
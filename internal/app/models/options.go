@@ -0,0 +1,110 @@
+package models
+
+import "time"
+
+// LinkOptions holds optional per-link settings accepted on link creation.
+type LinkOptions struct {
+	Alias string `json:"-"` // custom short code given by imports, the short representation of the address by default
+
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	TTL       int64      `json:"ttl,omitempty"` // seconds, converted to ExpiresAt by handlers
+	MaxClicks int64      `json:"max_clicks,omitempty"`
+	Password  string     `json:"password,omitempty"` // plain text, replaced with PasswordHash by handlers
+
+	RedirectCode int    `json:"redirect_code,omitempty"` // 0 stands for the server default
+	CachePolicy  string `json:"cache_policy,omitempty"`  // Cache-Control value, empty stands for the server default
+
+	ForwardQuery  bool   `json:"forward_query,omitempty"`  // merge query params of the short link into the destination
+	QueryConflict string `json:"query_conflict,omitempty"` // one of QueryConflict* values, QueryConflictDestination by default
+	ForwardPath   bool   `json:"forward_path,omitempty"`   // append path after the short code to the destination path
+
+	Rules []RedirectRule `json:"rules,omitempty"`
+
+	ActiveFrom  *time.Time `json:"active_from,omitempty"`
+	ActiveUntil *time.Time `json:"active_until,omitempty"`
+
+	Title   string `json:"title,omitempty"`
+	Preview bool   `json:"preview,omitempty"` // always show the interstitial page instead of redirecting
+
+	Tags   []string `json:"tags,omitempty"`
+	Folder string   `json:"folder,omitempty"`
+	Notes  string   `json:"notes,omitempty"`
+
+	PasswordHash string `json:"-"`
+}
+
+// Policies of merging a query param present both in the short link and in the destination.
+const (
+	QueryConflictDestination = "destination" // keep the destination value
+	QueryConflictRequest     = "request"     // replace it with the short link value
+	QueryConflictAppend      = "append"      // keep both values
+)
+
+// NewLinkInfo creates link info for a new link with the given options applied.
+func NewLinkInfo(uid, long string, opts LinkOptions) LinkInfo {
+	l := LinkInfo{
+		UUID:      uid,
+		CreatedAt: time.Now(),
+	}
+	l.SetOptions(long, opts)
+	return l
+}
+
+// Options returns owner editable settings of the link.
+func (l LinkInfo) Options() LinkOptions {
+	return LinkOptions{
+		ExpiresAt:    TimePtr(l.ExpiresAt),
+		MaxClicks:    l.MaxClicks,
+		RedirectCode: l.RedirectCode,
+		CachePolicy:  l.CachePolicy,
+
+		ForwardQuery:  l.ForwardQuery,
+		QueryConflict: l.QueryConflict,
+		ForwardPath:   l.ForwardPath,
+
+		Rules: l.Rules,
+
+		ActiveFrom:  TimePtr(l.ActiveFrom),
+		ActiveUntil: TimePtr(l.ActiveUntil),
+
+		Title:   l.Title,
+		Preview: l.Preview,
+
+		Tags:   l.Tags,
+		Folder: l.Folder,
+		Notes:  l.Notes,
+
+		PasswordHash: l.PasswordHash,
+	}
+}
+
+// SetOptions replaces the destination and owner editable settings of the link.
+// A new destination drops the result of the last health check and the page metadata.
+func (l *LinkInfo) SetOptions(long string, opts LinkOptions) {
+	if l.Long != long {
+		l.Health = nil
+		l.Metadata = nil
+	}
+	l.Long = long
+	l.MaxClicks = opts.MaxClicks
+	l.PasswordHash = opts.PasswordHash
+	l.RedirectCode = opts.RedirectCode
+	l.CachePolicy = opts.CachePolicy
+
+	l.ForwardQuery = opts.ForwardQuery
+	l.QueryConflict = opts.QueryConflict
+	l.ForwardPath = opts.ForwardPath
+
+	l.Rules = opts.Rules
+
+	l.ExpiresAt = TimeValue(opts.ExpiresAt)
+	l.ActiveFrom = TimeValue(opts.ActiveFrom)
+	l.ActiveUntil = TimeValue(opts.ActiveUntil)
+
+	l.Title = opts.Title
+	l.Preview = opts.Preview
+
+	l.Tags = opts.Tags
+	l.Folder = opts.Folder
+	l.Notes = opts.Notes
+}
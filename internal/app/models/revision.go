@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Revision is a saved state of owner editable link settings.
+type Revision struct {
+	Number    int
+	Author    string
+	CreatedAt time.Time
+	Link      LinkInfo
+}
+
+type RevisionJSON struct {
+	Number    int       `json:"revision"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+	Link      LinkJSON  `json:"link"`
+}
+
+// EditRequest changes settings of an existing link; fields absent from the request keep their values.
+type EditRequest struct {
+	URL string `json:"url"`
+	LinkOptions
+	RemovePassword bool `json:"remove_password,omitempty"`
+}
+
+type RollbackRequest struct {
+	Revision int `json:"revision"`
+}
+
+// JSON converts revision of the link stored under the short key into its JSON representation.
+func (r Revision) JSON(short string) RevisionJSON {
+	j := RevisionJSON{
+		Number:    r.Number,
+		Author:    r.Author,
+		CreatedAt: r.CreatedAt,
+		Link:      r.Link.JSON(short),
+	}
+	j.Link.UUID = ""
+	j.Link.Clicks = 0
+	j.Link.Health = nil
+	j.Link.Metadata = nil
+	return j
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"time"
+
+	"github.com/DrGermanius/Shortener/internal/app/hll"
+)
+
+// VisitorInterval is the interval of buckets of unique visitor sketches.
+const VisitorInterval = IntervalDay
+
+// VisitorRetention is how long unique visitor sketches are kept.
+const VisitorRetention = 366 * 24 * time.Hour
+
+// Intervals of click rollups.
+const (
+	IntervalMinute = "minute"
+	IntervalHour   = "hour"
+	IntervalDay    = "day"
+)
+
+// Intervals maps rollup intervals to their lengths.
+var Intervals = map[string]time.Duration{
+	IntervalMinute: time.Minute,
+	IntervalHour:   time.Hour,
+	IntervalDay:    24 * time.Hour,
+}
+
+// Dimensions of click rollups; DimensionTotal counts every click with an empty value.
+const (
+	DimensionTotal    = "total"
+	DimensionReferrer = "referrer"
+	DimensionCountry  = "country"
+	DimensionBrowser  = "browser"
+	DimensionOS       = "os"
+	DimensionDevice   = "device"
+)
+
+// RollupKey identifies a click counter of a link.
+type RollupKey struct {
+	Interval  string
+	Bucket    time.Time
+	Dimension string
+	Value     string
+}
+
+// ClickRollup is a click counter of a link.
+type ClickRollup struct {
+	RollupKey
+	Clicks int64
+}
+
+// Retention is how long rollups of the intervals are kept; rollups of other intervals are kept as long as their links.
+var Retention = map[string]time.Duration{
+	IntervalMinute: 7 * 24 * time.Hour,
+}
+
+// AddRollups increments counters of every interval and dimension by the click.
+func AddRollups(counts map[RollupKey]int64, c Click) {
+	for _, k := range RollupKeys(c) {
+		counts[k]++
+	}
+}
+
+// RollupKeys returns keys of the counters of every interval and dimension the click counts in.
+func RollupKeys(c Click) []RollupKey {
+	values := [...][2]string{
+		{DimensionTotal, ""},
+		{DimensionReferrer, c.ReferrerHost()},
+		{DimensionCountry, c.Country},
+		{DimensionBrowser, c.Browser()},
+		{DimensionOS, c.OS()},
+		{DimensionDevice, c.Device()},
+	}
+	keys := make([]RollupKey, 0, len(Intervals)*len(values))
+	for interval, d := range Intervals {
+		bucket := c.Time.UTC().Truncate(d)
+		for _, v := range values {
+			keys = append(keys, RollupKey{Interval: interval, Bucket: bucket, Dimension: v[0], Value: v[1]})
+		}
+	}
+	return keys
+}
+
+// VisitorSketch is the sketch of unique visitors of a link within a bucket.
+type VisitorSketch struct {
+	Bucket time.Time
+	Sketch *hll.Sketch
+}
+
+// AddVisitor adds visitor of the click to the sketch of its bucket; clicks of unknown clients aren't counted.
+func AddVisitor(sketches map[time.Time]*hll.Sketch, c Click) {
+	h := c.VisitorHash()
+	if h == 0 {
+		return
+	}
+
+	bucket := c.Time.UTC().Truncate(Intervals[VisitorInterval])
+	s, ok := sketches[bucket]
+	if !ok {
+		s = hll.New()
+		sketches[bucket] = s
+	}
+	s.Add(h)
+}
+
+// TimeSeries is click counts of a link split into buckets of the interval.
+type TimeSeries struct {
+	ShortURL string       `json:"short_url"`
+	Interval string       `json:"interval"`
+	From     time.Time    `json:"from"`
+	To       time.Time    `json:"to"`
+	Buckets  []TimeBucket `json:"buckets"`
+}
+
+// TimeBucket is click counts of a link since Start up to the next bucket.
+type TimeBucket struct {
+	Start  time.Time `json:"start"`
+	Clicks int64     `json:"clicks"`
+	// UniqueVisitors is present for buckets of VisitorInterval only, see LinkStats.
+	UniqueVisitors *int64       `json:"unique_visitors,omitempty"`
+	Referrers      []StatsEntry `json:"referrers"`
+	Browsers       []StatsEntry `json:"browsers"`
+	OS             []StatsEntry `json:"os"`
+	Devices        []StatsEntry `json:"devices"`
+}
@@ -0,0 +1,22 @@
+package models
+
+// RedirectRule sends clients matching all of its conditions to Target or to one of Split targets.
+// A rule without conditions matches every client.
+type RedirectRule struct {
+	Device    string   `json:"device,omitempty"`     // one of useragent.Device* values
+	UserAgent string   `json:"user_agent,omitempty"` // case-insensitive substring of User-Agent
+	Languages []string `json:"languages,omitempty"`  // primary subtags of the preferred language, e.g. "de"
+	Referrer  string   `json:"referrer,omitempty"`   // referrer host or its parent domain
+	HourFrom  *int     `json:"hour_from,omitempty"`  // inclusive
+	HourTo    *int     `json:"hour_to,omitempty"`    // exclusive, may be less than HourFrom to wrap over midnight
+	Timezone  string   `json:"timezone,omitempty"`   // IANA name of the hours timezone, UTC by default
+
+	Target string        `json:"target,omitempty"`
+	Split  []SplitTarget `json:"split,omitempty"`
+}
+
+// SplitTarget is a destination of a weighted random split.
+type SplitTarget struct {
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
@@ -2,6 +2,7 @@ package models
 
 type ShortenRequest struct {
 	URL string `json:"url"`
+	LinkOptions
 }
 
 type ShortenResponse struct {
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Types of events delivered to webhooks.
+const (
+	EventLinkCreated = "link.created"
+	EventLinkDeleted = "link.deleted"
+	EventLinkClicked = "link.clicked"
+)
+
+// EventTypes lists every event type.
+var EventTypes = []string{EventLinkCreated, EventLinkDeleted, EventLinkClicked}
+
+// Statuses of a webhook delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// Webhook is an endpoint of a user notified about events of the user's links.
+type Webhook struct {
+	ID        string    `json:"id"`
+	UID       string    `json:"-"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookRequest registers a webhook; no events stand for every event type.
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+// Subscribed reports whether the webhook is notified about events of the type.
+func (w Webhook) Subscribed(eventType string) bool {
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is the payload of a webhook delivery.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      EventData `json:"data"`
+}
+
+// EventData describes the link the event is about.
+type EventData struct {
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url,omitempty"`
+	Referrer    string `json:"referrer,omitempty"`
+	UserAgent   string `json:"user_agent,omitempty"`
+}
+
+// Delivery is an attempt to notify a webhook about an event, retried until it succeeds or gives up.
+type Delivery struct {
+	ID            string          `json:"id"`
+	WebhookID     string          `json:"webhook_id"`
+	UID           string          `json:"-"`
+	EventType     string          `json:"event_type"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	LastStatus    int             `json:"last_status,omitempty"`
+	LastError     string          `json:"last_error,omitempty"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+	DeliveredAt   *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// Finished reports whether the delivery won't be attempted again unless a dead one is retried.
+func (d Delivery) Finished() bool {
+	return d.Status == DeliveryDelivered || d.Status == DeliveryDead
+}
+
+// FinishedAt returns the time the delivery was delivered, or the time the last attempt of a dead delivery was due.
+func (d Delivery) FinishedAt() time.Time {
+	if d.DeliveredAt != nil {
+		return *d.DeliveredAt
+	}
+	return d.NextAttemptAt
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/DrGermanius/Shortener/internal/app/config"
+)
+
+const outboundUserAgent = "Shortener/1.0 (+link checker)"
+
+// NewOutboundClient creates HTTP client for requests to user supplied addresses.
+// Unless OUTBOUND_ALLOW_PRIVATE is set, it refuses to connect to loopback, private and link-local
+// addresses, so links can't be used to probe the service network.
+func NewOutboundClient(timeout time.Duration) *http.Client {
+	allowPrivate, _ := strconv.ParseBool(config.Config().OutboundAllowPrivate)
+
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+				ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: userAgentTransport{transport},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+}
+
+// userAgentTransport identifies the service in outbound requests.
+type userAgentTransport struct {
+	http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", outboundUserAgent)
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
@@ -0,0 +1,111 @@
+// Package qr renders QR codes of short links.
+package qr
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+)
+
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+
+	DefaultSize = 256
+	MinSize     = 64
+	MaxSize     = 2048
+)
+
+var levels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// Options describe how a QR code is rendered.
+type Options struct {
+	Format string
+	Size   int
+	ECC    string
+}
+
+// Validate fills default values of empty options and checks the rest.
+func (o *Options) Validate() error {
+	if o.Format == "" {
+		o.Format = FormatPNG
+	}
+	if o.Size == 0 {
+		o.Size = DefaultSize
+	}
+	if o.ECC == "" {
+		o.ECC = "M"
+	}
+	o.Format = strings.ToLower(o.Format)
+	o.ECC = strings.ToUpper(o.ECC)
+
+	if o.Format != FormatPNG && o.Format != FormatSVG {
+		return app.ErrInvalidQRFormat
+	}
+	if o.Size < MinSize || o.Size > MaxSize {
+		return app.ErrInvalidQRSize
+	}
+	if _, ok := levels[o.ECC]; !ok {
+		return app.ErrInvalidQRECC
+	}
+	return nil
+}
+
+// ContentType returns media type of the rendered code.
+func (o Options) ContentType() string {
+	if o.Format == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+// Render encodes content as a QR code image; options must be validated.
+func Render(content string, o Options) ([]byte, error) {
+	q, err := qrcode.New(content, levels[o.ECC])
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Format == FormatSVG {
+		return svg(q.Bitmap(), o.Size), nil
+	}
+	return q.PNG(o.Size)
+}
+
+// DataURI encodes content as a QR code data URI; options must be validated.
+func DataURI(content string, o Options) (string, error) {
+	b, err := Render(content, o)
+	if err != nil {
+		return "", err
+	}
+	return "data:" + o.ContentType() + ";base64," + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// svg draws dark modules of the bitmap as a single path scaled to size pixels.
+func svg(bitmap [][]bool, size int) []byte {
+	n := len(bitmap)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, n, n)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&buf, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes()
+}
@@ -0,0 +1,192 @@
+package app
+
+import (
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DrGermanius/Shortener/internal/app/models"
+	"github.com/DrGermanius/Shortener/internal/app/useragent"
+)
+
+const (
+	maxRules = 50
+	// maxSplitTargets and maxSplitWeight bound a split, so the total weight never overflows.
+	maxSplitTargets = 20
+	maxSplitWeight  = 10000
+)
+
+// Visit describes a client following a short link.
+type Visit struct {
+	UserAgent      string
+	AcceptLanguage string
+	Referrer       string
+	Time           time.Time
+}
+
+// MatchRule returns index of the first rule matching the visit, or -1 if there is none.
+func MatchRule(rules []models.RedirectRule, v Visit) int {
+	for i, r := range rules {
+		if matchRule(r, v) {
+			return i
+		}
+	}
+	return -1
+}
+
+// PickSplit returns index of a weighted random split target.
+func PickSplit(split []models.SplitTarget) int {
+	total := 0
+	for _, s := range split {
+		total += s.Weight
+	}
+
+	n := rand.Intn(total)
+	for i, s := range split {
+		n -= s.Weight
+		if n < 0 {
+			return i
+		}
+	}
+	return len(split) - 1
+}
+
+// ValidateRules checks that rules are complete and can be evaluated.
+func ValidateRules(rules []models.RedirectRule) error {
+	if len(rules) > maxRules {
+		return ErrInvalidRules
+	}
+
+	for _, r := range rules {
+		if (r.Target == "") == (len(r.Split) == 0) || len(r.Split) > maxSplitTargets {
+			return ErrInvalidRules
+		}
+		if r.Target != "" && !isAbsoluteURL(r.Target) {
+			return ErrInvalidRules
+		}
+		for _, s := range r.Split {
+			if s.Weight <= 0 || s.Weight > maxSplitWeight || !isAbsoluteURL(s.URL) {
+				return ErrInvalidRules
+			}
+		}
+
+		switch r.Device {
+		case "", useragent.DeviceIOS, useragent.DeviceAndroid, useragent.DeviceMobile, useragent.DeviceDesktop, useragent.DeviceBot:
+		default:
+			return ErrInvalidRules
+		}
+		if (r.HourFrom == nil) != (r.HourTo == nil) {
+			return ErrInvalidRules
+		}
+		// an empty window would never match; 0 to 24 stands for the whole day
+		if r.HourFrom != nil && (*r.HourFrom < 0 || *r.HourFrom > 23 || *r.HourTo < 0 || *r.HourTo > 24 || *r.HourFrom == *r.HourTo) {
+			return ErrInvalidRules
+		}
+		if r.Timezone != "" {
+			_, err := time.LoadLocation(r.Timezone)
+			if err != nil {
+				return ErrInvalidRules
+			}
+		}
+	}
+	return nil
+}
+
+func matchRule(r models.RedirectRule, v Visit) bool {
+	if r.Device != "" {
+		d := useragent.Device(v.UserAgent)
+		if d != r.Device && !(r.Device == useragent.DeviceMobile && useragent.IsMobile(d)) {
+			return false
+		}
+	}
+	if r.UserAgent != "" && !strings.Contains(strings.ToLower(v.UserAgent), strings.ToLower(r.UserAgent)) {
+		return false
+	}
+	if len(r.Languages) > 0 && !containsFold(r.Languages, preferredLanguage(v.AcceptLanguage)) {
+		return false
+	}
+	if r.Referrer != "" && !matchDomain(referrerHost(v.Referrer), r.Referrer) {
+		return false
+	}
+	if r.HourFrom != nil && r.HourTo != nil && !matchHour(*r.HourFrom, *r.HourTo, r.Timezone, v.Time) {
+		return false
+	}
+	return true
+}
+
+// preferredLanguage returns primary subtag of the language with the highest quality in Accept-Language.
+func preferredLanguage(header string) string {
+	best, bestQ := "", -1.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				v, err := strconv.ParseFloat(f[2:], 64)
+				if err == nil {
+					q = v
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+
+	if i := strings.IndexByte(best, '-'); i >= 0 {
+		best = best[:i]
+	}
+	return strings.ToLower(best)
+}
+
+func matchHour(from, to int, tz string, t time.Time) bool {
+	loc := time.UTC
+	if tz != "" {
+		l, err := time.LoadLocation(tz)
+		if err == nil {
+			loc = l
+		}
+	}
+
+	h := t.In(loc).Hour()
+	if from <= to {
+		return h >= from && h < to
+	}
+	return h >= from || h < to
+}
+
+func referrerHost(ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// matchDomain reports whether host is the domain or its subdomain.
+func matchDomain(host, domain string) bool {
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func isAbsoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,256 @@
+// Package screening decides whether URL addresses may be shortened and followed.
+package screening
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+)
+
+// checkTimeout bounds the time checkers may take to judge an URL address.
+const checkTimeout = 2 * time.Second
+
+// Checker is an additional source of verdicts, e.g. a reputation service client.
+// It returns a non-empty reason if the URL address must be blocked.
+type Checker interface {
+	Check(ctx context.Context, u *url.URL) (string, error)
+}
+
+// BlockedError describes why an URL address is blocked.
+type BlockedError struct {
+	Reason string
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("%v: %s", app.ErrBlockedURL, e.Reason)
+}
+
+func (e *BlockedError) Unwrap() error {
+	return app.ErrBlockedURL
+}
+
+// Screener checks URL addresses against domain blocklist and regexp rules loaded from local files.
+type Screener struct {
+	mu       sync.RWMutex
+	domains  map[string]struct{}
+	rules    []*regexp.Regexp
+	checkers []Checker
+	timeout  time.Duration
+
+	domainsFile list
+	rulesFile   list
+}
+
+type list struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// New creates screener with lists read from the files; empty path or missing file stands for an empty list.
+// If a file can't be read the screener is returned along with the error: it starts with empty lists
+// and keeps the paths, so Reload picks the files up once they are fixed.
+func New(domainsPath, rulesPath string) (*Screener, error) {
+	s := &Screener{
+		domains:     make(map[string]struct{}),
+		timeout:     checkTimeout,
+		domainsFile: list{path: domainsPath},
+		rulesFile:   list{path: rulesPath},
+	}
+
+	_, err := s.Reload()
+	return s, err
+}
+
+// AddChecker plugs an additional checker consulted after the local lists.
+func (s *Screener) AddChecker(c Checker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkers = append(s.checkers, c)
+}
+
+// Check returns *BlockedError if the URL address is blocked.
+// Checkers are called without holding the lists, so a slow checker doesn't hold up reloads and other checks.
+func (s *Screener) Check(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return &BlockedError{Reason: "malformed url"}
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+
+	// the lists are replaced rather than changed on reload, so they can be read after the lock is released
+	s.mu.RLock()
+	domains, rules, checkers := s.domains, s.rules, s.checkers
+	s.mu.RUnlock()
+
+	for d := host; d != ""; d = parentDomain(d) {
+		if _, ok := domains[d]; ok {
+			return &BlockedError{Reason: "domain " + d + " is blocklisted"}
+		}
+	}
+	for _, r := range rules {
+		if r.MatchString(raw) {
+			return &BlockedError{Reason: "url matches rule " + r.String()}
+		}
+	}
+	if len(checkers) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	for _, c := range checkers {
+		reason, err := c.Check(ctx, u)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			return &BlockedError{Reason: reason}
+		}
+	}
+	return nil
+}
+
+// Reload rereads list files which have changed since the last read and reports whether anything was reloaded.
+func (s *Screener) Reload() (bool, error) {
+	domainsChanged, err := s.domainsFile.changed()
+	if err != nil {
+		return false, err
+	}
+	rulesChanged, err := s.rulesFile.changed()
+	if err != nil {
+		return false, err
+	}
+	if !domainsChanged && !rulesChanged {
+		return false, nil
+	}
+
+	var domains map[string]struct{}
+	if domainsChanged {
+		lines, err := readLines(s.domainsFile.path)
+		if err != nil {
+			return false, err
+		}
+		domains = make(map[string]struct{}, len(lines))
+		for _, l := range lines {
+			domains[strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(l), "."), ".")] = struct{}{}
+		}
+	}
+
+	var rules []*regexp.Regexp
+	if rulesChanged {
+		lines, err := readLines(s.rulesFile.path)
+		if err != nil {
+			return false, err
+		}
+		rules = make([]*regexp.Regexp, 0, len(lines))
+		for _, l := range lines {
+			r, err := regexp.Compile(l)
+			if err != nil {
+				return false, fmt.Errorf("screening rule %q: %w", l, err)
+			}
+			rules = append(rules, r)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if domainsChanged {
+		s.domains = domains
+		s.domainsFile.stat()
+	}
+	if rulesChanged {
+		s.rules = rules
+		s.rulesFile.stat()
+	}
+	return true, nil
+}
+
+// Watch reloads changed list files every interval until ctx is done; a broken file keeps the previous list.
+func (s *Screener) Watch(ctx context.Context, interval time.Duration, logger *zap.SugaredLogger) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			reloaded, err := s.Reload()
+			if err != nil {
+				logger.Errorf("screening lists reload failed: %v", err)
+				continue
+			}
+			if reloaded {
+				logger.Infof("screening lists reloaded")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// changed reports whether the file differs from the last read one.
+func (l *list) changed() (bool, error) {
+	if l.path == "" {
+		return false, nil
+	}
+
+	fi, err := os.Stat(l.path)
+	if os.IsNotExist(err) {
+		return !l.modTime.IsZero(), nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !fi.ModTime().Equal(l.modTime) || fi.Size() != l.size, nil
+}
+
+func (l *list) stat() {
+	l.modTime, l.size = time.Time{}, 0
+
+	fi, err := os.Stat(l.path)
+	if err == nil {
+		l.modTime, l.size = fi.ModTime(), fi.Size()
+	}
+}
+
+// readLines returns non-empty lines of the file except "#" comment lines; a missing file has no lines.
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l != "" && !strings.HasPrefix(l, "#") {
+			lines = append(lines, l)
+		}
+	}
+	return lines, s.Err()
+}
+
+func parentDomain(d string) string {
+	i := strings.IndexByte(d, '.')
+	if i < 0 {
+		return ""
+	}
+	return d[i+1:]
+}
@@ -0,0 +1,158 @@
+package screening
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+)
+
+// writeList writes the list file, moving its modification time forward so the change is noticed.
+func writeList(t *testing.T, p, content string) {
+	require.NoError(t, os.WriteFile(p, []byte(content), 0o600))
+	at := time.Now().Add(time.Duration(len(content)) * time.Second)
+	require.NoError(t, os.Chtimes(p, at, at))
+}
+
+func requireBlocked(t *testing.T, s *Screener, raw, reason string) {
+	err := s.Check(context.Background(), raw)
+	require.ErrorIs(t, err, app.ErrBlockedURL, raw)
+	var blocked *BlockedError
+	require.True(t, errors.As(err, &blocked))
+	require.Equal(t, reason, blocked.Reason)
+}
+
+type checkerFunc func(ctx context.Context, u *url.URL) (string, error)
+
+func (f checkerFunc) Check(ctx context.Context, u *url.URL) (string, error) {
+	return f(ctx, u)
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	domains, rules := filepath.Join(dir, "domains"), filepath.Join(dir, "rules")
+	writeList(t, domains, "# phishing\n.Bad.Example.\n\n  evil.test  \n")
+	writeList(t, rules, `^https?://[^/]+/free-money`+"\n")
+
+	s, err := New(domains, rules)
+	require.NoError(t, err)
+
+	requireBlocked(t, s, "https://bad.example/path", "domain bad.example is blocklisted")
+	requireBlocked(t, s, "https://WWW.Bad.Example./path", "domain bad.example is blocklisted")
+	requireBlocked(t, s, "http://a.b.evil.test:8080", "domain evil.test is blocklisted")
+	requireBlocked(t, s, "https://go.dev/free-money", "url matches rule ^https?://[^/]+/free-money")
+	requireBlocked(t, s, "http://[::1", "malformed url")
+	require.NoError(t, s.Check(context.Background(), "https://notbad.example"))
+	require.NoError(t, s.Check(context.Background(), "https://go.dev/money/free-money"))
+
+	checkErr := errors.New("reputation service is down")
+	s.AddChecker(checkerFunc(func(_ context.Context, u *url.URL) (string, error) {
+		switch u.Host {
+		case "malware.test":
+			return "malware", nil
+		case "unknown.test":
+			return "", checkErr
+		}
+		return "", nil
+	}))
+	requireBlocked(t, s, "https://malware.test", "malware")
+	require.ErrorIs(t, s.Check(context.Background(), "https://unknown.test"), checkErr)
+	require.NoError(t, s.Check(context.Background(), "https://go.dev"))
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	domains, rules := filepath.Join(dir, "domains"), filepath.Join(dir, "rules")
+
+	// missing files stand for empty lists
+	s, err := New(domains, rules)
+	require.NoError(t, err)
+	require.NoError(t, s.Check(context.Background(), "https://bad.example"))
+	reloaded, err := s.Reload()
+	require.NoError(t, err)
+	require.False(t, reloaded)
+
+	writeList(t, domains, "bad.example\n")
+	reloaded, err = s.Reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	requireBlocked(t, s, "https://bad.example", "domain bad.example is blocklisted")
+	reloaded, err = s.Reload()
+	require.NoError(t, err)
+	require.False(t, reloaded)
+
+	// a broken rule keeps the previous lists
+	writeList(t, rules, "(unclosed\n")
+	_, err = s.Reload()
+	require.Error(t, err)
+	requireBlocked(t, s, "https://bad.example", "domain bad.example is blocklisted")
+
+	writeList(t, rules, "/blocked$\n")
+	reloaded, err = s.Reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	requireBlocked(t, s, "https://go.dev/blocked", "url matches rule /blocked$")
+
+	require.NoError(t, os.Remove(domains))
+	reloaded, err = s.Reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	require.NoError(t, s.Check(context.Background(), "https://bad.example"))
+}
+
+func TestNewWithBrokenList(t *testing.T) {
+	rules := filepath.Join(t.TempDir(), "rules")
+	writeList(t, rules, "[\n")
+
+	s, err := New("", rules)
+	require.Error(t, err)
+	require.NotNil(t, s)
+	require.NoError(t, s.Check(context.Background(), "https://go.dev/blocked"))
+
+	// the path is kept, so the fixed file is picked up
+	writeList(t, rules, "blocked\n")
+	reloaded, err := s.Reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	requireBlocked(t, s, "https://go.dev/blocked", "url matches rule blocked")
+}
+
+func TestSlowChecker(t *testing.T) {
+	domains := filepath.Join(t.TempDir(), "domains")
+	s, err := New(domains, "")
+	require.NoError(t, err)
+	s.timeout = 50 * time.Millisecond
+
+	started := make(chan struct{})
+	s.AddChecker(checkerFunc(func(ctx context.Context, _ *url.URL) (string, error) {
+		close(started)
+		<-ctx.Done()
+		return "", ctx.Err()
+	}))
+
+	checked := make(chan error)
+	go func() {
+		checked <- s.Check(context.Background(), "https://slow.example")
+	}()
+
+	// the lists are reloaded while the checker is running
+	<-started
+	writeList(t, domains, "bad.example\n")
+	reloaded, err := s.Reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+
+	select {
+	case err = <-checked:
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(5 * time.Second):
+		t.Fatal("checker isn't timed out")
+	}
+}
@@ -0,0 +1,38 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Shortener/internal/app/config"
+)
+
+// StartExpirySweeper periodically moves expired links into the deleted state until ctx is done.
+func StartExpirySweeper(ctx context.Context, sweep func(context.Context) (int64, error), logger *zap.SugaredLogger) {
+	interval, err := time.ParseDuration(config.Config().SweepInterval)
+	if err != nil || interval <= 0 {
+		interval = time.Minute
+		logger.Errorf("error while reading config expiry sweep interval %q, using %v", config.Config().SweepInterval, interval)
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			n, err := sweep(ctx)
+			if err != nil {
+				logger.Errorf("expiry sweep failed: %v", err)
+				continue
+			}
+			if n > 0 {
+				logger.Infof("expiry sweep deleted %d links", n)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Package useragent classifies clients by their User-Agent header.
+package useragent
+
+import "strings"
+
+const (
+	DeviceIOS     = "ios"
+	DeviceAndroid = "android"
+	DeviceMobile  = "mobile"
+	DeviceDesktop = "desktop"
+	DeviceBot     = "bot"
+)
+
+const (
+	BrowserChrome  = "chrome"
+	BrowserFirefox = "firefox"
+	BrowserSafari  = "safari"
+	BrowserEdge    = "edge"
+	BrowserOpera   = "opera"
+	BrowserSamsung = "samsung"
+	BrowserIE      = "ie"
+
+	OSWindows  = "windows"
+	OSMacOS    = "macos"
+	OSIOS      = "ios"
+	OSAndroid  = "android"
+	OSChromeOS = "chromeos"
+	OSLinux    = "linux"
+
+	// Other stands for an unknown browser or operating system.
+	Other = "other"
+)
+
+// Device returns device class of the client.
+// DeviceIOS and DeviceAndroid clients are mobile as well, see IsMobile.
+func Device(ua string) string {
+	s := strings.ToLower(ua)
+	switch {
+	case containsAny(s, "bot", "crawler", "spider", "curl/", "wget/", "python-requests", "go-http-client"):
+		return DeviceBot
+	case containsAny(s, "iphone", "ipad", "ipod"):
+		return DeviceIOS
+	case strings.Contains(s, "android"):
+		return DeviceAndroid
+	case containsAny(s, "mobile", "opera mini", "windows phone"):
+		return DeviceMobile
+	}
+	return DeviceDesktop
+}
+
+// Browser returns browser family of the client.
+func Browser(ua string) string {
+	s := strings.ToLower(ua)
+	// browsers based on Chrome mention it, and Chrome mentions Safari
+	switch {
+	case containsAny(s, "edg/", "edge/", "edga/", "edgios/"):
+		return BrowserEdge
+	case containsAny(s, "opr/", "opera"):
+		return BrowserOpera
+	case strings.Contains(s, "samsungbrowser/"):
+		return BrowserSamsung
+	case containsAny(s, "chrome/", "crios/", "chromium/"):
+		return BrowserChrome
+	case containsAny(s, "firefox/", "fxios/"):
+		return BrowserFirefox
+	case containsAny(s, "msie ", "trident/"):
+		return BrowserIE
+	case strings.Contains(s, "safari/"):
+		return BrowserSafari
+	}
+	return Other
+}
+
+// OS returns operating system of the client.
+func OS(ua string) string {
+	s := strings.ToLower(ua)
+	// iOS clients mention Mac OS X, and Android clients mention Linux
+	switch {
+	case containsAny(s, "iphone", "ipad", "ipod"):
+		return OSIOS
+	case strings.Contains(s, "android"):
+		return OSAndroid
+	case strings.Contains(s, "windows"):
+		return OSWindows
+	case containsAny(s, "macintosh", "mac os x"):
+		return OSMacOS
+	case strings.Contains(s, "cros "):
+		return OSChromeOS
+	case strings.Contains(s, "linux"):
+		return OSLinux
+	}
+	return Other
+}
+
+// IsMobile reports whether the device class stands for a mobile device.
+func IsMobile(device string) bool {
+	return device == DeviceIOS || device == DeviceAndroid || device == DeviceMobile
+}
+
+func containsAny(s string, subs ...string) bool {
+	for _, v := range subs {
+		if strings.Contains(s, v) {
+			return true
+		}
+	}
+	return false
+}
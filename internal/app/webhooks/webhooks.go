@@ -0,0 +1,384 @@
+// Package webhooks notifies endpoints registered by users about events of their links.
+package webhooks
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/config"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// Headers of delivery requests.
+const (
+	SignatureHeader = "X-Shortener-Signature"
+	EventHeader     = "X-Shortener-Event"
+	DeliveryHeader  = "X-Shortener-Delivery"
+	WebhookHeader   = "X-Shortener-Webhook"
+)
+
+const (
+	timeout = 10 * time.Second
+	// lease is how long a claimed delivery isn't claimed again, so a crash mid-delivery only delays it.
+	lease      = 3 * timeout
+	claimLimit = 100
+	maxBackoff = 6 * time.Hour
+	// cacheTTL bounds how long other instances of the service may miss webhook changes.
+	cacheTTL = 30 * time.Second
+	// maxCached bounds the number of users whose webhooks are cached, the least recently used are dropped.
+	maxCached = 10000
+	// retention is how long delivered and dead deliveries are kept.
+	retention     = 7 * 24 * time.Hour
+	pruneInterval = time.Hour
+)
+
+// Store keeps webhooks and their deliveries.
+type Store interface {
+	Webhooks(ctx context.Context, uid string) ([]models.Webhook, error)
+	Webhook(ctx context.Context, id string) (models.Webhook, error)
+	AddDeliveries(ctx context.Context, deliveries []models.Delivery) error
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.Delivery, error)
+	SaveDelivery(ctx context.Context, d models.Delivery) error
+	DeleteDeliveries(ctx context.Context, finishedBefore time.Time) (int64, error)
+}
+
+// Dispatcher queues deliveries of events in the store and sends them in the worker pool.
+// Failed deliveries are retried with exponential backoff until they run out of attempts and become dead.
+type Dispatcher struct {
+	context     context.Context
+	store       Store
+	pool        app.WorkerPool
+	client      *http.Client
+	logger      *zap.SugaredLogger
+	base        time.Duration
+	maxAttempts int
+	wake        chan struct{}
+
+	mu    sync.Mutex
+	cache map[string]*list.Element // of *cached
+	lru   *list.List               // of *cached, the most recently used first
+}
+
+type cached struct {
+	uid      string
+	webhooks []models.Webhook
+	expires  time.Time
+}
+
+// NewDispatcher creates dispatcher configured by WEBHOOK_* options, which sends deliveries until ctx is done.
+func NewDispatcher(ctx context.Context, store Store, pool app.WorkerPool, logger *zap.SugaredLogger) *Dispatcher {
+	base, err := time.ParseDuration(config.Config().WebhookRetryBase)
+	if err != nil || base <= 0 {
+		base = 10 * time.Second
+		logger.Errorf("error while reading config webhook retry base %q, using %v", config.Config().WebhookRetryBase, base)
+	}
+	maxAttempts, err := strconv.Atoi(config.Config().WebhookMaxAttempts)
+	if err != nil || maxAttempts <= 0 {
+		maxAttempts = 8
+		logger.Errorf("error while reading config webhook max attempts %q, using %d", config.Config().WebhookMaxAttempts, maxAttempts)
+	}
+
+	client := app.NewOutboundClient(timeout)
+	// a redirect is a failed delivery, the receiver should register the final address
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	d := &Dispatcher{
+		context:     ctx,
+		store:       store,
+		pool:        pool,
+		client:      client,
+		logger:      logger,
+		base:        base,
+		maxAttempts: maxAttempts,
+		wake:        make(chan struct{}, 1),
+		cache:       make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+	go d.run()
+	return d
+}
+
+// MaybeSubscribed reports whether the user may have a webhook notified about events of the type.
+// It never reads the store: it's false only if cached webhooks of the user rule the type out.
+func (d *Dispatcher) MaybeSubscribed(uid, eventType string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e, ok := d.cache[uid]
+	if !ok {
+		return true
+	}
+	c := e.Value.(*cached)
+	if !time.Now().Before(c.expires) {
+		return true
+	}
+	for _, w := range c.webhooks {
+		if w.Subscribed(eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish queues deliveries of the event to the user's webhooks subscribed to its type.
+func (d *Dispatcher) Publish(ctx context.Context, uid, eventType string, data models.EventData) error {
+	hooks, err := d.webhooks(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	payload, err := json.Marshal(models.Event{ID: uuid.NewString(), Type: eventType, CreatedAt: now, Data: data})
+	if err != nil {
+		return err
+	}
+
+	var deliveries []models.Delivery
+	for _, w := range hooks {
+		if !w.Subscribed(eventType) {
+			continue
+		}
+		deliveries = append(deliveries, models.Delivery{
+			ID:            uuid.NewString(),
+			WebhookID:     w.ID,
+			UID:           uid,
+			EventType:     eventType,
+			Payload:       payload,
+			Status:        models.DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	err = d.store.AddDeliveries(ctx, deliveries)
+	if err != nil {
+		return err
+	}
+	d.Wake()
+	return nil
+}
+
+// Wake makes the dispatcher look for due deliveries at once.
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Forget drops cached webhooks of the user after they change.
+func (d *Dispatcher) Forget(uid string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e, ok := d.cache[uid]; ok {
+		d.lru.Remove(e)
+		delete(d.cache, uid)
+	}
+}
+
+func (d *Dispatcher) webhooks(ctx context.Context, uid string) ([]models.Webhook, error) {
+	d.mu.Lock()
+	e, ok := d.cache[uid]
+	if ok {
+		d.lru.MoveToFront(e)
+		if c := e.Value.(*cached); time.Now().Before(c.expires) {
+			d.mu.Unlock()
+			return c.webhooks, nil
+		}
+	}
+	d.mu.Unlock()
+
+	hooks, err := d.store.Webhooks(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c := &cached{uid: uid, webhooks: hooks, expires: time.Now().Add(cacheTTL)}
+	if e, ok := d.cache[uid]; ok {
+		e.Value = c
+		d.lru.MoveToFront(e)
+		return hooks, nil
+	}
+	d.cache[uid] = d.lru.PushFront(c)
+	if d.lru.Len() > maxCached {
+		oldest := d.lru.Back()
+		d.lru.Remove(oldest)
+		delete(d.cache, oldest.Value.(*cached).uid)
+	}
+	return hooks, nil
+}
+
+func (d *Dispatcher) run() {
+	interval := time.Second
+	if d.base < interval {
+		interval = d.base
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	p := time.NewTicker(pruneInterval)
+	defer p.Stop()
+
+	d.prune()
+	for {
+		select {
+		case <-t.C:
+		case <-d.wake:
+		case <-p.C:
+			d.prune()
+			continue
+		case <-d.context.Done():
+			return
+		}
+		d.dispatch()
+	}
+}
+
+// prune deletes deliveries which were delivered or became dead longer than retention ago.
+func (d *Dispatcher) prune() {
+	n, err := d.store.DeleteDeliveries(d.context, time.Now().Add(-retention))
+	if err != nil {
+		if d.context.Err() == nil {
+			d.logger.Errorf("can't delete old webhook deliveries: %v", err)
+		}
+		return
+	}
+	if n > 0 {
+		d.logger.Infof("%d old webhook deliveries were deleted", n)
+	}
+}
+
+// dispatch claims due deliveries and submits them to the worker pool.
+func (d *Dispatcher) dispatch() {
+	deliveries, err := d.store.ClaimDeliveries(d.context, time.Now(), lease, claimLimit)
+	if err != nil {
+		if d.context.Err() == nil {
+			d.logger.Errorf("can't claim webhook deliveries: %v", err)
+		}
+		return
+	}
+
+	for _, dl := range deliveries {
+		dl := dl
+		err = d.pool.Submit(d.context, func(ctx context.Context) error {
+			return d.deliver(ctx, dl)
+		})
+		if err != nil {
+			// the rest is claimed again once the lease is over
+			if d.context.Err() == nil {
+				d.logger.Errorf("can't submit webhook deliveries: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// deliver sends the delivery and saves the outcome of the attempt.
+func (d *Dispatcher) deliver(ctx context.Context, dl models.Delivery) error {
+	w, err := d.store.Webhook(ctx, dl.WebhookID)
+	if errors.Is(err, app.ErrWebhookNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	status, err := d.send(ctx, w, dl)
+	if ctx.Err() != nil {
+		// stopped by shutdown, the delivery is claimed again once the lease is over
+		return nil
+	}
+
+	now := time.Now()
+	dl.Attempts++
+	dl.LastStatus, dl.LastError = status, ""
+	switch {
+	case err != nil:
+		dl.LastError = err.Error()
+	case status < 200 || status > 299:
+		dl.LastError = fmt.Sprintf("unexpected response status %d", status)
+	}
+
+	switch {
+	case dl.LastError == "":
+		dl.Status, dl.DeliveredAt = models.DeliveryDelivered, &now
+	case dl.Attempts >= d.maxAttempts:
+		dl.Status = models.DeliveryDead
+	default:
+		dl.NextAttemptAt = now.Add(d.backoff(dl.Attempts))
+	}
+
+	// the outcome is saved even if the dispatcher was stopped meanwhile
+	return d.store.SaveDelivery(context.Background(), dl)
+}
+
+// send posts the signed payload to the webhook and returns the response status.
+func (d *Dispatcher) send(ctx context.Context, w models.Webhook, dl models.Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, dl.EventType)
+	req.Header.Set(DeliveryHeader, dl.ID)
+	req.Header.Set(WebhookHeader, w.ID)
+	req.Header.Set(SignatureHeader, Signature(w.Secret, time.Now(), dl.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// draining a bit of the body lets the connection be reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before the attempt following the given number of attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	b := d.base
+	for i := 1; i < attempts && b < maxBackoff; i++ {
+		b *= 2
+	}
+	if b > maxBackoff {
+		b = maxBackoff
+	}
+	return b
+}
+
+// Signature returns the signature header of the body sent at t: "t=<unix time>,v1=<hex HMAC-SHA256>",
+// where the HMAC keyed by the webhook secret covers the time, a dot and the body.
+// Receivers should compare it in constant time and reject old timestamps to prevent replays.
+func Signature(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(ts + "."))
+	h.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(h.Sum(nil))
+}
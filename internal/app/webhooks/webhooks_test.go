@@ -0,0 +1,231 @@
+package webhooks
+
+import (
+	"container/list"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// memStore keeps webhooks and the last saved state of every delivery.
+type memStore struct {
+	mu         sync.Mutex
+	webhooks   map[string]models.Webhook
+	deliveries map[string]models.Delivery
+	reads      int
+}
+
+func newMemStore(hooks ...models.Webhook) *memStore {
+	s := &memStore{webhooks: make(map[string]models.Webhook), deliveries: make(map[string]models.Delivery)}
+	for _, w := range hooks {
+		s.webhooks[w.ID] = w
+	}
+	return s
+}
+
+func (s *memStore) Webhooks(_ context.Context, uid string) ([]models.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reads++
+	var res []models.Webhook
+	for _, w := range s.webhooks {
+		if w.UID == uid {
+			res = append(res, w)
+		}
+	}
+	return res, nil
+}
+
+func (s *memStore) Webhook(_ context.Context, id string) (models.Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w, ok := s.webhooks[id]
+	if !ok {
+		return models.Webhook{}, app.ErrWebhookNotFound
+	}
+	return w, nil
+}
+
+func (s *memStore) AddDeliveries(_ context.Context, deliveries []models.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, d := range deliveries {
+		s.deliveries[d.ID] = d
+	}
+	return nil
+}
+
+func (s *memStore) ClaimDeliveries(context.Context, time.Time, time.Duration, int) ([]models.Delivery, error) {
+	return nil, nil
+}
+
+func (s *memStore) SaveDelivery(ctx context.Context, d models.Delivery) error {
+	return s.AddDeliveries(ctx, []models.Delivery{d})
+}
+
+func (s *memStore) DeleteDeliveries(context.Context, time.Time) (int64, error) {
+	return 0, nil
+}
+
+func newTestDispatcher(store Store) *Dispatcher {
+	return &Dispatcher{
+		context:     context.Background(),
+		store:       store,
+		client:      &http.Client{},
+		logger:      zap.NewNop().Sugar(),
+		base:        time.Second,
+		maxAttempts: 2,
+		wake:        make(chan struct{}, 1),
+		cache:       make(map[string]*list.Element),
+		lru:         list.New(),
+	}
+}
+
+func TestSignature(t *testing.T) {
+	at := time.Unix(1633089600, 0)
+	body := []byte(`{"type":"link.created"}`)
+
+	sig := Signature("secret", at, body)
+	parts := strings.Split(sig, ",")
+	require.Len(t, parts, 2)
+	require.Equal(t, "t=1633089600", parts[0])
+
+	h := hmac.New(sha256.New, []byte("secret"))
+	h.Write([]byte("1633089600." + string(body)))
+	require.Equal(t, "v1="+hex.EncodeToString(h.Sum(nil)), parts[1])
+
+	require.NotEqual(t, sig, Signature("other", at, body))
+	require.NotEqual(t, sig, Signature("secret", at.Add(time.Second), body))
+}
+
+func TestBackoff(t *testing.T) {
+	d := newTestDispatcher(newMemStore())
+	require.Equal(t, time.Second, d.backoff(1))
+	require.Equal(t, 2*time.Second, d.backoff(2))
+	require.Equal(t, 8*time.Second, d.backoff(4))
+	require.Equal(t, maxBackoff, d.backoff(100))
+}
+
+func TestPublishAndCache(t *testing.T) {
+	store := newMemStore(
+		models.Webhook{ID: "clicks", UID: "user", Events: []string{models.EventLinkClicked}},
+		models.Webhook{ID: "all", UID: "user", Events: models.EventTypes},
+		models.Webhook{ID: "other", UID: "other", Events: models.EventTypes},
+	)
+	d := newTestDispatcher(store)
+	ctx := context.Background()
+
+	// nothing is known about the user before its webhooks are read
+	require.True(t, d.MaybeSubscribed("user", models.EventLinkCreated))
+
+	require.NoError(t, d.Publish(ctx, "user", models.EventLinkClicked, models.EventData{ShortURL: "http://localhost:8080/a"}))
+	require.Len(t, store.deliveries, 2)
+	require.NoError(t, d.Publish(ctx, "user", models.EventLinkCreated, models.EventData{ShortURL: "http://localhost:8080/a"}))
+	require.Len(t, store.deliveries, 3)
+	require.Equal(t, 1, store.reads)
+
+	delete(store.webhooks, "all")
+	require.True(t, d.MaybeSubscribed("user", models.EventLinkCreated))
+	d.Forget("user")
+	_, err := d.webhooks(ctx, "user")
+	require.NoError(t, err)
+	require.False(t, d.MaybeSubscribed("user", models.EventLinkCreated))
+	require.True(t, d.MaybeSubscribed("user", models.EventLinkClicked))
+}
+
+func TestCacheEviction(t *testing.T) {
+	store := newMemStore()
+	d := newTestDispatcher(store)
+	ctx := context.Background()
+
+	for i := 0; i < maxCached; i++ {
+		_, err := d.webhooks(ctx, strconv.Itoa(i))
+		require.NoError(t, err)
+	}
+	// the first user is used again, so the second one is the least recently used
+	_, err := d.webhooks(ctx, "0")
+	require.NoError(t, err)
+	_, err = d.webhooks(ctx, "new")
+	require.NoError(t, err)
+
+	require.Len(t, d.cache, maxCached)
+	require.Equal(t, maxCached, d.lru.Len())
+	require.Contains(t, d.cache, "0")
+	require.Contains(t, d.cache, "new")
+	require.NotContains(t, d.cache, "1")
+	require.False(t, d.MaybeSubscribed("0", models.EventLinkClicked))
+	require.True(t, d.MaybeSubscribed("1", models.EventLinkClicked))
+}
+
+func TestDeliver(t *testing.T) {
+	var mu sync.Mutex
+	statuses := []int{http.StatusInternalServerError, http.StatusNoContent}
+	var signatures []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		body, _ := ioutil.ReadAll(req.Body)
+		require.Equal(t, `{"id":"e"}`, string(body))
+		require.Equal(t, models.EventLinkCreated, req.Header.Get(EventHeader))
+		signatures = append(signatures, req.Header.Get(SignatureHeader))
+		w.WriteHeader(statuses[0])
+		statuses = statuses[1:]
+	}))
+	defer srv.Close()
+
+	w := models.Webhook{ID: "w", UID: "user", URL: srv.URL, Secret: "secret"}
+	store := newMemStore(w)
+	d := newTestDispatcher(store)
+	dl := models.Delivery{ID: "d", WebhookID: "w", EventType: models.EventLinkCreated, Payload: []byte(`{"id":"e"}`),
+		Status: models.DeliveryPending}
+
+	before := time.Now()
+	require.NoError(t, d.deliver(context.Background(), dl))
+	failed := store.deliveries["d"]
+	require.Equal(t, models.DeliveryPending, failed.Status)
+	require.Equal(t, 1, failed.Attempts)
+	require.Equal(t, http.StatusInternalServerError, failed.LastStatus)
+	require.Equal(t, "unexpected response status 500", failed.LastError)
+	require.WithinDuration(t, before.Add(d.base), failed.NextAttemptAt, time.Second)
+
+	require.NoError(t, d.deliver(context.Background(), failed))
+	delivered := store.deliveries["d"]
+	require.Equal(t, models.DeliveryDelivered, delivered.Status)
+	require.NotNil(t, delivered.DeliveredAt)
+	require.Empty(t, delivered.LastError)
+	require.Len(t, signatures, 2)
+	require.True(t, strings.HasPrefix(signatures[0], "t="))
+
+	// the last failed attempt makes the delivery dead
+	dl.ID, dl.Attempts = "dead", 1
+	w.URL = srv.URL + "/gone"
+	srv.Close()
+	require.NoError(t, d.deliver(context.Background(), dl))
+	require.Equal(t, models.DeliveryDead, store.deliveries["dead"].Status)
+	require.NotEmpty(t, store.deliveries["dead"].LastError)
+
+	// deliveries of deleted webhooks are dropped
+	delete(store.webhooks, "w")
+	dl.ID = "dropped"
+	require.NoError(t, d.deliver(context.Background(), dl))
+	require.NotContains(t, store.deliveries, "dropped")
+}
@@ -9,6 +9,9 @@ import (
 	"github.com/DrGermanius/Shortener/internal/app/config"
 )
 
+// queueSize bounds the number of tasks waiting for a worker.
+const queueSize = 1024
+
 type WorkerPool struct {
 	context context.Context
 	inputCh chan input
@@ -16,22 +19,20 @@ type WorkerPool struct {
 }
 
 type input struct {
-	uid      string
-	link     string
 	context  context.Context
-	function func(context.Context, string, string) error
+	function func(context.Context) error
 }
 
 func NewWorkerPool(context context.Context, logger *zap.SugaredLogger) WorkerPool {
 	wc, err := strconv.Atoi(config.Config().WorkersCount)
 	if err != nil {
 		wc = 10
-		logger.Errorf("error while reading config workers count: %f", err)
+		logger.Errorf("error while reading config workers count %q, using %d", config.Config().WorkersCount, wc)
 	}
 
 	wp := WorkerPool{
 		context: context,
-		inputCh: make(chan input, 10),
+		inputCh: make(chan input, queueSize),
 		logger:  logger,
 	}
 
@@ -42,15 +43,18 @@ func NewWorkerPool(context context.Context, logger *zap.SugaredLogger) WorkerPoo
 	return wp
 }
 
-func (p WorkerPool) StartDeleteWorker(uid string, links []string, function func(context.Context, string, string) error, context context.Context) {
-	for _, link := range links {
-		i := input{
-			uid:      uid,
-			link:     link,
-			function: function,
-			context:  context,
-		}
-		p.inputCh <- i
+// Submit queues the task without blocking the caller.
+// It returns ErrPoolBusy if the queue is full and the error of the pool context if the pool is stopped.
+func (p WorkerPool) Submit(ctx context.Context, task func(context.Context) error) error {
+	if err := p.context.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case p.inputCh <- input{context: ctx, function: task}:
+		return nil
+	default:
+		return ErrPoolBusy
 	}
 }
 
@@ -58,7 +62,7 @@ func (p WorkerPool) listen() {
 	for {
 		select {
 		case v := <-p.inputCh:
-			if err := v.function(v.context, v.uid, v.link); err != nil {
+			if err := v.function(v.context); err != nil {
 				p.logger.Error(err)
 			}
 		case <-p.context.Done():
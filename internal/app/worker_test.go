@@ -0,0 +1,22 @@
+package app
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestWorkerPoolSubmit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// no workers listen, so the queue is never drained
+	p := WorkerPool{context: ctx, inputCh: make(chan input, 1), logger: zap.NewNop().Sugar()}
+	task := func(context.Context) error { return nil }
+
+	require.NoError(t, p.Submit(ctx, task))
+	require.ErrorIs(t, p.Submit(ctx, task), ErrPoolBusy)
+
+	cancel()
+	require.ErrorIs(t, p.Submit(ctx, task), context.Canceled)
+}
@@ -2,22 +2,196 @@ package database
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 
 	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/hll"
 	"github.com/DrGermanius/Shortener/internal/app/models"
 )
 
 const (
-	linkFields      = "user_id, long_link, short_link"
-	insertLinkQuery = "INSERT INTO links  (" + linkFields + ") VALUES ( $1, $2, $3 )"
+	linkFields = "short_link, user_id, long_link, is_deleted, expires_at, max_clicks, clicks, password_hash, " +
+		"redirect_code, cache_policy, forward_query, query_conflict, forward_path, rules, active_from, active_until, " +
+		"title, preview, created_at, health, tags, folder, notes, metadata"
+	insertFields = "user_id, long_link, short_link, expires_at, max_clicks, password_hash, redirect_code, cache_policy, " +
+		"forward_query, query_conflict, forward_path, rules, active_from, active_until, title, preview, tags, folder, notes"
+	insertLinkQuery = "INSERT INTO links  (" + insertFields + ") " +
+		"VALUES ( $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19 )"
+	updateLinkQuery = "UPDATE links SET (" + insertFields + ", health, health_checked_at, metadata) = " +
+		"( $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22 ) " +
+		"WHERE short_link = $3"
+	jobFields           = "id, user_id, kind, status, total, processed, failed, error, results, created_at, finished_at"
+	insertRevisionQuery = "INSERT INTO link_revisions (short_link, revision, author, created_at, link) " +
+		"VALUES ( $1, $2, $3, $4, $5 )"
 )
 
+// snapshot is the stored state of a link revision.
+type snapshot struct {
+	models.LinkJSON
+	PasswordHash string `json:"password_hash,omitempty"`
+}
+
+// migrations bring tables created by earlier versions of the service up to date.
+var migrations = []string{
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ NULL",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS max_clicks BIGINT DEFAULT 0 NOT NULL",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS clicks BIGINT DEFAULT 0 NOT NULL",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS password_hash VARCHAR DEFAULT '' NOT NULL",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS redirect_code INT DEFAULT 0 NOT NULL",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS cache_policy VARCHAR DEFAULT '' NOT NULL",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS forward_query bool DEFAULT false NOT NULL",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS query_conflict VARCHAR DEFAULT '' NOT NULL",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS forward_path bool DEFAULT false NOT NULL",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS rules JSONB DEFAULT '[]' NOT NULL",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS active_from TIMESTAMPTZ NULL",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS active_until TIMESTAMPTZ NULL",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS title VARCHAR DEFAULT '' NOT NULL",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS preview bool DEFAULT false NOT NULL",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ DEFAULT now() NOT NULL",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS health JSONB NULL",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS health_checked_at TIMESTAMPTZ NULL",
+	"CREATE INDEX IF NOT EXISTS links_health_checked_at ON links (health_checked_at NULLS FIRST) WHERE is_deleted = false",
+	"CREATE TABLE IF NOT EXISTS link_revisions (" +
+		"short_link VARCHAR NOT NULL," +
+		"revision 	INT 	NOT NULL," +
+		"author 	VARCHAR ( 50 ) NOT NULL," +
+		"created_at TIMESTAMPTZ DEFAULT now() NOT NULL," +
+		"link 		JSONB 	NOT NULL," +
+		"PRIMARY KEY (short_link, revision)" +
+		")",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS tags JSONB DEFAULT '[]' NOT NULL",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS folder VARCHAR DEFAULT '' NOT NULL",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS notes VARCHAR DEFAULT '' NOT NULL",
+	"CREATE INDEX IF NOT EXISTS links_tags ON links USING GIN (tags)",
+	"ALTER TABLE links ADD COLUMN IF NOT EXISTS metadata JSONB NULL",
+	"CREATE UNIQUE INDEX IF NOT EXISTS links_short_link ON links (short_link)",
+	"CREATE TABLE IF NOT EXISTS jobs (" +
+		"id 		UUID 	PRIMARY KEY," +
+		"user_id 	VARCHAR ( 50 ) NOT NULL," +
+		"kind 		VARCHAR ( 20 ) NOT NULL," +
+		"status 	VARCHAR ( 20 ) NOT NULL," +
+		"total 		INT 	NOT NULL," +
+		"processed 	INT 	NOT NULL," +
+		"failed 	INT 	NOT NULL," +
+		"error 		VARCHAR NOT NULL," +
+		"results 	JSONB 	NOT NULL," +
+		"created_at TIMESTAMPTZ NOT NULL," +
+		"finished_at TIMESTAMPTZ NULL" +
+		")",
+	"CREATE INDEX IF NOT EXISTS jobs_finished_at ON jobs (finished_at)",
+	"CREATE TABLE IF NOT EXISTS clicks (" +
+		"short_link VARCHAR NOT NULL," +
+		"clicked_at TIMESTAMPTZ NOT NULL," +
+		"referrer 	VARCHAR NOT NULL," +
+		"referrer_host VARCHAR NOT NULL," +
+		"user_agent VARCHAR NOT NULL," +
+		"device 	VARCHAR ( 20 ) NOT NULL," +
+		"ip_hash 	VARCHAR ( 64 ) NOT NULL," +
+		"country 	VARCHAR ( 2 ) NOT NULL" +
+		")",
+	"CREATE INDEX IF NOT EXISTS clicks_short_link_clicked_at ON clicks (short_link, clicked_at)",
+	"CREATE TABLE IF NOT EXISTS click_rollups (" +
+		"short_link VARCHAR NOT NULL," +
+		"granularity VARCHAR ( 10 ) NOT NULL," +
+		"bucket 	TIMESTAMPTZ NOT NULL," +
+		"dimension 	VARCHAR ( 10 ) NOT NULL," +
+		"value 		VARCHAR NOT NULL," +
+		"clicks 	BIGINT 	NOT NULL," +
+		"PRIMARY KEY (short_link, granularity, bucket, dimension, value)" +
+		")",
+	"CREATE INDEX IF NOT EXISTS click_rollups_granularity_bucket ON click_rollups (granularity, bucket)",
+	"CREATE TABLE IF NOT EXISTS visitor_sketches (" +
+		"short_link VARCHAR NOT NULL," +
+		"bucket 	TIMESTAMPTZ NOT NULL," +
+		"sketch 	BYTEA 	NOT NULL," +
+		"PRIMARY KEY (short_link, bucket)" +
+		")",
+	"CREATE INDEX IF NOT EXISTS visitor_sketches_bucket ON visitor_sketches (bucket)",
+	"CREATE TABLE IF NOT EXISTS webhooks (" +
+		"id 		UUID 	PRIMARY KEY," +
+		"user_id 	VARCHAR ( 50 ) NOT NULL," +
+		"url 		VARCHAR NOT NULL," +
+		"events 	JSONB 	NOT NULL," +
+		"secret 	VARCHAR NOT NULL," +
+		"created_at TIMESTAMPTZ NOT NULL" +
+		")",
+	"CREATE INDEX IF NOT EXISTS webhooks_user_id ON webhooks (user_id)",
+	"CREATE TABLE IF NOT EXISTS webhook_deliveries (" +
+		"id 		UUID 	PRIMARY KEY," +
+		"webhook_id UUID 	NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE," +
+		"user_id 	VARCHAR ( 50 ) NOT NULL," +
+		"event_type VARCHAR ( 20 ) NOT NULL," +
+		"payload 	JSONB 	NOT NULL," +
+		"status 	VARCHAR ( 20 ) NOT NULL," +
+		"attempts 	INT 	NOT NULL," +
+		"last_status INT 	NOT NULL," +
+		"last_error VARCHAR NOT NULL," +
+		"next_attempt_at TIMESTAMPTZ NOT NULL," +
+		"created_at TIMESTAMPTZ NOT NULL," +
+		"delivered_at TIMESTAMPTZ NULL" +
+		")",
+	"CREATE INDEX IF NOT EXISTS webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending'",
+	"CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id, created_at)",
+	"CREATE INDEX IF NOT EXISTS webhook_deliveries_finished ON webhook_deliveries " +
+		"(COALESCE(delivered_at, next_attempt_at)) WHERE status <> 'pending'",
+	// link totals are kept by a trigger, so reading them doesn't scan the links
+	"CREATE INDEX IF NOT EXISTS links_created_at ON links (created_at)",
+	// the totals are spread over 16 rows updated at random, so concurrent writes don't queue on a single row
+	"CREATE TABLE IF NOT EXISTS link_counter_shards (" +
+		"shard 		SMALLINT PRIMARY KEY," +
+		"urls 		BIGINT 	NOT NULL," +
+		"deleted 	BIGINT 	NOT NULL," +
+		"users 		BIGINT 	NOT NULL" +
+		")",
+	"CREATE TABLE IF NOT EXISTS link_users (" +
+		"user_id 	VARCHAR ( 50 ) PRIMARY KEY" +
+		")",
+	"CREATE OR REPLACE FUNCTION count_links() RETURNS trigger AS $$ " +
+		"DECLARE s smallint := floor(random() * 16); " +
+		"BEGIN " +
+		"IF TG_OP = 'INSERT' THEN " +
+		"UPDATE link_counter_shards SET urls = urls + (NOT NEW.is_deleted)::int, deleted = deleted + NEW.is_deleted::int " +
+		"WHERE shard = s; " +
+		"IF NEW.user_id <> '' THEN " +
+		"INSERT INTO link_users (user_id) VALUES (NEW.user_id) ON CONFLICT DO NOTHING; " +
+		"IF FOUND THEN UPDATE link_counter_shards SET users = users + 1 WHERE shard = s; END IF; " +
+		"END IF; " +
+		"ELSIF NEW.is_deleted <> OLD.is_deleted THEN " +
+		"UPDATE link_counter_shards SET urls = urls + OLD.is_deleted::int - NEW.is_deleted::int, " +
+		"deleted = deleted + NEW.is_deleted::int - OLD.is_deleted::int WHERE shard = s; " +
+		"END IF; " +
+		"RETURN NULL; " +
+		"END $$ LANGUAGE plpgsql",
+	// the totals of existing links are counted, in the first shard, and the trigger is created once in a single
+	// transaction; writes of links wait for it, so none of them is left out or counted twice,
+	// and a restart doesn't lock the links to replace the trigger
+	"DO $$ BEGIN " +
+		"IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'links_count' AND tgrelid = 'links'::regclass) THEN " +
+		"LOCK TABLE links IN SHARE MODE; " +
+		"DELETE FROM link_users; " +
+		"DELETE FROM link_counter_shards; " +
+		"INSERT INTO link_users (user_id) SELECT DISTINCT user_id FROM links WHERE user_id <> ''; " +
+		"INSERT INTO link_counter_shards (shard, urls, deleted, users) SELECT s, " +
+		"CASE WHEN s = 0 THEN t.urls ELSE 0 END, CASE WHEN s = 0 THEN t.deleted ELSE 0 END, " +
+		"CASE WHEN s = 0 THEN t.users ELSE 0 END " +
+		"FROM generate_series(0, 15) s, (SELECT count(*) FILTER (WHERE NOT is_deleted) urls, " +
+		"count(*) FILTER (WHERE is_deleted) deleted, (SELECT count(*) FROM link_users) users FROM links) t; " +
+		"CREATE TRIGGER links_count AFTER INSERT OR UPDATE OF is_deleted ON links FOR EACH ROW EXECUTE FUNCTION count_links(); " +
+		"END IF; " +
+		"END $$",
+}
+
 type DB struct {
 	conn *pgxpool.Pool
 }
@@ -33,52 +207,217 @@ func NewDatabaseStore(connString string) (*DB, error) {
 		return nil, err
 	}
 
+	err = migrate(conn)
+	if err != nil {
+		return nil, err
+	}
+
 	return &DB{conn: conn}, nil
 }
 
-func (d *DB) Get(ctx context.Context, short string) (string, error) {
-	var long string
-	var isDel bool
+func (d *DB) Get(ctx context.Context, short string) (models.LinkInfo, error) {
+	l, err := d.Lookup(ctx, short)
+	if err != nil {
+		return models.LinkInfo{}, err
+	}
+
+	err = app.CheckLink(l, time.Now())
+	if err != nil {
+		return models.LinkInfo{}, err
+	}
+
+	return l, nil
+}
+
+// HealthTargets returns up to limit followable links which weren't checked since checkedBefore, least recent first.
+func (d *DB) HealthTargets(ctx context.Context, checkedBefore time.Time, limit int) ([]models.HealthTarget, error) {
+	rows, err := d.conn.Query(ctx, "SELECT short_link, long_link FROM links WHERE is_deleted = false "+
+		"AND (health_checked_at IS NULL OR health_checked_at < $1) ORDER BY health_checked_at NULLS FIRST LIMIT $2",
+		checkedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	row := d.conn.QueryRow(ctx, "SELECT long_link, is_deleted FROM links where short_link = $1", short)
+	var targets []models.HealthTarget
+	for rows.Next() {
+		var t models.HealthTarget
+		err = rows.Scan(&t.Short, &t.Long)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+
+	return targets, rows.Err()
+}
+
+// SetHealth records the result of a destination check of the link.
+func (d *DB) SetHealth(ctx context.Context, short string, health models.LinkHealth) error {
+	b, err := json.Marshal(health)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.conn.Exec(ctx, "UPDATE links SET health = $2, health_checked_at = $3 WHERE short_link = $1",
+		short, string(b), health.CheckedAt)
+	return err
+}
+
+// SetMetadata saves metadata of the destination page unless the link has got another destination meanwhile.
+func (d *DB) SetMetadata(ctx context.Context, short, long string, m models.LinkMetadata) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.conn.Exec(ctx, "UPDATE links SET metadata = $3 WHERE short_link = $1 AND long_link = $2", short, long, string(b))
+	return err
+}
 
-	err := row.Scan(&long, &isDel)
+// FindLink returns the short code of the link of the address.
+func (d *DB) FindLink(ctx context.Context, long string) (string, error) {
+	var short string
+	err := d.conn.QueryRow(ctx, "SELECT short_link FROM links WHERE long_link = $1", long).Scan(&short)
 	if errors.Is(err, pgx.ErrNoRows) {
 		return "", app.ErrLinkNotFound
 	}
+	return short, err
+}
+
+// Lookup returns the link regardless of whether it can be followed.
+func (d *DB) Lookup(ctx context.Context, short string) (models.LinkInfo, error) {
+	row := d.conn.QueryRow(ctx, "SELECT "+linkFields+" FROM links where short_link = $1", short)
+
+	_, l, err := scanLink(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.LinkInfo{}, app.ErrLinkNotFound
+	}
 	if err != nil {
-		return "", err
+		return models.LinkInfo{}, err
 	}
 
-	if isDel {
-		return "", app.ErrDeletedLink
+	return l, nil
+}
+
+// Update applies change to the user's link and saves the result as a new revision.
+// The state before the first change is saved as revision 1.
+func (d *DB) Update(ctx context.Context, uid string, short string, change func(*models.LinkInfo) error) (models.LinkInfo, error) {
+	tx, err := d.conn.Begin(ctx)
+	if err != nil {
+		return models.LinkInfo{}, err
 	}
+	defer tx.Rollback(ctx)
 
-	return long, nil
-}
+	row := tx.QueryRow(ctx, "SELECT "+linkFields+" FROM links WHERE short_link = $1 AND user_id = $2 FOR UPDATE", short, uid)
+	_, l, err := scanLink(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.LinkInfo{}, app.ErrLinkNotFound
+	}
+	if err != nil {
+		return models.LinkInfo{}, err
+	}
+	if l.IsDeleted {
+		return models.LinkInfo{}, app.ErrDeletedLink
+	}
 
-func (d *DB) GetByUserID(ctx context.Context, id string) ([]models.LinkJSON, error) {
-	var links []models.LinkJSON
+	var last int
+	err = tx.QueryRow(ctx, "SELECT COALESCE(MAX(revision), 0) FROM link_revisions WHERE short_link = $1", short).Scan(&last)
+	if err != nil {
+		return models.LinkInfo{}, err
+	}
+	if last == 0 {
+		last = 1
+		_, err = tx.Exec(ctx, insertRevisionQuery, revisionArgs(short, models.Revision{Number: last, Author: l.UUID, CreatedAt: l.CreatedAt, Link: l})...)
+		if err != nil {
+			return models.LinkInfo{}, err
+		}
+	}
+
+	err = change(&l)
+	if err != nil {
+		return models.LinkInfo{}, err
+	}
 
-	rows, err := d.conn.Query(ctx, "SELECT "+linkFields+" FROM links where user_id = $1", id)
+	var checkedAt *time.Time
+	if l.Health != nil {
+		checkedAt = &l.Health.CheckedAt
+	}
+	_, err = tx.Exec(ctx, updateLinkQuery, append(insertArgs(l.UUID, l.Long, short, l.Options()),
+		nullableJSON(l.Health), checkedAt, nullableJSON(l.Metadata))...)
+	if err != nil {
+		return models.LinkInfo{}, uniqueViolation(err)
+	}
+
+	_, err = tx.Exec(ctx, insertRevisionQuery, revisionArgs(short, models.Revision{Number: last + 1, Author: uid, CreatedAt: time.Now(), Link: l})...)
+	if err != nil {
+		return models.LinkInfo{}, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return models.LinkInfo{}, err
+	}
+	return l, nil
+}
+
+// Revisions returns saved revisions of the link, oldest first.
+func (d *DB) Revisions(ctx context.Context, short string) ([]models.Revision, error) {
+	rows, err := d.conn.Query(ctx, "SELECT revision, author, created_at, link FROM link_revisions "+
+		"WHERE short_link = $1 ORDER BY revision", short)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	var revisions []models.Revision
 	for rows.Next() {
-		var l models.LinkJSON
-		err = rows.Scan(&l.UUID, &l.Long, &l.Short)
-		l.Short = app.FullLink(l.Short)
+		var r models.Revision
+		var link []byte
+		err = rows.Scan(&r.Number, &r.Author, &r.CreatedAt, &link)
+		if err != nil {
+			return nil, err
+		}
 
+		var snap snapshot
+		err = json.Unmarshal(link, &snap)
 		if err != nil {
 			return nil, err
 		}
+		r.Link = snap.Info()
+		r.Link.PasswordHash = snap.PasswordHash
+		revisions = append(revisions, r)
+	}
+
+	return revisions, rows.Err()
+}
+
+// ConsumeClick atomically counts a redirect against the link click limit.
+func (d *DB) ConsumeClick(ctx context.Context, short string) error {
+	tag, err := d.conn.Exec(ctx, "UPDATE links SET clicks = clicks + 1 WHERE short_link = $1 AND is_deleted = false "+
+		"AND (max_clicks = 0 OR clicks < max_clicks) AND (expires_at IS NULL OR expires_at > now()) "+
+		"AND (active_from IS NULL OR active_from <= now()) AND (active_until IS NULL OR active_until > now())", short)
+	if err != nil {
+		return err
+	}
 
-		links = append(links, l)
+	if tag.RowsAffected() == 0 {
+		_, err = d.Get(ctx, short)
+		if err != nil {
+			return err
+		}
+		return app.ErrExhaustedLink
 	}
 
-	err = rows.Err()
+	return nil
+}
+
+func (d *DB) GetByUserID(ctx context.Context, id string, filter models.LinkFilter) ([]models.LinkJSON, error) {
+	var links []models.LinkJSON
+	err := d.EachByUserID(ctx, id, filter, func(j models.LinkJSON) error {
+		links = append(links, j)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -90,31 +429,91 @@ func (d *DB) GetByUserID(ctx context.Context, id string) ([]models.LinkJSON, err
 	return links, nil
 }
 
-func (d *DB) Write(ctx context.Context, uuid, long string) (string, error) {
-	short := app.ShortLink([]byte(long))
+// EachByUserID calls fn for every user's link matching the filter in creation order until fn fails.
+// Rows are read from the cursor one by one, so the links are never loaded at once.
+func (d *DB) EachByUserID(ctx context.Context, id string, filter models.LinkFilter, fn func(models.LinkJSON) error) error {
+	where, args := filterCondition(filter, id)
+	rows, err := d.conn.Query(ctx, "SELECT "+linkFields+" FROM links where user_id = $1"+where+" ORDER BY id", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 
-	_, err := d.conn.Exec(ctx, insertLinkQuery, uuid, long, short)
+	for rows.Next() {
+		short, l, err := scanLink(rows)
+		if err != nil {
+			return err
+		}
 
-	if err != nil {
-		pgErr := new(pgconn.PgError)
-		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
-			return short, app.ErrLinkAlreadyExists
+		err = fn(l.JSON(app.FullLink(short)))
+		if err != nil {
+			return err
 		}
-		return "", err
 	}
 
-	return short, nil
+	return rows.Err()
 }
 
+// Write saves a new link of the address; if the address already has a link, its short code is returned
+// with ErrLinkAlreadyExists and the link is left as is.
+func (d *DB) Write(ctx context.Context, uuid, long string, opts models.LinkOptions) (string, error) {
+	short := app.ShortCode(long, opts)
+
+	for n := 1; ; n++ {
+		_, err := d.conn.Exec(ctx, insertLinkQuery, insertArgs(uuid, long, short, opts)...)
+		if err == nil {
+			return short, nil
+		}
+
+		err = uniqueViolation(err)
+		switch {
+		case errors.Is(err, app.ErrLinkAlreadyExists):
+			// the short code isn't derived from the address once the destination of a link has been edited
+			short, lookupErr := d.FindLink(ctx, long)
+			if lookupErr != nil {
+				return "", lookupErr
+			}
+			return short, err
+		case errors.Is(err, app.ErrAliasTaken) && opts.Alias == "":
+			// the short representation belongs to a link whose destination has been edited
+			if n == app.MaxShortLinkProbes {
+				return "", app.ErrNoFreeShortLink
+			}
+			short = app.AltShortLink(long, n)
+		default:
+			return "", err
+		}
+	}
+}
+
+// Delete marks the user's link as deleted; it returns ErrLinkNotFound or ErrNotLinkOwner if there is nothing to delete.
 func (d *DB) Delete(ctx context.Context, uid string, link string) error {
-	_, err := d.conn.Exec(ctx, "UPDATE links SET is_deleted = true WHERE user_id = $1 AND short_link = $2", uid, link)
+	tag, err := d.conn.Exec(ctx, "UPDATE links SET is_deleted = true WHERE user_id = $1 AND short_link = $2", uid, link)
 	if err != nil {
 		return err
 	}
 
+	if tag.RowsAffected() == 0 {
+		_, err = d.Lookup(ctx, link)
+		if err != nil {
+			return err
+		}
+		return app.ErrNotLinkOwner
+	}
+
 	return nil
 }
 
+// DeleteExpired marks every expired link as deleted and returns the number of affected links.
+func (d *DB) DeleteExpired(ctx context.Context) (int64, error) {
+	tag, err := d.conn.Exec(ctx, "UPDATE links SET is_deleted = true WHERE is_deleted = false AND expires_at <= now()")
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
 func (d *DB) BatchWrite(ctx context.Context, uid string, originals []models.BatchOriginal) ([]string, error) {
 	conn, err := d.conn.Acquire(ctx)
 	if err != nil {
@@ -124,7 +523,7 @@ func (d *DB) BatchWrite(ctx context.Context, uid string, originals []models.Batc
 
 	shorts := make([]string, 0, len(originals))
 	for _, v := range originals {
-		shorts = append(shorts, app.ShortLink([]byte(v.OriginalURL)))
+		shorts = append(shorts, app.ShortCode(v.OriginalURL, v.LinkOptions))
 	}
 
 	tx, err := conn.BeginTx(ctx, pgx.TxOptions{})
@@ -139,11 +538,14 @@ func (d *DB) BatchWrite(ctx context.Context, uid string, originals []models.Batc
 	}
 
 	for i, v := range originals {
-		rows, err := tx.Query(ctx, "batch-insert", uid, v.OriginalURL, shorts[i])
+		rows, err := tx.Query(ctx, "batch-insert", insertArgs(uid, v.OriginalURL, shorts[i], v.LinkOptions)...)
 		if err != nil {
 			return nil, err
 		}
 		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, uniqueViolation(err)
+		}
 	}
 	err = tx.Commit(ctx)
 	if err != nil {
@@ -153,6 +555,291 @@ func (d *DB) BatchWrite(ctx context.Context, uid string, originals []models.Batc
 	return shorts, nil
 }
 
+// AddClicks copies the clicks into the clicks table and adds them to the rollups in the same transaction.
+func (d *DB) AddClicks(ctx context.Context, clicks []models.Click) error {
+	rows := make([][]interface{}, len(clicks))
+	counts := make(map[string]map[models.RollupKey]int64)
+	for i, c := range clicks {
+		rows[i] = []interface{}{c.Short, c.Time, c.Referrer, c.ReferrerHost(), c.UserAgent, c.Device(), c.IPHash, c.Country}
+
+		linkCounts, ok := counts[c.Short]
+		if !ok {
+			linkCounts = make(map[models.RollupKey]int64)
+			counts[c.Short] = linkCounts
+		}
+		models.AddRollups(linkCounts, c)
+	}
+
+	tx, err := d.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"clicks"},
+		[]string{"short_link", "clicked_at", "referrer", "referrer_host", "user_agent", "device", "ip_hash", "country"},
+		pgx.CopyFromRows(rows))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, "INSERT INTO click_rollups (short_link, granularity, bucket, dimension, value, clicks) "+
+		"SELECT * FROM unnest($1::varchar[], $2::varchar[], $3::timestamptz[], $4::varchar[], $5::varchar[], $6::bigint[]) "+
+		"ON CONFLICT (short_link, granularity, bucket, dimension, value) DO UPDATE SET clicks = click_rollups.clicks + EXCLUDED.clicks",
+		rollupArgs(counts)...)
+	if err != nil {
+		return err
+	}
+
+	err = mergeVisitorSketches(ctx, tx, clicks)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// mergeVisitorSketches adds visitors of the clicks to the stored sketches.
+// Sketches are merged under row locks taken in the same order by every transaction.
+func mergeVisitorSketches(ctx context.Context, tx pgx.Tx, clicks []models.Click) error {
+	sketches := make(map[string]map[time.Time]*hll.Sketch)
+	for _, c := range clicks {
+		linkSketches, ok := sketches[c.Short]
+		if !ok {
+			linkSketches = make(map[time.Time]*hll.Sketch)
+			sketches[c.Short] = linkSketches
+		}
+		models.AddVisitor(linkSketches, c)
+	}
+
+	type linkSketch struct {
+		short string
+		models.VisitorSketch
+	}
+	var added []linkSketch
+	for short, linkSketches := range sketches {
+		for bucket, s := range linkSketches {
+			added = append(added, linkSketch{short: short, VisitorSketch: models.VisitorSketch{Bucket: bucket, Sketch: s}})
+		}
+	}
+	sort.Slice(added, func(i, j int) bool {
+		if added[i].short != added[j].short {
+			return added[i].short < added[j].short
+		}
+		return added[i].Bucket.Before(added[j].Bucket)
+	})
+
+	empty, err := hll.New().MarshalBinary()
+	if err != nil {
+		return err
+	}
+	for _, a := range added {
+		// the no-op update locks the existing row and returns its sketch
+		var b []byte
+		err = tx.QueryRow(ctx, "INSERT INTO visitor_sketches (short_link, bucket, sketch) VALUES ( $1, $2, $3 ) "+
+			"ON CONFLICT (short_link, bucket) DO UPDATE SET sketch = visitor_sketches.sketch RETURNING sketch",
+			a.short, a.Bucket, empty).Scan(&b)
+		if err != nil {
+			return err
+		}
+
+		stored := hll.New()
+		err = stored.UnmarshalBinary(b)
+		if err != nil {
+			return err
+		}
+		stored.Merge(a.Sketch)
+
+		b, err = stored.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, "UPDATE visitor_sketches SET sketch = $3 WHERE short_link = $1 AND bucket = $2", a.short, a.Bucket, b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VisitorSketches returns visitor sketches of the link for buckets starting within [from, to).
+func (d *DB) VisitorSketches(ctx context.Context, short string, from, to time.Time) ([]models.VisitorSketch, error) {
+	rows, err := d.conn.Query(ctx, "SELECT bucket, sketch FROM visitor_sketches "+
+		"WHERE short_link = $1 AND bucket >= $2 AND bucket < $3", short, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []models.VisitorSketch
+	for rows.Next() {
+		v := models.VisitorSketch{Sketch: hll.New()}
+		var b []byte
+		err = rows.Scan(&v.Bucket, &b)
+		if err != nil {
+			return nil, err
+		}
+		err = v.Sketch.UnmarshalBinary(b)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return res, rows.Err()
+}
+
+// ClickRollups returns counters of the link for buckets of the interval starting within [from, to).
+func (d *DB) ClickRollups(ctx context.Context, short, interval string, from, to time.Time) ([]models.ClickRollup, error) {
+	rows, err := d.conn.Query(ctx, "SELECT bucket, dimension, value, clicks FROM click_rollups "+
+		"WHERE short_link = $1 AND granularity = $2 AND bucket >= $3 AND bucket < $4", short, interval, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []models.ClickRollup
+	for rows.Next() {
+		r := models.ClickRollup{RollupKey: models.RollupKey{Interval: interval}}
+		err = rows.Scan(&r.Bucket, &r.Dimension, &r.Value, &r.Clicks)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+	return res, rows.Err()
+}
+
+// DeleteRollups deletes rollups of the interval for buckets starting before the time.
+func (d *DB) DeleteRollups(ctx context.Context, interval string, before time.Time) (int64, error) {
+	tag, err := d.conn.Exec(ctx, "DELETE FROM click_rollups WHERE granularity = $1 AND bucket < $2", interval, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// DeleteVisitorSketches deletes visitor sketches for buckets starting before the time.
+func (d *DB) DeleteVisitorSketches(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := d.conn.Exec(ctx, "DELETE FROM visitor_sketches WHERE bucket < $1", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// ClickStats sums daily rollups of the link, so it doesn't depend on the number of clicks.
+func (d *DB) ClickStats(ctx context.Context, short string) (models.LinkStats, error) {
+	var stats models.LinkStats
+	err := d.conn.QueryRow(ctx, "SELECT min(clicked_at), max(clicked_at) FROM clicks WHERE short_link = $1", short).
+		Scan(&stats.FirstClick, &stats.LastClick)
+	if err != nil {
+		return models.LinkStats{}, err
+	}
+
+	rows, err := d.conn.Query(ctx, "SELECT dimension, value, sum(clicks) FROM click_rollups "+
+		"WHERE short_link = $1 AND granularity = $2 AND dimension IN ($3, $4, $5, $6) GROUP BY dimension, value",
+		short, models.IntervalDay, models.DimensionTotal, models.DimensionReferrer, models.DimensionCountry, models.DimensionDevice)
+	if err != nil {
+		return models.LinkStats{}, err
+	}
+	defer rows.Close()
+
+	counts := map[string]map[string]int64{
+		models.DimensionReferrer: {},
+		models.DimensionCountry:  {},
+		models.DimensionDevice:   {},
+	}
+	for rows.Next() {
+		var dimension, value string
+		var n int64
+		err = rows.Scan(&dimension, &value, &n)
+		if err != nil {
+			return models.LinkStats{}, err
+		}
+		if dimension == models.DimensionTotal {
+			stats.Clicks = n
+			continue
+		}
+		counts[dimension][value] = n
+	}
+	if err = rows.Err(); err != nil {
+		return models.LinkStats{}, err
+	}
+
+	stats.Referrers = models.TopStats(counts[models.DimensionReferrer])
+	stats.Countries = models.TopStats(counts[models.DimensionCountry])
+	stats.Devices = models.TopStats(counts[models.DimensionDevice])
+	return stats, nil
+}
+
+// InternalStats returns totals of the links kept by the links_count trigger, summing up their shards.
+func (d *DB) InternalStats(ctx context.Context) (models.InternalStats, error) {
+	var s models.InternalStats
+	err := d.conn.QueryRow(ctx, "SELECT COALESCE(sum(urls), 0)::bigint, COALESCE(sum(users), 0)::bigint, "+
+		"COALESCE(sum(deleted), 0)::bigint, "+
+		"(SELECT count(*) FROM links WHERE created_at > now() - interval '24 hours') FROM link_counter_shards").
+		Scan(&s.URLs, &s.Users, &s.DeletedURLs, &s.CreatedLast24h)
+	return s, err
+}
+
+// SaveJob inserts the job or replaces its stored state.
+func (d *DB) SaveJob(ctx context.Context, job models.Job) error {
+	_, err := d.conn.Exec(ctx, "INSERT INTO jobs ("+jobFields+") VALUES ( $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11 ) "+
+		"ON CONFLICT (id) DO UPDATE SET (status, processed, failed, error, results, finished_at) = "+
+		"(EXCLUDED.status, EXCLUDED.processed, EXCLUDED.failed, EXCLUDED.error, EXCLUDED.results, EXCLUDED.finished_at)",
+		job.ID, job.UID, job.Kind, job.Status, job.Total, job.Processed, job.Failed, job.Error, jsonArg(job.Results),
+		job.CreatedAt, job.FinishedAt)
+	return err
+}
+
+func (d *DB) GetJob(ctx context.Context, id string) (models.Job, error) {
+	// ids which aren't UUIDs can't match, and comparing the column itself keeps the primary key index in use
+	jobID, err := uuid.Parse(id)
+	if err != nil {
+		return models.Job{}, app.ErrJobNotFound
+	}
+
+	var j models.Job
+	var results []byte
+	err = d.conn.QueryRow(ctx, "SELECT "+jobFields+" FROM jobs WHERE id = $1", jobID.String()).Scan(&j.ID, &j.UID, &j.Kind,
+		&j.Status, &j.Total, &j.Processed, &j.Failed, &j.Error, &results, &j.CreatedAt, &j.FinishedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Job{}, app.ErrJobNotFound
+	}
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	err = json.Unmarshal(results, &j.Results)
+	if err != nil {
+		return models.Job{}, err
+	}
+	return j, nil
+}
+
+// InterruptJobs marks every unfinished job as failed and returns the number of affected jobs.
+func (d *DB) InterruptJobs(ctx context.Context) (int64, error) {
+	tag, err := d.conn.Exec(ctx, "UPDATE jobs SET status = $1, error = $2, finished_at = now() WHERE status IN ($3, $4)",
+		models.JobFailed, app.ErrJobInterrupted.Error(), models.JobQueued, models.JobRunning)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// DeleteJobs deletes jobs finished before the time and returns the number of deleted jobs.
+func (d *DB) DeleteJobs(ctx context.Context, finishedBefore time.Time) (int64, error) {
+	tag, err := d.conn.Exec(ctx, "DELETE FROM jobs WHERE finished_at < $1", finishedBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
 func (d *DB) Ping(ctx context.Context) bool {
 	return d.conn.Ping(ctx) == nil
 }
@@ -175,6 +862,27 @@ func createDatabaseAndTable(c *pgxpool.Pool) error {
 			"long_link  VARCHAR  		NOT NULL,"+
 			"short_link VARCHAR  		NOT NULL,"+
 			"is_deleted bool DEFAULT false  	NOT NULL,"+
+			"expires_at TIMESTAMPTZ 		NULL,"+
+			"max_clicks BIGINT DEFAULT 0 	NOT NULL,"+
+			"clicks 	BIGINT DEFAULT 0 	NOT NULL,"+
+			"password_hash VARCHAR DEFAULT '' NOT NULL,"+
+			"redirect_code INT DEFAULT 0 	NOT NULL,"+
+			"cache_policy VARCHAR DEFAULT '' NOT NULL,"+
+			"forward_query bool DEFAULT false NOT NULL,"+
+			"query_conflict VARCHAR DEFAULT '' NOT NULL,"+
+			"forward_path bool DEFAULT false NOT NULL,"+
+			"rules JSONB DEFAULT '[]' NOT NULL,"+
+			"active_from TIMESTAMPTZ 		NULL,"+
+			"active_until TIMESTAMPTZ 		NULL,"+
+			"title VARCHAR DEFAULT '' 		NOT NULL,"+
+			"preview bool DEFAULT false 	NOT NULL,"+
+			"created_at TIMESTAMPTZ DEFAULT now() NOT NULL,"+
+			"health JSONB 					NULL,"+
+			"health_checked_at TIMESTAMPTZ 	NULL,"+
+			"tags JSONB DEFAULT '[]' 		NOT NULL,"+
+			"folder VARCHAR DEFAULT '' 		NOT NULL,"+
+			"notes VARCHAR DEFAULT '' 		NOT NULL,"+
+			"metadata JSONB 				NULL,"+
 			"UNIQUE(long_link)"+
 			");")
 		if err != nil {
@@ -184,3 +892,165 @@ func createDatabaseAndTable(c *pgxpool.Pool) error {
 
 	return nil
 }
+
+// jsonArg encodes value for a JSONB column; nil slices are stored as empty arrays.
+func jsonArg(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil || string(b) == "null" {
+		return "[]"
+	}
+	return string(b)
+}
+
+// uniqueViolation converts unique constraint violations into ErrAliasTaken or ErrLinkAlreadyExists.
+func uniqueViolation(err error) error {
+	pgErr := new(pgconn.PgError)
+	if !errors.As(err, &pgErr) || pgErr.Code != pgerrcode.UniqueViolation {
+		return err
+	}
+	if pgErr.ConstraintName == "links_short_link" {
+		return app.ErrAliasTaken
+	}
+	return app.ErrLinkAlreadyExists
+}
+
+// nullableJSON encodes value for a nullable JSONB column; nil pointers are stored as NULL.
+func nullableJSON(v interface{}) *string {
+	b, err := json.Marshal(v)
+	if err != nil || string(b) == "null" {
+		return nil
+	}
+	s := string(b)
+	return &s
+}
+
+// insertArgs returns arguments of insertLinkQuery.
+func insertArgs(uid, long, short string, opts models.LinkOptions) []interface{} {
+	return []interface{}{uid, long, short, opts.ExpiresAt, opts.MaxClicks, opts.PasswordHash,
+		opts.RedirectCode, opts.CachePolicy, opts.ForwardQuery, opts.QueryConflict, opts.ForwardPath, jsonArg(opts.Rules),
+		opts.ActiveFrom, opts.ActiveUntil, opts.Title, opts.Preview, jsonArg(opts.Tags), opts.Folder, opts.Notes}
+}
+
+// filterCondition returns conditions selecting links matching the filter, to be appended to the user_id = $1
+// condition, and all query arguments starting with uid.
+func filterCondition(filter models.LinkFilter, uid string) (string, []interface{}) {
+	var where strings.Builder
+	args := []interface{}{uid}
+
+	if filter.Folder != "" {
+		args = append(args, filter.Folder)
+		fmt.Fprintf(&where, " AND folder = $%d", len(args))
+	}
+	if len(filter.Tags) > 0 {
+		args = append(args, jsonArg(filter.Tags))
+		fmt.Fprintf(&where, " AND tags @> $%d::jsonb", len(args))
+	}
+	for _, w := range filter.Words() {
+		args = append(args, "%"+likeEscaper.Replace(w)+"%")
+		fmt.Fprintf(&where, " AND (title ILIKE $%[1]d OR notes ILIKE $%[1]d OR long_link ILIKE $%[1]d)", len(args))
+	}
+
+	return where.String(), args
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// rollupArgs returns arrays of the counters' columns sorted by key, so concurrent upserts lock rows in the same order.
+func rollupArgs(counts map[string]map[models.RollupKey]int64) []interface{} {
+	type counter struct {
+		short string
+		models.RollupKey
+		clicks int64
+	}
+	var counters []counter
+	for short, linkCounts := range counts {
+		for k, n := range linkCounts {
+			counters = append(counters, counter{short: short, RollupKey: k, clicks: n})
+		}
+	}
+	sort.Slice(counters, func(i, j int) bool {
+		a, b := counters[i], counters[j]
+		switch {
+		case a.short != b.short:
+			return a.short < b.short
+		case a.Interval != b.Interval:
+			return a.Interval < b.Interval
+		case !a.Bucket.Equal(b.Bucket):
+			return a.Bucket.Before(b.Bucket)
+		case a.Dimension != b.Dimension:
+			return a.Dimension < b.Dimension
+		}
+		return a.Value < b.Value
+	})
+
+	shorts := make([]string, len(counters))
+	intervals := make([]string, len(counters))
+	buckets := make([]time.Time, len(counters))
+	dimensions := make([]string, len(counters))
+	values := make([]string, len(counters))
+	clicks := make([]int64, len(counters))
+	for i, k := range counters {
+		shorts[i], intervals[i], buckets[i], dimensions[i], values[i] = k.short, k.Interval, k.Bucket, k.Dimension, k.Value
+		clicks[i] = k.clicks
+	}
+	return []interface{}{shorts, intervals, buckets, dimensions, values, clicks}
+}
+
+// revisionArgs returns arguments of insertRevisionQuery.
+func revisionArgs(short string, r models.Revision) []interface{} {
+	link, _ := json.Marshal(snapshot{LinkJSON: r.Link.JSON(short), PasswordHash: r.Link.PasswordHash})
+	return []interface{}{short, r.Number, r.Author, r.CreatedAt, string(link)}
+}
+
+// scanLink reads a row selected with linkFields.
+func scanLink(row pgx.Row) (string, models.LinkInfo, error) {
+	var short string
+	var l models.LinkInfo
+	var expiresAt, activeFrom, activeUntil *time.Time
+	var rules, health, tags, metadata []byte
+
+	err := row.Scan(&short, &l.UUID, &l.Long, &l.IsDeleted, &expiresAt, &l.MaxClicks, &l.Clicks, &l.PasswordHash,
+		&l.RedirectCode, &l.CachePolicy, &l.ForwardQuery, &l.QueryConflict, &l.ForwardPath, &rules,
+		&activeFrom, &activeUntil, &l.Title, &l.Preview, &l.CreatedAt, &health, &tags, &l.Folder, &l.Notes, &metadata)
+	if err != nil {
+		return "", models.LinkInfo{}, err
+	}
+	l.ExpiresAt = models.TimeValue(expiresAt)
+	l.ActiveFrom = models.TimeValue(activeFrom)
+	l.ActiveUntil = models.TimeValue(activeUntil)
+	err = json.Unmarshal(rules, &l.Rules)
+	if err != nil {
+		return "", models.LinkInfo{}, err
+	}
+	err = json.Unmarshal(tags, &l.Tags)
+	if err != nil {
+		return "", models.LinkInfo{}, err
+	}
+	if health != nil {
+		l.Health = new(models.LinkHealth)
+		err = json.Unmarshal(health, l.Health)
+		if err != nil {
+			return "", models.LinkInfo{}, err
+		}
+	}
+	if metadata != nil {
+		l.Metadata = new(models.LinkMetadata)
+		err = json.Unmarshal(metadata, l.Metadata)
+		if err != nil {
+			return "", models.LinkInfo{}, err
+		}
+	}
+
+	return short, l, nil
+}
+
+func migrate(c *pgxpool.Pool) error {
+	for _, m := range migrations {
+		_, err := c.Exec(context.Background(), m)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
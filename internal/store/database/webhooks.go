@@ -0,0 +1,181 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+const (
+	webhookFields  = "id, user_id, url, events, secret, created_at"
+	deliveryFields = "id, webhook_id, user_id, event_type, payload, status, attempts, last_status, last_error, " +
+		"next_attempt_at, created_at, delivered_at"
+)
+
+func (d *DB) CreateWebhook(ctx context.Context, w models.Webhook) error {
+	_, err := d.conn.Exec(ctx, "INSERT INTO webhooks ("+webhookFields+") VALUES ( $1, $2, $3, $4, $5, $6 )",
+		w.ID, w.UID, w.URL, jsonArg(w.Events), w.Secret, w.CreatedAt)
+	return err
+}
+
+// Webhooks returns webhooks of the user, oldest first.
+func (d *DB) Webhooks(ctx context.Context, uid string) ([]models.Webhook, error) {
+	rows, err := d.conn.Query(ctx, "SELECT "+webhookFields+" FROM webhooks WHERE user_id = $1 ORDER BY created_at, id", uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []models.Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, w)
+	}
+	return res, rows.Err()
+}
+
+func (d *DB) Webhook(ctx context.Context, id string) (models.Webhook, error) {
+	w, err := scanWebhook(d.conn.QueryRow(ctx, "SELECT "+webhookFields+" FROM webhooks WHERE id::text = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Webhook{}, app.ErrWebhookNotFound
+	}
+	return w, err
+}
+
+// DeleteWebhook deletes the user's webhook with its deliveries.
+func (d *DB) DeleteWebhook(ctx context.Context, uid, id string) error {
+	tag, err := d.conn.Exec(ctx, "DELETE FROM webhooks WHERE id::text = $1 AND user_id = $2", id, uid)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return app.ErrWebhookNotFound
+	}
+	return nil
+}
+
+func (d *DB) AddDeliveries(ctx context.Context, deliveries []models.Delivery) error {
+	batch := &pgx.Batch{}
+	for _, dl := range deliveries {
+		batch.Queue("INSERT INTO webhook_deliveries ("+deliveryFields+") "+
+			"VALUES ( $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12 )", deliveryArgs(dl)...)
+	}
+	return d.conn.SendBatch(ctx, batch).Close()
+}
+
+// ClaimDeliveries returns up to limit pending deliveries due at now, the most overdue first,
+// and postpones their next attempts by lease, so they aren't claimed again while being delivered.
+func (d *DB) ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.Delivery, error) {
+	rows, err := d.conn.Query(ctx, "WITH due AS ("+
+		"SELECT id, next_attempt_at FROM webhook_deliveries WHERE status = $1 AND next_attempt_at <= $2 "+
+		"ORDER BY next_attempt_at LIMIT $3 FOR UPDATE SKIP LOCKED) "+
+		"UPDATE webhook_deliveries w SET next_attempt_at = $4 FROM due WHERE w.id = due.id "+
+		"RETURNING w.id, w.webhook_id, w.user_id, w.event_type, w.payload, w.status, w.attempts, w.last_status, "+
+		"w.last_error, due.next_attempt_at, w.created_at, w.delivered_at",
+		models.DeliveryPending, now, limit, now.Add(lease))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanDeliveries(rows)
+}
+
+func (d *DB) SaveDelivery(ctx context.Context, dl models.Delivery) error {
+	_, err := d.conn.Exec(ctx, "UPDATE webhook_deliveries SET (status, attempts, last_status, last_error, next_attempt_at, delivered_at) = "+
+		"( $2, $3, $4, $5, $6, $7 ) WHERE id = $1",
+		dl.ID, dl.Status, dl.Attempts, dl.LastStatus, dl.LastError, dl.NextAttemptAt, dl.DeliveredAt)
+	return err
+}
+
+// DeleteDeliveries deletes deliveries which were delivered or became dead before the time.
+func (d *DB) DeleteDeliveries(ctx context.Context, finishedBefore time.Time) (int64, error) {
+	tag, err := d.conn.Exec(ctx, "DELETE FROM webhook_deliveries WHERE status <> $1 AND "+
+		"COALESCE(delivered_at, next_attempt_at) < $2", models.DeliveryPending, finishedBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// Deliveries returns up to limit deliveries of the user's webhook with the status, or any status if it's empty, newest first.
+func (d *DB) Deliveries(ctx context.Context, uid, webhookID, status string, limit int) ([]models.Delivery, error) {
+	rows, err := d.conn.Query(ctx, "SELECT "+deliveryFields+" FROM webhook_deliveries "+
+		"WHERE webhook_id::text = $1 AND user_id = $2 AND ($3 = '' OR status = $3) "+
+		"ORDER BY created_at DESC, id LIMIT $4", webhookID, uid, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanDeliveries(rows)
+}
+
+// RetryDelivery queues the user's dead delivery again with a fresh number of attempts.
+func (d *DB) RetryDelivery(ctx context.Context, uid, id string) (models.Delivery, error) {
+	rows, err := d.conn.Query(ctx, "UPDATE webhook_deliveries SET (status, attempts, next_attempt_at) = ( $1, 0, now() ) "+
+		"WHERE id::text = $2 AND user_id = $3 AND status = $4 RETURNING "+deliveryFields,
+		models.DeliveryPending, id, uid, models.DeliveryDead)
+	if err != nil {
+		return models.Delivery{}, err
+	}
+	res, err := scanDeliveries(rows)
+	rows.Close()
+	if err != nil {
+		return models.Delivery{}, err
+	}
+	if len(res) == 1 {
+		return res[0], nil
+	}
+
+	var status string
+	err = d.conn.QueryRow(ctx, "SELECT status FROM webhook_deliveries WHERE id::text = $1 AND user_id = $2", id, uid).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Delivery{}, app.ErrDeliveryNotFound
+	}
+	if err != nil {
+		return models.Delivery{}, err
+	}
+	return models.Delivery{}, app.ErrDeliveryNotDead
+}
+
+func deliveryArgs(dl models.Delivery) []interface{} {
+	return []interface{}{dl.ID, dl.WebhookID, dl.UID, dl.EventType, string(dl.Payload), dl.Status, dl.Attempts,
+		dl.LastStatus, dl.LastError, dl.NextAttemptAt, dl.CreatedAt, dl.DeliveredAt}
+}
+
+func scanWebhook(row pgx.Row) (models.Webhook, error) {
+	var w models.Webhook
+	var events []byte
+	err := row.Scan(&w.ID, &w.UID, &w.URL, &events, &w.Secret, &w.CreatedAt)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	return w, json.Unmarshal(events, &w.Events)
+}
+
+func scanDeliveries(rows pgx.Rows) ([]models.Delivery, error) {
+	var res []models.Delivery
+	for rows.Next() {
+		var dl models.Delivery
+		var payload []byte
+		err := rows.Scan(&dl.ID, &dl.WebhookID, &dl.UID, &dl.EventType, &payload, &dl.Status, &dl.Attempts,
+			&dl.LastStatus, &dl.LastError, &dl.NextAttemptAt, &dl.CreatedAt, &dl.DeliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		dl.Payload = payload
+		res = append(res, dl)
+	}
+	return res, rows.Err()
+}
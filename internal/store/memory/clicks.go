@@ -0,0 +1,364 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/DrGermanius/Shortener/internal/app/config"
+	"github.com/DrGermanius/Shortener/internal/app/hll"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// clicksCompactSize is the least number of clicks appended to the clicks file before it's compacted.
+const clicksCompactSize = 10000
+
+// clickStore keeps aggregates of clicks under a lock of its own, so recording clicks doesn't hold up the links.
+// Clicks are counted on ingest and aren't kept; the clicks file holds them only until it's compacted.
+type clickStore struct {
+	mu       sync.RWMutex
+	rollups  map[rollupSeries][]rollupBucket
+	visitors map[string]map[time.Time]*hll.Sketch
+	spans    map[string]clickSpan
+
+	// appended is the number of clicks appended to the file since it was compacted,
+	// compacted is the number of lines the compacted file started with.
+	appended  int
+	compacted int
+}
+
+// rollupSeries identifies the rollups of a link for an interval.
+type rollupSeries struct {
+	short    string
+	interval string
+}
+
+// rollupBucket is the counters of a bucket of a rollup series; buckets of a series are sorted by start.
+type rollupBucket struct {
+	start  time.Time
+	counts map[rollupValue]int64
+}
+
+type rollupValue struct {
+	dimension string
+	value     string
+}
+
+// clickSpan is the time of the first and the last click of a link.
+type clickSpan struct {
+	First time.Time `json:"first"`
+	Last  time.Time `json:"last"`
+}
+
+// clicksLine is a line of the clicks file: a click, or a counter, a visitor sketch or the click span of a link
+// written by compaction in place of the clicks.
+type clicksLine struct {
+	models.Click
+	Rollup *rollupLine `json:"rollup,omitempty"`
+	Sketch *sketchLine `json:"sketch,omitempty"`
+	Span   *clickSpan  `json:"span,omitempty"`
+}
+
+// aggregateLine is a line of the compacted clicks file, see clicksLine.
+type aggregateLine struct {
+	Short  string      `json:"short_url"`
+	Rollup *rollupLine `json:"rollup,omitempty"`
+	Sketch *sketchLine `json:"sketch,omitempty"`
+	Span   *clickSpan  `json:"span,omitempty"`
+}
+
+type rollupLine struct {
+	Interval  string    `json:"interval"`
+	Bucket    time.Time `json:"bucket"`
+	Dimension string    `json:"dimension"`
+	Value     string    `json:"value,omitempty"`
+	Clicks    int64     `json:"clicks"`
+}
+
+type sketchLine struct {
+	Bucket time.Time `json:"bucket"`
+	Sketch []byte    `json:"sketch"`
+}
+
+func newClickStore() *clickStore {
+	return &clickStore{
+		rollups:  make(map[rollupSeries][]rollupBucket),
+		visitors: make(map[string]map[time.Time]*hll.Sketch),
+		spans:    make(map[string]clickSpan),
+	}
+}
+
+// AddClicks counts the clicks and appends them to the clicks file, compacting the file once enough clicks were appended.
+func (l *LinkMemoryStore) AddClicks(_ context.Context, clicks []models.Click) error {
+	c := l.clicks
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lines := make([]interface{}, len(clicks))
+	for i, click := range clicks {
+		c.add(click)
+		lines[i] = click
+	}
+	err := appendLines(clicksFilePath(), lines...)
+	if err != nil {
+		return err
+	}
+
+	c.appended += len(clicks)
+	// the file is rewritten once it doubles at most, so compaction takes a constant time per click
+	if c.appended < clicksCompactSize || c.appended < c.compacted {
+		return nil
+	}
+	return c.compact()
+}
+
+// add counts the click in rollups, visitor sketches and the click span of the link.
+func (c *clickStore) add(click models.Click) {
+	for _, k := range models.RollupKeys(click) {
+		c.bucket(rollupSeries{short: click.Short, interval: k.Interval}, k.Bucket)[rollupValue{k.Dimension, k.Value}]++
+	}
+
+	v, ok := c.visitors[click.Short]
+	if !ok {
+		v = make(map[time.Time]*hll.Sketch)
+		c.visitors[click.Short] = v
+	}
+	models.AddVisitor(v, click)
+
+	span, ok := c.spans[click.Short]
+	if !ok || click.Time.Before(span.First) {
+		span.First = click.Time
+	}
+	if !ok || click.Time.After(span.Last) {
+		span.Last = click.Time
+	}
+	c.spans[click.Short] = span
+}
+
+// bucket returns counters of the bucket of the series, adding the bucket if it's missing.
+func (c *clickStore) bucket(s rollupSeries, start time.Time) map[rollupValue]int64 {
+	buckets := c.rollups[s]
+	// clicks come in order mostly, so the bucket is the last one or a new one at the end
+	i := len(buckets)
+	if i == 0 || !buckets[i-1].start.Equal(start) {
+		i = sort.Search(len(buckets), func(i int) bool {
+			return !buckets[i].start.Before(start)
+		})
+	} else {
+		i--
+	}
+	if i < len(buckets) && buckets[i].start.Equal(start) {
+		return buckets[i].counts
+	}
+
+	b := rollupBucket{start: start, counts: make(map[rollupValue]int64)}
+	buckets = append(buckets, rollupBucket{})
+	copy(buckets[i+1:], buckets[i:])
+	buckets[i] = b
+	c.rollups[s] = buckets
+	return b.counts
+}
+
+// DeleteRollups deletes rollups of the interval for buckets starting before the time.
+func (l *LinkMemoryStore) DeleteRollups(_ context.Context, interval string, before time.Time) (int64, error) {
+	c := l.clicks
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var n int64
+	for s, buckets := range c.rollups {
+		if s.interval != interval {
+			continue
+		}
+
+		i := sort.Search(len(buckets), func(i int) bool {
+			return !buckets[i].start.Before(before)
+		})
+		for _, b := range buckets[:i] {
+			n += int64(len(b.counts))
+		}
+		switch {
+		case i == len(buckets):
+			delete(c.rollups, s)
+		case i > 0:
+			c.rollups[s] = append([]rollupBucket(nil), buckets[i:]...)
+		}
+	}
+	return n, nil
+}
+
+// DeleteVisitorSketches deletes visitor sketches for buckets starting before the time.
+func (l *LinkMemoryStore) DeleteVisitorSketches(_ context.Context, before time.Time) (int64, error) {
+	c := l.clicks
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var n int64
+	for short, sketches := range c.visitors {
+		for bucket := range sketches {
+			if bucket.Before(before) {
+				delete(sketches, bucket)
+				n++
+			}
+		}
+		if len(sketches) == 0 {
+			delete(c.visitors, short)
+		}
+	}
+	return n, nil
+}
+
+// compact replaces the clicks file with the aggregates of the clicks.
+func (c *clickStore) compact() error {
+	var lines []interface{}
+	for short, span := range c.spans {
+		span := span
+		lines = append(lines, aggregateLine{Short: short, Span: &span})
+	}
+	for s, buckets := range c.rollups {
+		for _, b := range buckets {
+			for v, n := range b.counts {
+				lines = append(lines, aggregateLine{Short: s.short, Rollup: &rollupLine{
+					Interval:  s.interval,
+					Bucket:    b.start,
+					Dimension: v.dimension,
+					Value:     v.value,
+					Clicks:    n,
+				}})
+			}
+		}
+	}
+	for short, sketches := range c.visitors {
+		for bucket, s := range sketches {
+			b, err := s.MarshalBinary()
+			if err != nil {
+				return err
+			}
+			lines = append(lines, aggregateLine{Short: short, Sketch: &sketchLine{Bucket: bucket, Sketch: b}})
+		}
+	}
+
+	err := rewriteLines(clicksFilePath(), lines...)
+	if err != nil {
+		return err
+	}
+	c.appended, c.compacted = 0, len(lines)
+	return nil
+}
+
+// VisitorSketches returns copies of visitor sketches of the link for buckets starting within [from, to).
+func (l *LinkMemoryStore) VisitorSketches(_ context.Context, short string, from, to time.Time) ([]models.VisitorSketch, error) {
+	c := l.clicks
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var res []models.VisitorSketch
+	for bucket, s := range c.visitors[short] {
+		if !bucket.Before(from) && bucket.Before(to) {
+			cp := hll.New()
+			cp.Merge(s)
+			res = append(res, models.VisitorSketch{Bucket: bucket, Sketch: cp})
+		}
+	}
+	return res, nil
+}
+
+// ClickRollups returns counters of the link for buckets of the interval starting within [from, to).
+func (l *LinkMemoryStore) ClickRollups(_ context.Context, short, interval string, from, to time.Time) ([]models.ClickRollup, error) {
+	c := l.clicks
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	buckets := c.rollups[rollupSeries{short: short, interval: interval}]
+	i := sort.Search(len(buckets), func(i int) bool {
+		return !buckets[i].start.Before(from)
+	})
+
+	var res []models.ClickRollup
+	for ; i < len(buckets) && buckets[i].start.Before(to); i++ {
+		for v, n := range buckets[i].counts {
+			k := models.RollupKey{Interval: interval, Bucket: buckets[i].start, Dimension: v.dimension, Value: v.value}
+			res = append(res, models.ClickRollup{RollupKey: k, Clicks: n})
+		}
+	}
+	return res, nil
+}
+
+// ClickStats sums daily rollups of the link, so it doesn't depend on the number of clicks.
+func (l *LinkMemoryStore) ClickStats(_ context.Context, short string) (models.LinkStats, error) {
+	c := l.clicks
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var stats models.LinkStats
+	if span, ok := c.spans[short]; ok {
+		stats.FirstClick, stats.LastClick = &span.First, &span.Last
+	}
+
+	counts := map[string]map[string]int64{
+		models.DimensionReferrer: {},
+		models.DimensionCountry:  {},
+		models.DimensionDevice:   {},
+	}
+	for _, b := range c.rollups[rollupSeries{short: short, interval: models.IntervalDay}] {
+		for v, n := range b.counts {
+			if v.dimension == models.DimensionTotal {
+				stats.Clicks += n
+				continue
+			}
+			if dim, ok := counts[v.dimension]; ok {
+				dim[v.value] += n
+			}
+		}
+	}
+	stats.Referrers = models.TopStats(counts[models.DimensionReferrer])
+	stats.Countries = models.TopStats(counts[models.DimensionCountry])
+	stats.Devices = models.TopStats(counts[models.DimensionDevice])
+	return stats, nil
+}
+
+// readClicksFile restores the aggregates from the compacted part of the clicks file and the clicks appended since.
+func (l *LinkMemoryStore) readClicksFile() error {
+	c := l.clicks
+	return readLines(clicksFilePath(), func(b []byte) error {
+		var line clicksLine
+		err := json.Unmarshal(b, &line)
+		if err != nil {
+			return err
+		}
+
+		short := line.Short
+		switch {
+		case line.Span != nil:
+			c.spans[short] = *line.Span
+		case line.Rollup != nil:
+			r := line.Rollup
+			c.bucket(rollupSeries{short: short, interval: r.Interval}, r.Bucket)[rollupValue{r.Dimension, r.Value}] = r.Clicks
+		case line.Sketch != nil:
+			s := hll.New()
+			err = s.UnmarshalBinary(line.Sketch.Sketch)
+			if err != nil {
+				return err
+			}
+			v, ok := c.visitors[short]
+			if !ok {
+				v = make(map[time.Time]*hll.Sketch)
+				c.visitors[short] = v
+			}
+			v[line.Sketch.Bucket] = s
+		default:
+			c.add(line.Click)
+			c.appended++
+			return nil
+		}
+		c.compacted++
+		return nil
+	})
+}
+
+// clicksFilePath returns path of the file clicks are kept in next to the links.
+func clicksFilePath() string {
+	return config.Config().FilePath + ".clicks"
+}
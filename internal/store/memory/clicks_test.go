@@ -0,0 +1,137 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/DrGermanius/Shortener/internal/app/config"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+func TestClicksCompaction(t *testing.T) {
+	t.Setenv("FILE_STORAGE_PATH", filepath.Join(t.TempDir(), "links"))
+	config.SetTestConfig()
+
+	l, err := NewLinkMemoryStore()
+	require.NoError(t, err)
+
+	start := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	clicks := make([]models.Click, clicksCompactSize)
+	for i := range clicks {
+		clicks[i] = models.Click{
+			Short:     "a",
+			Time:      start.Add(time.Duration(i) * time.Second),
+			Referrer:  "https://www.google.com/search",
+			UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 15_0 like Mac OS X) Mobile/15E148 Safari/604.1",
+			IPHash:    fmt.Sprint(i % 100),
+			Country:   "DE",
+		}
+	}
+	require.NoError(t, l.AddClicks(context.Background(), clicks))
+	require.Equal(t, 0, l.clicks.appended)
+	require.NotZero(t, l.clicks.compacted)
+
+	last := models.Click{Short: "a", Time: start.Add(24 * time.Hour), Country: "FR"}
+	require.NoError(t, l.AddClicks(context.Background(), []models.Click{last}))
+
+	want, err := l.ClickStats(context.Background(), "a")
+	require.NoError(t, err)
+	require.Equal(t, int64(clicksCompactSize+1), want.Clicks)
+	require.Equal(t, start, *want.FirstClick)
+	require.Equal(t, last.Time, *want.LastClick)
+	require.Equal(t, []models.StatsEntry{{Value: "google.com", Clicks: clicksCompactSize}, {Value: "", Clicks: 1}}, want.Referrers)
+
+	wantSketches, err := l.VisitorSketches(context.Background(), "a", start.Truncate(24*time.Hour), last.Time)
+	require.NoError(t, err)
+	require.Len(t, wantSketches, 1)
+
+	reopened, err := NewLinkMemoryStore()
+	require.NoError(t, err)
+	require.Equal(t, 1, reopened.clicks.appended)
+	require.Equal(t, l.clicks.compacted, reopened.clicks.compacted)
+
+	got, err := reopened.ClickStats(context.Background(), "a")
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	gotSketches, err := reopened.VisitorSketches(context.Background(), "a", start.Truncate(24*time.Hour), last.Time)
+	require.NoError(t, err)
+	require.Equal(t, wantSketches, gotSketches)
+	require.Equal(t, int64(100), gotSketches[0].Sketch.Estimate())
+}
+
+func TestRollupSeries(t *testing.T) {
+	t.Setenv("FILE_STORAGE_PATH", filepath.Join(t.TempDir(), "links"))
+	config.SetTestConfig()
+
+	l, err := NewLinkMemoryStore()
+	require.NoError(t, err)
+
+	start := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	// out of order clicks land in sorted buckets
+	var clicks []models.Click
+	for _, m := range []int{5, 1, 3, 1, 0, 4} {
+		clicks = append(clicks, models.Click{Short: "a", Time: start.Add(time.Duration(m) * time.Minute)})
+	}
+	clicks = append(clicks, models.Click{Short: "b", Time: start})
+	require.NoError(t, l.AddClicks(context.Background(), clicks))
+
+	totals := func(from, to time.Time) map[time.Time]int64 {
+		rollups, err := l.ClickRollups(context.Background(), "a", models.IntervalMinute, from, to)
+		require.NoError(t, err)
+		res := make(map[time.Time]int64)
+		for _, r := range rollups {
+			if r.Dimension == models.DimensionTotal {
+				res[r.Bucket] = r.Clicks
+			}
+		}
+		return res
+	}
+	minute := func(m int) time.Time {
+		return start.Add(time.Duration(m) * time.Minute)
+	}
+	require.Equal(t, map[time.Time]int64{minute(1): 2, minute(3): 1, minute(4): 1}, totals(minute(1), minute(5)))
+
+	n, err := l.DeleteRollups(context.Background(), models.IntervalMinute, minute(3))
+	require.NoError(t, err)
+	// the total and five dimensions of buckets 0 and 1 of "a" and bucket 0 of "b"
+	require.Equal(t, int64(3*6), n)
+	require.Equal(t, map[time.Time]int64{minute(3): 1, minute(4): 1, minute(5): 1}, totals(start, minute(10)))
+
+	hours, err := l.ClickRollups(context.Background(), "b", models.IntervalHour, start, minute(60))
+	require.NoError(t, err)
+	require.Len(t, hours, 6)
+}
+
+func TestDeleteVisitorSketches(t *testing.T) {
+	t.Setenv("FILE_STORAGE_PATH", filepath.Join(t.TempDir(), "links"))
+	config.SetTestConfig()
+
+	l, err := NewLinkMemoryStore()
+	require.NoError(t, err)
+
+	day := func(d int) time.Time {
+		return time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, d)
+	}
+	var clicks []models.Click
+	for _, d := range []int{0, 1, 2} {
+		clicks = append(clicks, models.Click{Short: "a", Time: day(d).Add(time.Hour), IPHash: "1"})
+	}
+	clicks = append(clicks, models.Click{Short: "b", Time: day(0), IPHash: "1"})
+	require.NoError(t, l.AddClicks(context.Background(), clicks))
+
+	n, err := l.DeleteVisitorSketches(context.Background(), day(2))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), n)
+
+	sketches, err := l.VisitorSketches(context.Background(), "a", day(0), day(3))
+	require.NoError(t, err)
+	require.Len(t, sketches, 1)
+	require.Equal(t, day(2), sketches[0].Bucket)
+	require.NotContains(t, l.clicks.visitors, "b")
+}
@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// recentMinutes is the span of links counted as created recently.
+const recentMinutes = 24 * 60
+
+// counters are totals of the links kept up to date on every change, so stats don't scan the links.
+type counters struct {
+	urls    int64
+	deleted int64
+	users   map[string]struct{}
+	created recentCounter
+}
+
+// recentCounter counts links created within each of the recent minutes, indexed by minute modulo the span.
+type recentCounter [recentMinutes]minuteCount
+
+type minuteCount struct {
+	minute int64
+	n      int64
+}
+
+func newCounters() *counters {
+	return &counters{users: make(map[string]struct{})}
+}
+
+// setLink saves the link state and updates the counters and the short codes of destinations.
+func (l *LinkMemoryStore) setLink(s string, info models.LinkInfo) {
+	old, exist := l.links[s]
+	l.links[s] = info
+
+	if exist && old.Long != info.Long && l.shorts[old.Long] == s {
+		delete(l.shorts, old.Long)
+	}
+	// a deleted link gives its destination up to a newer link
+	if other, taken := l.shorts[info.Long]; !taken || other == s || l.links[other].IsDeleted {
+		l.shorts[info.Long] = s
+	}
+
+	c := l.counters
+	if exist {
+		c.count(old, -1)
+	} else {
+		c.created.add(info.CreatedAt, time.Now())
+		if info.UUID != "" {
+			c.users[info.UUID] = struct{}{}
+		}
+	}
+	c.count(info, 1)
+}
+
+// InternalStats returns totals of the links; links created within the last minute of the span may be left out.
+func (l *LinkMemoryStore) InternalStats(_ context.Context) (models.InternalStats, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	c := l.counters
+	return models.InternalStats{
+		URLs:           c.urls,
+		Users:          int64(len(c.users)),
+		DeletedURLs:    c.deleted,
+		CreatedLast24h: c.created.sum(time.Now()),
+	}, nil
+}
+
+func (c *counters) count(info models.LinkInfo, n int64) {
+	if info.IsDeleted {
+		c.deleted += n
+	} else {
+		c.urls += n
+	}
+}
+
+// add counts a link created at t unless it's older than the span.
+func (m *recentCounter) add(t, now time.Time) {
+	minute := t.Unix() / 60
+	if minute <= now.Unix()/60-recentMinutes {
+		return
+	}
+
+	b := &m[minute%recentMinutes]
+	if b.minute != minute {
+		*b = minuteCount{minute: minute}
+	}
+	b.n++
+}
+
+// sum returns the number of links created within the span before now.
+func (m *recentCounter) sum(now time.Time) int64 {
+	from := now.Unix()/60 - recentMinutes
+	var n int64
+	for _, b := range m {
+		if b.minute > from {
+			n += b.n
+		}
+	}
+	return n
+}
@@ -5,28 +5,89 @@ import (
 	"context"
 	"encoding/json"
 	"os"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/DrGermanius/Shortener/internal/app"
 	"github.com/DrGermanius/Shortener/internal/app/config"
 	"github.com/DrGermanius/Shortener/internal/app/models"
 )
 
-type LinkMemoryStore map[string]models.LinkInfo
+type LinkMemoryStore struct {
+	mu        sync.RWMutex
+	links     map[string]models.LinkInfo
+	shorts    map[string]string // short code of the live link of every destination
+	revisions map[string][]models.Revision
+	jobs      map[string]models.Job
+	clicks    *clickStore
+
+	// webhooks and their deliveries are guarded by hooksMu, so dispatching them doesn't hold up the links
+	hooksMu    sync.RWMutex
+	webhooks   map[string]models.Webhook
+	deliveries map[string]models.Delivery
+	due        attemptQueue
+
+	counters *counters
+}
+
+// record is a line of the storage file; it keeps fields which are never exposed via LinkJSON.
+type record struct {
+	models.LinkJSON
+	PasswordHash string         `json:"password_hash,omitempty"`
+	Revision     *revisionStamp `json:"revision,omitempty"`
+}
+
+// jobRecord is a line of the jobs file; the last record of a job wins on read.
+type jobRecord struct {
+	models.Job
+	UID string `json:"uid"`
+}
+
+// revisionStamp marks a record which is a revision of the link as well.
+type revisionStamp struct {
+	Number    int       `json:"number"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+}
 
 func NewLinkMemoryStore() (*LinkMemoryStore, error) {
-	LinksMap := make(LinkMemoryStore)
+	l := &LinkMemoryStore{
+		links:     make(map[string]models.LinkInfo),
+		shorts:    make(map[string]string),
+		revisions: make(map[string][]models.Revision),
+		jobs:      make(map[string]models.Job),
+		clicks:    newClickStore(),
+
+		webhooks:   make(map[string]models.Webhook),
+		deliveries: make(map[string]models.Delivery),
 
-	err := LinksMap.readFile()
+		counters: newCounters(),
+	}
+
+	err := l.readFile()
 	if err != nil {
 		return nil, err
 	}
-	return &LinksMap, nil
+	err = l.readJobsFile()
+	if err != nil {
+		return nil, err
+	}
+	err = l.readClicksFile()
+	if err != nil {
+		return nil, err
+	}
+	err = l.readWebhooksFiles()
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
 }
 
-func (l LinkMemoryStore) BatchWrite(ctx context.Context, uid string, originals []models.BatchOriginal) ([]string, error) {
+func (l *LinkMemoryStore) BatchWrite(ctx context.Context, uid string, originals []models.BatchOriginal) ([]string, error) {
 	shorts := make([]string, 0, len(originals))
 	for _, v := range originals {
-		s, err := l.Write(ctx, uid, v.OriginalURL)
+		s, err := l.Write(ctx, uid, v.OriginalURL, v.LinkOptions)
 		if err != nil {
 			return nil, err
 		}
@@ -36,39 +97,306 @@ func (l LinkMemoryStore) BatchWrite(ctx context.Context, uid string, originals [
 	return shorts, nil
 }
 
-func (l LinkMemoryStore) Ping(_ context.Context) bool {
+// SaveJob stores the current state of the job.
+func (l *LinkMemoryStore) SaveJob(_ context.Context, job models.Job) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.jobs[job.ID] = job
+	return writeJobRecord(job)
+}
+
+func (l *LinkMemoryStore) GetJob(_ context.Context, id string) (models.Job, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	j, ok := l.jobs[id]
+	if !ok {
+		return models.Job{}, app.ErrJobNotFound
+	}
+	return j, nil
+}
+
+// InterruptJobs marks every unfinished job as failed and returns the number of affected jobs.
+func (l *LinkMemoryStore) InterruptJobs(_ context.Context) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var n int64
+	for id, j := range l.jobs {
+		if j.Finished() {
+			continue
+		}
+
+		j.Status, j.Error, j.FinishedAt = models.JobFailed, app.ErrJobInterrupted.Error(), &now
+		l.jobs[id] = j
+		err := writeJobRecord(j)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// DeleteJobs deletes jobs finished before the time and rewrites the jobs file without them.
+func (l *LinkMemoryStore) DeleteJobs(_ context.Context, finishedBefore time.Time) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var n int64
+	for id, j := range l.jobs {
+		if j.FinishedAt != nil && j.FinishedAt.Before(finishedBefore) {
+			delete(l.jobs, id)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	lines := make([]interface{}, 0, len(l.jobs))
+	for _, j := range l.jobs {
+		lines = append(lines, jobRecord{Job: j, UID: j.UID})
+	}
+	return n, rewriteLines(jobsFilePath(), lines...)
+}
+
+func (l *LinkMemoryStore) Ping(_ context.Context) bool {
 	return true
 }
 
-func (l LinkMemoryStore) Delete(_ context.Context, uid string, link string) error {
-	if l[link].UUID == uid {
-		updatedLink := l[link]
-		updatedLink.IsDeleted = true
-		l[link] = updatedLink
+// Delete marks the user's link as deleted; it returns ErrLinkNotFound or ErrNotLinkOwner if there is nothing to delete.
+func (l *LinkMemoryStore) Delete(_ context.Context, uid string, link string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	info, exist := l.links[link]
+	if !exist {
+		return app.ErrLinkNotFound
+	}
+	if info.UUID != uid {
+		return app.ErrNotLinkOwner
+	}
+	if info.IsDeleted {
+		return nil
+	}
+
+	info.IsDeleted = true
+	l.setLink(link, info)
+	return writeFile(link, info)
+}
+
+// DeleteExpired marks every expired link as deleted and returns the number of affected links.
+func (l *LinkMemoryStore) DeleteExpired(_ context.Context) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var n int64
+	for k, v := range l.links {
+		if v.IsDeleted || !app.IsExpired(v, now) {
+			continue
+		}
+
+		v.IsDeleted = true
+		l.setLink(k, v)
+		err := writeFile(k, v)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (l *LinkMemoryStore) Get(ctx context.Context, s string) (models.LinkInfo, error) {
+	info, err := l.Lookup(ctx, s)
+	if err != nil {
+		return models.LinkInfo{}, err
 	}
+
+	err = app.CheckLink(info, time.Now())
+	if err != nil {
+		return models.LinkInfo{}, err
+	}
+	return info, nil
+}
+
+// HealthTargets returns up to limit followable links which weren't checked since checkedBefore, least recent first.
+func (l *LinkMemoryStore) HealthTargets(_ context.Context, checkedBefore time.Time, limit int) ([]models.HealthTarget, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	type due struct {
+		models.HealthTarget
+		checkedAt time.Time
+	}
+	var res []due
+	for k, v := range l.links {
+		if v.IsDeleted || (v.Health != nil && !v.Health.CheckedAt.Before(checkedBefore)) {
+			continue
+		}
+		d := due{HealthTarget: models.HealthTarget{Short: k, Long: v.Long}}
+		if v.Health != nil {
+			d.checkedAt = v.Health.CheckedAt
+		}
+		res = append(res, d)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].checkedAt.Before(res[j].checkedAt) })
+	if len(res) > limit {
+		res = res[:limit]
+	}
+
+	targets := make([]models.HealthTarget, 0, len(res))
+	for _, v := range res {
+		targets = append(targets, v.HealthTarget)
+	}
+	return targets, nil
+}
+
+// SetHealth records the result of a destination check of the link.
+// The result isn't appended to the storage file, which would otherwise get a record per link every check interval;
+// it is saved along with the next change of the link, and links are checked again soon after a restart anyway.
+func (l *LinkMemoryStore) SetHealth(_ context.Context, s string, health models.LinkHealth) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	info, exist := l.links[s]
+	if !exist {
+		return app.ErrLinkNotFound
+	}
+
+	info.Health = &health
+	l.setLink(s, info)
 	return nil
 }
 
-func (l LinkMemoryStore) Get(_ context.Context, s string) (string, error) {
-	long, exist := l[s]
+// SetMetadata saves metadata of the destination page unless the link has got another destination meanwhile.
+func (l *LinkMemoryStore) SetMetadata(_ context.Context, s, long string, m models.LinkMetadata) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	info, exist := l.links[s]
+	if !exist || info.Long != long {
+		return nil
+	}
+
+	info.Metadata = &m
+	l.setLink(s, info)
+	return writeFile(s, info)
+}
+
+// FindLink returns the short code of the link of the address.
+func (l *LinkMemoryStore) FindLink(_ context.Context, long string) (string, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	s, exist := l.shorts[long]
 	if !exist {
 		return "", app.ErrLinkNotFound
 	}
+	return s, nil
+}
 
-	if long.IsDeleted {
-		return "", app.ErrDeletedLink
+// Lookup returns the link regardless of whether it can be followed.
+func (l *LinkMemoryStore) Lookup(_ context.Context, s string) (models.LinkInfo, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	info, exist := l.links[s]
+	if !exist {
+		return models.LinkInfo{}, app.ErrLinkNotFound
 	}
-	return long.Long, nil
+	return info, nil
 }
 
-func (l LinkMemoryStore) GetByUserID(_ context.Context, id string) ([]models.LinkJSON, error) {
-	var res []models.LinkJSON
-	for k, v := range l {
-		if v.UUID == id {
-			res = append(res, models.LinkJSON{Long: v.Long, Short: app.FullLink(k)})
+// Update applies change to the user's link and saves the result as a new revision.
+// The state before the first change is saved as revision 1.
+func (l *LinkMemoryStore) Update(_ context.Context, uid string, s string, change func(*models.LinkInfo) error) (models.LinkInfo, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	info, exist := l.links[s]
+	if !exist || info.UUID != uid {
+		return models.LinkInfo{}, app.ErrLinkNotFound
+	}
+	if info.IsDeleted {
+		return models.LinkInfo{}, app.ErrDeletedLink
+	}
+
+	if len(l.revisions[s]) == 0 {
+		err := l.addRevision(s, models.Revision{Number: 1, Author: info.UUID, CreatedAt: info.CreatedAt, Link: info})
+		if err != nil {
+			return models.LinkInfo{}, err
 		}
 	}
 
+	err := change(&info)
+	if err != nil {
+		return models.LinkInfo{}, err
+	}
+	if other, exist := l.shorts[info.Long]; exist && other != s {
+		return models.LinkInfo{}, app.ErrLinkAlreadyExists
+	}
+
+	l.setLink(s, info)
+	err = l.addRevision(s, models.Revision{Number: len(l.revisions[s]) + 1, Author: uid, CreatedAt: time.Now(), Link: info})
+	if err != nil {
+		return models.LinkInfo{}, err
+	}
+	return info, nil
+}
+
+// Revisions returns saved revisions of the link, oldest first.
+func (l *LinkMemoryStore) Revisions(_ context.Context, s string) ([]models.Revision, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return append([]models.Revision(nil), l.revisions[s]...), nil
+}
+
+func (l *LinkMemoryStore) addRevision(s string, r models.Revision) error {
+	l.revisions[s] = append(l.revisions[s], r)
+	return writeRecord(record{
+		LinkJSON:     r.Link.JSON(s),
+		PasswordHash: r.Link.PasswordHash,
+		Revision:     &revisionStamp{Number: r.Number, Author: r.Author, CreatedAt: r.CreatedAt},
+	})
+}
+
+// ConsumeClick atomically counts a redirect against the link click limit.
+func (l *LinkMemoryStore) ConsumeClick(_ context.Context, s string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	info, exist := l.links[s]
+	if !exist {
+		return app.ErrLinkNotFound
+	}
+
+	err := app.CheckLink(info, time.Now())
+	if err != nil {
+		return err
+	}
+
+	info.Clicks++
+	l.setLink(s, info)
+	return writeFile(s, info)
+}
+
+func (l *LinkMemoryStore) GetByUserID(ctx context.Context, id string, filter models.LinkFilter) ([]models.LinkJSON, error) {
+	var res []models.LinkJSON
+	err := l.EachByUserID(ctx, id, filter, func(j models.LinkJSON) error {
+		res = append(res, j)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	if len(res) == 0 {
 		return nil, app.ErrUserHasNoRecords
 	}
@@ -76,11 +404,68 @@ func (l LinkMemoryStore) GetByUserID(_ context.Context, id string) ([]models.Lin
 	return res, nil
 }
 
-func (l LinkMemoryStore) Write(_ context.Context, uuid, long string) (string, error) {
-	s := app.ShortLink([]byte(long))
-	l[s] = models.LinkInfo{Long: long, UUID: uuid, IsDeleted: false}
+// EachByUserID calls fn for every user's link matching the filter in creation order until fn fails.
+// Matching links are copied first, so fn doesn't hold the store lock.
+func (l *LinkMemoryStore) EachByUserID(_ context.Context, id string, filter models.LinkFilter, fn func(models.LinkJSON) error) error {
+	l.mu.RLock()
+	var res []models.LinkJSON
+	for k, v := range l.links {
+		if v.UUID == id && filter.Match(v) {
+			j := v.JSON(k)
+			j.UUID = ""
+			j.Short = app.FullLink(k)
+			res = append(res, j)
+		}
+	}
+	l.mu.RUnlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		ci, cj := models.TimeValue(res[i].CreatedAt), models.TimeValue(res[j].CreatedAt)
+		if !ci.Equal(cj) {
+			return ci.Before(cj)
+		}
+		return res[i].Short < res[j].Short
+	})
+
+	for _, j := range res {
+		err := fn(j)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write saves a new link of the address; if the address already has a link, its short code is returned
+// with ErrLinkAlreadyExists and the link is left as is. Short codes of deleted links are never reused,
+// so their revisions and clicks can't pass to another link.
+func (l *LinkMemoryStore) Write(_ context.Context, uuid, long string, opts models.LinkOptions) (string, error) {
+	info := models.NewLinkInfo(uuid, long, opts)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the link of another user must never be taken over
+	if s, exist := l.shorts[long]; exist {
+		return s, app.ErrLinkAlreadyExists
+	}
+
+	s := opts.Alias
+	if s != "" {
+		if _, exist := l.links[s]; exist {
+			return "", app.ErrAliasTaken
+		}
+	} else {
+		var err error
+		s, err = l.freeShortLink(long)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	l.setLink(s, info)
 
-	err := writeFile(uuid, s, long)
+	err := writeFile(s, info)
 	if err != nil {
 		return "", err
 	}
@@ -88,7 +473,18 @@ func (l LinkMemoryStore) Write(_ context.Context, uuid, long string) (string, er
 	return s, nil
 }
 
-func (l LinkMemoryStore) readFile() error {
+// freeShortLink returns the first short representation of the address which isn't held by another link.
+func (l *LinkMemoryStore) freeShortLink(long string) (string, error) {
+	for n := 0; n < app.MaxShortLinkProbes; n++ {
+		s := app.AltShortLink(long, n)
+		if _, exist := l.links[s]; !exist {
+			return s, nil
+		}
+	}
+	return "", app.ErrNoFreeShortLink
+}
+
+func (l *LinkMemoryStore) readFile() error {
 	p := config.Config().FilePath
 
 	f, err := os.OpenFile(p, os.O_RDONLY|os.O_CREATE, 0644)
@@ -99,54 +495,119 @@ func (l LinkMemoryStore) readFile() error {
 	s := bufio.NewScanner(f)
 
 	for s.Scan() {
-		var link models.LinkJSON
-		err = json.Unmarshal(s.Bytes(), &link)
+		var r record
+		err = json.Unmarshal(s.Bytes(), &r)
 		if err != nil {
 			return err
 		}
 
-		l[link.Short] = models.LinkInfo{Long: link.Long, UUID: link.UUID, IsDeleted: link.IsDeleted}
+		info := r.Info()
+		info.PasswordHash = r.PasswordHash
+		l.setLink(r.Short, info)
+		if r.Revision != nil {
+			l.revisions[r.Short] = append(l.revisions[r.Short], models.Revision{
+				Number:    r.Revision.Number,
+				Author:    r.Revision.Author,
+				CreatedAt: r.Revision.CreatedAt,
+				Link:      info,
+			})
+		}
 	}
 	return nil
 }
 
-func Clear() error {
-	f := config.Config().FilePath
-	err := os.Remove(f)
+func (l *LinkMemoryStore) readJobsFile() error {
+	f, err := os.Open(jobsFilePath())
+	if os.IsNotExist(err) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	return nil
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, maxJobRecordSize)
+	for s.Scan() {
+		var r jobRecord
+		err = json.Unmarshal(s.Bytes(), &r)
+		if err != nil {
+			return err
+		}
+
+		r.Job.UID = r.UID
+		l.jobs[r.ID] = r.Job
+	}
+	return s.Err()
 }
 
-func writeFile(uuid, short, long string) error {
-	m := models.LinkJSON{
-		UUID:      uuid,
-		Short:     short,
-		Long:      long,
-		IsDeleted: false,
+func Clear() error {
+	for _, p := range []string{config.Config().FilePath, jobsFilePath(), clicksFilePath(), webhooksFilePath(), deliveriesFilePath()} {
+		err := os.Remove(p)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
+	return nil
+}
 
-	p := config.Config().FilePath
+// writeFile appends the current state of the link to the storage file; the last record of a link wins on read.
+func writeFile(short string, info models.LinkInfo) error {
+	return writeRecord(record{LinkJSON: info.JSON(short), PasswordHash: info.PasswordHash})
+}
 
-	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
-	if err != nil {
+// maxJobRecordSize bounds a line of the jobs file, which holds results of every job item.
+const maxJobRecordSize = 64 << 20
+
+// jobsFilePath returns path of the file jobs are kept in next to the links.
+func jobsFilePath() string {
+	return config.Config().FilePath + ".jobs"
+}
+
+func writeJobRecord(j models.Job) error {
+	return appendLines(jobsFilePath(), jobRecord{Job: j, UID: j.UID})
+}
+
+func writeRecord(m record) error {
+	return appendLines(config.Config().FilePath, m)
+}
+
+// rewriteLines replaces the file with JSON lines of the values; readers see either the old or the new file.
+func rewriteLines(p string, values ...interface{}) error {
+	tmp := p + ".tmp"
+	err := os.Remove(tmp)
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	defer f.Close()
 
-	data, err := json.Marshal(m)
+	err = appendLines(tmp, values...)
 	if err != nil {
 		return err
 	}
+	return os.Rename(tmp, p)
+}
 
-	data = append(data, '\n')
+// appendLines appends JSON lines of the values to the file.
+func appendLines(p string, values ...interface{}) error {
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
 	w := bufio.NewWriter(f)
+	for _, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
 
-	_, err = w.Write(data)
-	if err != nil {
-		return err
+		data = append(data, '\n')
+
+		_, err = w.Write(data)
+		if err != nil {
+			return err
+		}
 	}
 
 	err = w.Flush()
@@ -0,0 +1,54 @@
+package memory
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/config"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+func TestRewriteDeletedLink(t *testing.T) {
+	t.Setenv("FILE_STORAGE_PATH", filepath.Join(t.TempDir(), "links"))
+	config.SetTestConfig()
+
+	l, err := NewLinkMemoryStore()
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	const long = "https://go.dev"
+	short, err := l.Write(ctx, "alice", long, models.LinkOptions{})
+	require.NoError(t, err)
+	_, err = l.Update(ctx, "alice", short, func(info *models.LinkInfo) error {
+		info.Notes = "private"
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, l.AddClicks(ctx, []models.Click{{Short: short, Time: time.Now()}}))
+	require.NoError(t, l.Delete(ctx, "alice", short))
+
+	// the deleted link keeps its short code, its revisions and clicks aren't handed to another user
+	other, err := l.Write(ctx, "bob", long, models.LinkOptions{})
+	require.ErrorIs(t, err, app.ErrLinkAlreadyExists)
+	require.Equal(t, short, other)
+
+	info, err := l.Lookup(ctx, short)
+	require.NoError(t, err)
+	require.Equal(t, "alice", info.UUID)
+	require.True(t, info.IsDeleted)
+	revisions, err := l.Revisions(ctx, short)
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	require.Equal(t, "alice", revisions[1].Author)
+
+	// nor is the short code taken by a generated code of another address
+	require.Equal(t, short, app.AltShortLink(long, 0))
+	s, err := l.freeShortLink(long)
+	require.NoError(t, err)
+	require.NotEqual(t, short, s)
+}
@@ -0,0 +1,316 @@
+package memory
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/DrGermanius/Shortener/internal/app"
+	"github.com/DrGermanius/Shortener/internal/app/config"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+// webhookRecord is a line of the webhooks file; the last record of a webhook wins on read.
+type webhookRecord struct {
+	models.Webhook
+	UID     string `json:"uid"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// deliveryRecord is a line of the deliveries file; the last record of a delivery wins on read.
+type deliveryRecord struct {
+	models.Delivery
+	UID string `json:"uid"`
+}
+
+func (l *LinkMemoryStore) CreateWebhook(_ context.Context, w models.Webhook) error {
+	l.hooksMu.Lock()
+	defer l.hooksMu.Unlock()
+
+	l.webhooks[w.ID] = w
+	return appendLines(webhooksFilePath(), webhookRecord{Webhook: w, UID: w.UID})
+}
+
+// Webhooks returns webhooks of the user, oldest first.
+func (l *LinkMemoryStore) Webhooks(_ context.Context, uid string) ([]models.Webhook, error) {
+	l.hooksMu.RLock()
+	defer l.hooksMu.RUnlock()
+
+	var res []models.Webhook
+	for _, w := range l.webhooks {
+		if w.UID == uid {
+			res = append(res, w)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreatedAt.Before(res[j].CreatedAt)
+	})
+	return res, nil
+}
+
+func (l *LinkMemoryStore) Webhook(_ context.Context, id string) (models.Webhook, error) {
+	l.hooksMu.RLock()
+	defer l.hooksMu.RUnlock()
+
+	w, ok := l.webhooks[id]
+	if !ok {
+		return models.Webhook{}, app.ErrWebhookNotFound
+	}
+	return w, nil
+}
+
+// DeleteWebhook deletes the user's webhook with its deliveries.
+func (l *LinkMemoryStore) DeleteWebhook(_ context.Context, uid, id string) error {
+	l.hooksMu.Lock()
+	defer l.hooksMu.Unlock()
+
+	w, ok := l.webhooks[id]
+	if !ok || w.UID != uid {
+		return app.ErrWebhookNotFound
+	}
+
+	delete(l.webhooks, id)
+	for k, d := range l.deliveries {
+		if d.WebhookID == id {
+			delete(l.deliveries, k)
+		}
+	}
+	// deliveries of deleted webhooks are dropped on read
+	return appendLines(webhooksFilePath(), webhookRecord{Webhook: w, UID: w.UID, Deleted: true})
+}
+
+func (l *LinkMemoryStore) AddDeliveries(_ context.Context, deliveries []models.Delivery) error {
+	l.hooksMu.Lock()
+	defer l.hooksMu.Unlock()
+
+	var added []models.Delivery
+	for _, d := range deliveries {
+		if _, ok := l.webhooks[d.WebhookID]; ok {
+			added = append(added, d)
+		}
+	}
+	return l.saveDeliveries(added...)
+}
+
+// ClaimDeliveries returns up to limit pending deliveries due at now, the most overdue first,
+// and postpones their next attempts by lease, so they aren't claimed again while being delivered.
+func (l *LinkMemoryStore) ClaimDeliveries(_ context.Context, now time.Time, lease time.Duration, limit int) ([]models.Delivery, error) {
+	l.hooksMu.Lock()
+	defer l.hooksMu.Unlock()
+
+	var due []models.Delivery
+	for len(due) < limit && l.due.Len() > 0 && !l.due[0].at.After(now) {
+		a := heap.Pop(&l.due).(attempt)
+		// attempts of deliveries which were delivered, deleted or rescheduled since are stale
+		d, ok := l.deliveries[a.id]
+		if ok && d.Status == models.DeliveryPending && d.NextAttemptAt.Equal(a.at) {
+			due = append(due, d)
+		}
+	}
+
+	claimed := make([]models.Delivery, len(due))
+	for i, d := range due {
+		claimed[i] = d
+		d.NextAttemptAt = now.Add(lease)
+		due[i] = d
+	}
+	return claimed, l.saveDeliveries(due...)
+}
+
+func (l *LinkMemoryStore) SaveDelivery(_ context.Context, d models.Delivery) error {
+	l.hooksMu.Lock()
+	defer l.hooksMu.Unlock()
+
+	if _, ok := l.webhooks[d.WebhookID]; !ok {
+		return nil
+	}
+	return l.saveDeliveries(d)
+}
+
+// Deliveries returns up to limit deliveries of the user's webhook with the status, or any status if it's empty, newest first.
+func (l *LinkMemoryStore) Deliveries(_ context.Context, uid, webhookID, status string, limit int) ([]models.Delivery, error) {
+	l.hooksMu.RLock()
+	defer l.hooksMu.RUnlock()
+
+	var res []models.Delivery
+	for _, d := range l.deliveries {
+		if d.UID == uid && d.WebhookID == webhookID && (status == "" || d.Status == status) {
+			res = append(res, d)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].CreatedAt.Equal(res[j].CreatedAt) {
+			return res[i].CreatedAt.After(res[j].CreatedAt)
+		}
+		return res[i].ID < res[j].ID
+	})
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+// RetryDelivery queues the user's dead delivery again with a fresh number of attempts.
+func (l *LinkMemoryStore) RetryDelivery(_ context.Context, uid, id string) (models.Delivery, error) {
+	l.hooksMu.Lock()
+	defer l.hooksMu.Unlock()
+
+	d, ok := l.deliveries[id]
+	if !ok || d.UID != uid {
+		return models.Delivery{}, app.ErrDeliveryNotFound
+	}
+	if d.Status != models.DeliveryDead {
+		return models.Delivery{}, app.ErrDeliveryNotDead
+	}
+
+	d.Status, d.Attempts, d.NextAttemptAt = models.DeliveryPending, 0, time.Now()
+	return d, l.saveDeliveries(d)
+}
+
+func (l *LinkMemoryStore) saveDeliveries(deliveries ...models.Delivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	lines := make([]interface{}, len(deliveries))
+	for i, d := range deliveries {
+		l.setDelivery(d)
+		lines[i] = deliveryRecord{Delivery: d, UID: d.UID}
+	}
+	return appendLines(deliveriesFilePath(), lines...)
+}
+
+// setDelivery keeps the delivery and queues its next attempt if it's pending.
+func (l *LinkMemoryStore) setDelivery(d models.Delivery) {
+	l.deliveries[d.ID] = d
+	if d.Status == models.DeliveryPending {
+		heap.Push(&l.due, attempt{id: d.ID, at: d.NextAttemptAt})
+	}
+}
+
+// DeleteDeliveries deletes deliveries which were delivered or became dead before the time,
+// and rewrites the deliveries file without them.
+func (l *LinkMemoryStore) DeleteDeliveries(_ context.Context, finishedBefore time.Time) (int64, error) {
+	l.hooksMu.Lock()
+	defer l.hooksMu.Unlock()
+
+	var n int64
+	for id, d := range l.deliveries {
+		if d.Finished() && d.FinishedAt().Before(finishedBefore) {
+			delete(l.deliveries, id)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	lines := make([]interface{}, 0, len(l.deliveries))
+	for _, d := range l.deliveries {
+		lines = append(lines, deliveryRecord{Delivery: d, UID: d.UID})
+	}
+	return n, rewriteLines(deliveriesFilePath(), lines...)
+}
+
+// attempt is a due attempt of a pending delivery.
+type attempt struct {
+	id string
+	at time.Time
+}
+
+// attemptQueue is a min-heap of attempts by due time. A delivery may have stale attempts queued,
+// which are dropped when they come due.
+type attemptQueue []attempt
+
+func (q attemptQueue) Len() int            { return len(q) }
+func (q attemptQueue) Less(i, j int) bool  { return q[i].at.Before(q[j].at) }
+func (q attemptQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *attemptQueue) Push(x interface{}) { *q = append(*q, x.(attempt)) }
+
+func (q *attemptQueue) Pop() interface{} {
+	old := *q
+	a := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return a
+}
+
+func (l *LinkMemoryStore) readWebhooksFiles() error {
+	err := readLines(webhooksFilePath(), func(b []byte) error {
+		var r webhookRecord
+		err := json.Unmarshal(b, &r)
+		if err != nil {
+			return err
+		}
+
+		if r.Deleted {
+			delete(l.webhooks, r.ID)
+			return nil
+		}
+		r.Webhook.UID = r.UID
+		l.webhooks[r.ID] = r.Webhook
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = readLines(deliveriesFilePath(), func(b []byte) error {
+		var r deliveryRecord
+		err := json.Unmarshal(b, &r)
+		if err != nil {
+			return err
+		}
+
+		r.Delivery.UID = r.UID
+		l.deliveries[r.ID] = r.Delivery
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for id, d := range l.deliveries {
+		if _, ok := l.webhooks[d.WebhookID]; !ok {
+			delete(l.deliveries, id)
+			continue
+		}
+		l.setDelivery(d)
+	}
+	return nil
+}
+
+// readLines calls fn for every line of the file; a missing file has no lines.
+func readLines(p string, fn func([]byte) error) error {
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, maxJobRecordSize)
+	for s.Scan() {
+		err = fn(s.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// webhooksFilePath returns path of the file webhooks are kept in next to the links.
+func webhooksFilePath() string {
+	return config.Config().FilePath + ".webhooks"
+}
+
+// deliveriesFilePath returns path of the file webhook deliveries are kept in next to the links.
+func deliveriesFilePath() string {
+	return config.Config().FilePath + ".deliveries"
+}
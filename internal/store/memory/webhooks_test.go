@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/DrGermanius/Shortener/internal/app/config"
+	"github.com/DrGermanius/Shortener/internal/app/models"
+)
+
+func TestDeliveryQueue(t *testing.T) {
+	t.Setenv("FILE_STORAGE_PATH", filepath.Join(t.TempDir(), "links"))
+	config.SetTestConfig()
+
+	l, err := NewLinkMemoryStore()
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, l.CreateWebhook(ctx, models.Webhook{ID: "w", UID: "user", URL: "https://hooks.example", CreatedAt: now}))
+	delivery := func(id string, due time.Duration) models.Delivery {
+		return models.Delivery{ID: id, WebhookID: "w", UID: "user", Status: models.DeliveryPending,
+			NextAttemptAt: now.Add(due), CreatedAt: now}
+	}
+	require.NoError(t, l.AddDeliveries(ctx, []models.Delivery{
+		delivery("later", 90*time.Second),
+		delivery("second", -time.Minute),
+		delivery("first", -time.Hour),
+		delivery("third", 0),
+	}))
+
+	ids := func(deliveries []models.Delivery) []string {
+		var res []string
+		for _, d := range deliveries {
+			res = append(res, d.ID)
+		}
+		return res
+	}
+
+	claimed, err := l.ClaimDeliveries(ctx, now, time.Minute, 2)
+	require.NoError(t, err)
+	require.Equal(t, []string{"first", "second"}, ids(claimed))
+
+	// the failed attempt of the first delivery is rescheduled, the second one is delivered
+	failed := claimed[0]
+	failed.Attempts, failed.NextAttemptAt = 1, now.Add(30*time.Second)
+	require.NoError(t, l.SaveDelivery(ctx, failed))
+	delivered := claimed[1]
+	delivered.Status, delivered.DeliveredAt = models.DeliveryDelivered, &now
+	require.NoError(t, l.SaveDelivery(ctx, delivered))
+
+	claimed, err = l.ClaimDeliveries(ctx, now, time.Minute, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"third"}, ids(claimed))
+
+	// leases of the claimed deliveries are over, but their stale attempts aren't claimed again
+	claimed, err = l.ClaimDeliveries(ctx, now.Add(2*time.Minute), time.Minute, 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"first", "third", "later"}, ids(claimed))
+
+	n, err := l.DeleteDeliveries(ctx, now.Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+
+	reopened, err := NewLinkMemoryStore()
+	require.NoError(t, err)
+	all, err := reopened.Deliveries(ctx, "user", "w", "", 10)
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	claimed, err = reopened.ClaimDeliveries(ctx, now.Add(time.Hour), time.Minute, 10)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"first", "later", "third"}, ids(claimed))
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -11,11 +12,42 @@ import (
 )
 
 type LinksStorager interface {
-	Get(context.Context, string) (string, error)
-	GetByUserID(context.Context, string) ([]models.LinkJSON, error)
-	Write(context.Context, string, string) (string, error)
+	Get(context.Context, string) (models.LinkInfo, error)
+	Lookup(context.Context, string) (models.LinkInfo, error)
+	FindLink(ctx context.Context, long string) (string, error)
+	Update(ctx context.Context, uid string, short string, change func(*models.LinkInfo) error) (models.LinkInfo, error)
+	Revisions(ctx context.Context, short string) ([]models.Revision, error)
+	ConsumeClick(context.Context, string) error
+	GetByUserID(ctx context.Context, uid string, filter models.LinkFilter) ([]models.LinkJSON, error)
+	EachByUserID(ctx context.Context, uid string, filter models.LinkFilter, fn func(models.LinkJSON) error) error
+	Write(context.Context, string, string, models.LinkOptions) (string, error)
 	BatchWrite(context.Context, string, []models.BatchOriginal) ([]string, error)
 	Delete(ctx context.Context, uid string, links string) error
+	DeleteExpired(context.Context) (int64, error)
+	HealthTargets(ctx context.Context, checkedBefore time.Time, limit int) ([]models.HealthTarget, error)
+	SetHealth(ctx context.Context, short string, health models.LinkHealth) error
+	SetMetadata(ctx context.Context, short, long string, m models.LinkMetadata) error
+	AddClicks(ctx context.Context, clicks []models.Click) error
+	ClickStats(ctx context.Context, short string) (models.LinkStats, error)
+	InternalStats(ctx context.Context) (models.InternalStats, error)
+	VisitorSketches(ctx context.Context, short string, from, to time.Time) ([]models.VisitorSketch, error)
+	ClickRollups(ctx context.Context, short, interval string, from, to time.Time) ([]models.ClickRollup, error)
+	DeleteRollups(ctx context.Context, interval string, before time.Time) (int64, error)
+	DeleteVisitorSketches(ctx context.Context, before time.Time) (int64, error)
+	CreateWebhook(ctx context.Context, w models.Webhook) error
+	Webhooks(ctx context.Context, uid string) ([]models.Webhook, error)
+	Webhook(ctx context.Context, id string) (models.Webhook, error)
+	DeleteWebhook(ctx context.Context, uid, id string) error
+	AddDeliveries(ctx context.Context, deliveries []models.Delivery) error
+	ClaimDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]models.Delivery, error)
+	SaveDelivery(ctx context.Context, d models.Delivery) error
+	Deliveries(ctx context.Context, uid, webhookID, status string, limit int) ([]models.Delivery, error)
+	RetryDelivery(ctx context.Context, uid, id string) (models.Delivery, error)
+	DeleteDeliveries(ctx context.Context, finishedBefore time.Time) (int64, error)
+	SaveJob(ctx context.Context, job models.Job) error
+	GetJob(ctx context.Context, id string) (models.Job, error)
+	InterruptJobs(ctx context.Context) (int64, error)
+	DeleteJobs(ctx context.Context, finishedBefore time.Time) (int64, error)
 	Ping(context.Context) bool
 }
 